@@ -10,3 +10,10 @@ const (
 	// 默认topK
 	DefaultTopK = 3
 )
+
+const (
+	// 导入报告目录 相对于程序目录
+	ReportDir = "reports"
+	// 异步任务文件目录 相对于程序目录
+	JobDir = "jobs"
+)
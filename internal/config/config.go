@@ -16,6 +16,19 @@ type Config struct {
 	Milvus    *MilvusConfig    `toml:"milvus"`
 	LLM       *LLMConfig       `toml:"llm"`
 	Embedding *EmbeddingConfig `toml:"embedding"`
+	Document  *DocumentConfig  `toml:"document"`
+	Job       *JobConfig       `toml:"job"`
+	Sync      *SyncConfig      `toml:"sync"`
+	Prompt    *PromptConfig    `toml:"prompt"`
+	Search    *SearchConfig    `toml:"search"`
+	Cache     *CacheConfig     `toml:"cache"`
+	Usage     *UsageConfig     `toml:"usage"`
+	Gap       *GapConfig       `toml:"gap"`
+	Conflict  *ConflictConfig  `toml:"conflict"`
+
+	Conversation *ConversationConfig `toml:"conversation"`
+
+	KnowledgeBases map[string]*KnowledgeBaseConfig `toml:"knowledge_bases"` // 知识库配置 key为知识库名称
 }
 
 // 关系型数据库配置
@@ -46,6 +59,46 @@ type LLMConfig struct {
 	// TopK        int     `toml:"top_k"`
 	FrequencyPenalty float64 `toml:"frequency_penalty"`
 	PresencePenalty  float64 `toml:"presence_penalty"`
+	LegacyMaxTokens  bool    `toml:"legacy_max_tokens"` // 未配置endpoints时使用 同LLMEndpointConfig
+
+	Limits *LLMLimitConfig `toml:"limits"` // 请求覆盖生成参数的范围
+
+	Endpoints []*LLMEndpointConfig `toml:"endpoints"` // 按顺序故障转移的模型服务 为空时使用上面的base_url、api_key、model
+	Retry     *LLMRetryConfig      `toml:"retry"`
+	Breaker   *LLMBreakerConfig    `toml:"breaker"`
+}
+
+// 模型服务
+type LLMEndpointConfig struct {
+	Name           string `toml:"name"`
+	Provider       string `toml:"provider"` // openai兼容接口 ollama原生接口 默认openai
+	BaseUrl        string `toml:"base_url"`
+	ApiKey         string `toml:"api_key"`
+	Model          string `toml:"model"`
+	Timeout        int    `toml:"timeout"`         // 单次调用超时(秒) 包含流式输出 默认120
+	ConnectTimeout int    `toml:"connect_timeout"` // 建立连接超时(秒) 默认10
+	// 兼容接口不支持max_completion_tokens时开启 改为传递max_tokens
+	LegacyMaxTokens bool `toml:"legacy_max_tokens"`
+}
+
+// 单个服务失败重试 为0时使用默认值
+type LLMRetryConfig struct {
+	MaxAttempts int `toml:"max_attempts"` // 每个服务的最多调用次数 默认2
+	Backoff     int `toml:"backoff"`      // 首次重试等待(毫秒) 之后每次翻倍 默认500
+	MaxBackoff  int `toml:"max_backoff"`  // 最长重试等待(毫秒) 默认5000
+}
+
+// 熔断 为0时使用默认值
+type LLMBreakerConfig struct {
+	Failures int `toml:"failures"` // 连续失败该次数后熔断 默认3
+	Cooldown int `toml:"cooldown"` // 熔断后等待(秒) 之后放行一次试探调用 默认30
+}
+
+// 请求覆盖生成参数的范围 为0时使用默认范围
+type LLMLimitConfig struct {
+	MaxTemperature float64 `toml:"max_temperature"` // 默认2
+	MaxTokens      int     `toml:"max_tokens"`      // 默认为max_tokens配置
+	MaxPenalty     float64 `toml:"max_penalty"`     // 惩罚项绝对值上限 默认2
 }
 
 // 向量配置
@@ -55,6 +108,92 @@ type EmbeddingConfig struct {
 	Model   string `toml:"model"`
 }
 
+// 文档切片配置
+type DocumentConfig struct {
+	ChunkSize    int `toml:"chunk_size"`    // 切片长度(字符数)
+	ChunkOverlap int `toml:"chunk_overlap"` // 切片重叠长度
+}
+
+// 异步任务配置
+type JobConfig struct {
+	Workers int `toml:"workers"` // 并发执行任务数
+}
+
+// 目录同步配置
+type SyncConfig struct {
+	Dirs     []string `toml:"dirs"`     // 监听的目录 包含子目录
+	Exts     []string `toml:"exts"`     // 同步的文件后缀
+	Debounce int      `toml:"debounce"` // 文件变化合并间隔(毫秒)
+}
+
+// 回答提示词模板 使用go template语法 为空时使用默认模板
+type PromptConfig struct {
+	System   string `toml:"system"`   // 系统提示词
+	Document string `toml:"document"` // 单条知识格式
+	Question string `toml:"question"` // 问题模板 包含全部知识和问题
+}
+
+// 语义答案缓存配置
+type CacheConfig struct {
+	Enabled    bool    `toml:"enabled"`
+	Threshold  float32 `toml:"threshold"`   // 问题相似度达到该值时返回缓存答案 相似度=1/(1+L2距离)
+	TTL        int     `toml:"ttl"`         // 缓存有效期(秒) 为0时不过期
+	MaxEntries int     `toml:"max_entries"` // 最多缓存条数 超出时淘汰最早的缓存
+}
+
+// 用量统计配置
+type UsageConfig struct {
+	Enabled  bool                `toml:"enabled"`  // 保存每次查询的用量记录
+	Currency string              `toml:"currency"` // 费用单位 仅用于展示
+	Prices   []*ModelPriceConfig `toml:"prices"`
+}
+
+// 模型价格 每千token
+type ModelPriceConfig struct {
+	Model      string  `toml:"model"`
+	Prompt     float64 `toml:"prompt"`     // 输入token价格
+	Completion float64 `toml:"completion"` // 输出token价格
+}
+
+// 知识缺口分析配置 相似度=1/(1+L2距离)
+type GapConfig struct {
+	MinSimilarity    float32 `toml:"min_similarity"`    // 检索到的最高相似度低于该值的查询视为未回答
+	ClusterThreshold float32 `toml:"cluster_threshold"` // 问题与主题中心的相似度达到该值时归为同一主题
+	MaxQuestions     int     `toml:"max_questions"`     // 单次分析最多的查询数 取最近的查询
+	MaxTopics        int     `toml:"max_topics"`        // 单次分析最多输出的主题数 按查询次数从多到少
+}
+
+// 矛盾检测配置 相似度=1/(1+L2距离)
+type ConflictConfig struct {
+	Threshold float32 `toml:"threshold"` // 不同分组的知识相似度达到该值时由模型判断是否矛盾
+	Neighbors int     `toml:"neighbors"` // 每条知识检索的相似知识数
+	OnSave    bool    `toml:"on_save"`   // 保存或删除知识后检查变化的分组
+	Debounce  int     `toml:"debounce"`  // 保存后合并检查的间隔(秒)
+}
+
+// 多轮对话配置
+type ConversationConfig struct {
+	HistoryTokens int `toml:"history_tokens"` // 历史消息token上限 超出后将较早的对话总结为摘要
+	KeepTurns     int `toml:"keep_turns"`     // 总结时保留的最近对话轮数
+}
+
+// 检索问答配置
+type SearchConfig struct {
+	DirectThreshold float32  `toml:"direct_threshold"` // 问答相似度达到该值时直接返回已存答案 为0时不启用 知识库配置小于0时关闭
+	ContextTokens   int      `toml:"context_tokens"`   // 提示词中知识的token上限
+	Overflow        string   `toml:"overflow"`         // 知识超出上限时的处理方式 trim截断 summarize先总结再截断
+	Chain           string   `toml:"chain"`            // 默认回答方式 stuff map_reduce refine map_rerank
+	Strategies      []string `toml:"strategies"`       // 检索前的问题处理 rewrite改写 multi_query多问题扩展 hyde假设答案
+	SubQueries      int      `toml:"sub_queries"`      // multi_query生成的问题数
+	Group           *bool    `toml:"group"`            // 同一问答分组只占一条检索结果 默认启用
+}
+
+// 知识库配置 查询时通过knowledge_base指定 未配置的项使用全局配置
+type KnowledgeBaseConfig struct {
+	Prompt *PromptConfig `toml:"prompt"`
+	Search *SearchConfig `toml:"search"`
+}
+
 // NewConfig 初始化一个server配置文件对象
 func NewConfig(path string) (cfgChan chan *Config, err error) {
 	if path == "" {
@@ -66,7 +205,7 @@ func NewConfig(path string) (cfgChan chan *Config, err error) {
 	if err != nil {
 		return
 	}
-	go watcher(cfgChan, path)
+	newWatcher(cfgChan, path)
 	go func() {
 		cfgChan <- cfg
 	}()
@@ -1,48 +1,38 @@
 package config
 
 import (
+	"ai-knowledge/internal/watcher"
 	"log"
-
-	"github.com/fsnotify/fsnotify"
+	"path/filepath"
 )
 
-// Watcher 监听配置文件变化
-func watcher(cfgChan chan *Config, path string) {
-	watcher, err := fsnotify.NewWatcher()
+// 监听配置文件变化 监听所在目录 编辑器先写临时文件再改名保存时也能收到变化
+func newWatcher(cfgChan chan *Config, path string) {
+	path, err := filepath.Abs(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer watcher.Close()
-
-	done := make(chan bool)
-	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				log.Println("event:", event)
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					log.Println("配置文件发生变化")
-					cfg, err := readConfFile(path)
-					if err != nil {
-						return
-					}
-					cfgChan <- cfg
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Println("error:", err)
+	w, err := watcher.New(watcher.Options{
+		Match: func(name string) bool {
+			return filepath.Clean(name) == path
+		},
+		OnChange: func(name string) {
+			log.Println("配置文件发生变化")
+			cfg, err := readConfFile(path)
+			if err != nil {
+				log.Println("读取配置文件错误:", err)
+				return
 			}
-		}
-	}()
-
-	err = watcher.Add(path)
+			cfgChan <- cfg
+		},
+		OnError: func(err error) {
+			log.Println("error:", err)
+		},
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
-	<-done
+	if err = w.Add(filepath.Dir(path)); err != nil {
+		log.Fatal(err)
+	}
 }
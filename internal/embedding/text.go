@@ -4,6 +4,7 @@ import (
 	"ai-knowledge/internal/config"
 	"context"
 	"log"
+	"net/http"
 
 	"github.com/tmc/langchaingo/llms/openai"
 )
@@ -24,8 +25,15 @@ func InitTextEmbeddingOperator(cfg *config.EmbeddingConfig) {
 		return
 	}
 	// openai格式客户的
+	client := &http.Client{
+		Transport: &usageTransport{
+			base:  http.DefaultTransport,
+			model: cfg.Model,
+		},
+	}
 	llm, err := openai.New(openai.WithBaseURL(cfg.BaseUrl),
-		openai.WithToken(cfg.ApiKey), openai.WithEmbeddingModel(cfg.Model))
+		openai.WithToken(cfg.ApiKey), openai.WithEmbeddingModel(cfg.Model),
+		openai.WithHTTPClient(client))
 	if err != nil {
 		log.Panicln("init llm failed, err:", err)
 		return
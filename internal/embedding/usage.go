@@ -0,0 +1,76 @@
+package embedding
+
+import (
+	"ai-knowledge/internal/llm"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 从向量化接口响应中收集token用量 接口未返回用量时按输入文本估算
+type usageTransport struct {
+	base  http.RoundTripper
+	model string
+}
+
+func (t *usageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil || !strings.HasSuffix(req.URL.Path, "/embeddings") {
+		return t.base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	result := struct {
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}{}
+	tokens := 0
+	if json.Unmarshal(respBody, &result) == nil {
+		tokens = result.Usage.PromptTokens
+	}
+	if tokens == 0 {
+		tokens = estimateInputTokens(body)
+	}
+	llm.AddEmbeddingUsage(req.Context(), t.model, tokens)
+	return resp, nil
+}
+
+// 估算请求中输入文本的token数
+func estimateInputTokens(body []byte) int {
+	payload := struct {
+		Input any `json:"input"`
+	}{}
+	if json.Unmarshal(body, &payload) != nil {
+		return 0
+	}
+	tokens := 0
+	switch input := payload.Input.(type) {
+	case string:
+		tokens = llm.EstimateTokens(input)
+	case []any:
+		for _, v := range input {
+			if text, ok := v.(string); ok {
+				tokens += llm.EstimateTokens(text)
+			}
+		}
+	}
+	return tokens
+}
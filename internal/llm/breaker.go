@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 熔断状态
+	BreakerClosed   = "closed"    // 正常
+	BreakerOpen     = "open"      // 熔断中 不调用
+	BreakerHalfOpen = "half_open" // 冷却结束 放行一次试探调用
+
+	defaultBreakerFailures = 3
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// 熔断器 连续失败达到阈值后熔断 冷却后放行一次试探调用 成功则恢复
+type breaker struct {
+	mu       sync.Mutex
+	failures int // 熔断阈值
+	cooldown time.Duration
+
+	state    string
+	count    int // 连续失败次数
+	openedAt time.Time
+	probing  bool // 半开状态下已放行试探调用
+}
+
+func newBreaker(failures int, cooldown time.Duration) *breaker {
+	if failures <= 0 {
+		failures = defaultBreakerFailures
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &breaker{
+		failures: failures,
+		cooldown: cooldown,
+		state:    BreakerClosed,
+	}
+}
+
+// 是否允许调用
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// 调用成功
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.count = 0
+	b.probing = false
+}
+
+// 调用失败
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.count++
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.count >= b.failures {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// 放弃已放行的调用 调用方取消时不计入成功或失败
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// 当前状态、连续失败次数、熔断结束时间
+func (b *breaker) status() (string, int, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var openUntil time.Time
+	if b.state == BreakerOpen {
+		openUntil = b.openedAt.Add(b.cooldown)
+	}
+	return b.state, b.count, openUntil
+}
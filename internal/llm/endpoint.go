@@ -0,0 +1,201 @@
+package llm
+
+import (
+	"ai-knowledge/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	defaultEndpointName   = "default"
+	defaultTimeout        = 120 * time.Second
+	defaultConnectTimeout = 10 * time.Second
+	defaultMaxAttempts    = 2
+	defaultBackoff        = 500 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+var (
+	ErrNoAvailableEndpoint = errors.New("no available llm endpoint")
+)
+
+// 单个模型服务
+type endpoint struct {
+	name     string
+	provider string
+	model    string
+	timeout  time.Duration
+	client   Provider
+	breaker  *breaker
+
+	mu          sync.Mutex
+	calls       int64
+	errors      int64
+	lastError   string
+	lastErrorAt int64
+	lastLatency int64
+}
+
+// 服务状态
+type EndpointHealth struct {
+	Name        string `json:"name"`
+	Provider    string `json:"provider"`
+	Model       string `json:"model"`
+	State       string `json:"state"`         // closed正常 open熔断中 half_open试探中
+	Failures    int    `json:"failures"`      // 连续失败次数
+	OpenUntil   int64  `json:"open_until"`    // 熔断结束时间
+	Calls       int64  `json:"calls"`         // 调用次数 包含重试
+	Errors      int64  `json:"errors"`        // 失败次数
+	LastError   string `json:"last_error"`    // 最近一次错误
+	LastErrorAt int64  `json:"last_error_at"` // 最近一次错误时间
+	LastLatency int64  `json:"last_latency"`  // 最近一次成功调用耗时(毫秒)
+}
+
+// 重试配置
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+func newRetryPolicy(cfg *config.LLMRetryConfig) *retryPolicy {
+	p := &retryPolicy{
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+	}
+	if cfg != nil {
+		if cfg.MaxAttempts > 0 {
+			p.maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.Backoff > 0 {
+			p.backoff = time.Duration(cfg.Backoff) * time.Millisecond
+		}
+		if cfg.MaxBackoff > 0 {
+			p.maxBackoff = time.Duration(cfg.MaxBackoff) * time.Millisecond
+		}
+	}
+	return p
+}
+
+// 第attempt次重试前的等待 指数增长并加入抖动
+func (p *retryPolicy) wait(attempt int) time.Duration {
+	d := min(p.backoff<<(attempt-1), p.maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// 配置的服务列表 未配置时使用[llm]中的单个服务
+func endpointConfigs(cfg *config.LLMConfig) []*config.LLMEndpointConfig {
+	if len(cfg.Endpoints) > 0 {
+		return cfg.Endpoints
+	}
+	return []*config.LLMEndpointConfig{
+		{
+			Name:     defaultEndpointName,
+			Provider: ProviderOpenAI,
+			BaseUrl:  cfg.BaseUrl,
+			ApiKey:   cfg.ApiKey,
+			Model:    cfg.Model,
+
+			LegacyMaxTokens: cfg.LegacyMaxTokens,
+		},
+	}
+}
+
+func newEndpoint(cfg *config.LLMEndpointConfig, breakerCfg *config.LLMBreakerConfig, defaults *Params) (*endpoint, error) {
+	timeout, connectTimeout := defaultTimeout, defaultConnectTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	if cfg.ConnectTimeout > 0 {
+		connectTimeout = time.Duration(cfg.ConnectTimeout) * time.Second
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	client := &http.Client{
+		Transport: &paramsTransport{
+			base:            transport,
+			defaults:        defaults,
+			legacyMaxTokens: cfg.LegacyMaxTokens,
+		},
+	}
+	provider, err := newProvider(cfg, client)
+	if err != nil {
+		return nil, err
+	}
+	failures, cooldown := 0, time.Duration(0)
+	if breakerCfg != nil {
+		failures, cooldown = breakerCfg.Failures, time.Duration(breakerCfg.Cooldown)*time.Second
+	}
+	ep := &endpoint{
+		name:     cfg.Name,
+		provider: cfg.Provider,
+		model:    cfg.Model,
+		timeout:  timeout,
+		client:   provider,
+		breaker:  newBreaker(failures, cooldown),
+	}
+	if ep.provider == "" {
+		ep.provider = ProviderOpenAI
+	}
+	if ep.name == "" {
+		ep.name = fmt.Sprintf("%s:%s", ep.provider, ep.model)
+	}
+	return ep, nil
+}
+
+// 单次调用
+func (e *endpoint) generate(ctx context.Context, messages []llms.MessageContent, options []llms.CallOption) (*llms.ContentResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := e.client.GenerateContent(ctx, messages, options...)
+	if err == nil && (resp == nil || len(resp.Choices) == 0) {
+		err = errors.New("empty response")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls++
+	if err != nil {
+		e.errors++
+		e.lastError = err.Error()
+		e.lastErrorAt = time.Now().Unix()
+		return nil, err
+	}
+	e.lastLatency = time.Since(start).Milliseconds()
+	return resp, nil
+}
+
+func (e *endpoint) health() *EndpointHealth {
+	state, failures, openUntil := e.breaker.status()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h := &EndpointHealth{
+		Name:        e.name,
+		Provider:    e.provider,
+		Model:       e.model,
+		State:       state,
+		Failures:    failures,
+		Calls:       e.calls,
+		Errors:      e.errors,
+		LastError:   e.lastError,
+		LastErrorAt: e.lastErrorAt,
+		LastLatency: e.lastLatency,
+	}
+	if !openUntil.IsZero() {
+		h.OpenUntil = openUntil.Unix()
+	}
+	return h
+}
@@ -2,22 +2,23 @@ package llm
 
 import (
 	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/logger"
 	"context"
 	"log"
+	"time"
 
-	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/openai"
-	"github.com/tmc/langchaingo/schema"
 )
 
 var (
 	LLMHandler *LLMOperator
 )
 
-// 大模型相关
+// 大模型相关 按顺序调用配置的服务 失败时重试 仍失败时转移到下一个服务
 type LLMOperator struct {
-	llm *openai.LLM
+	endpoints []*endpoint
+	retry     *retryPolicy
+	limits    *paramsLimits
 }
 
 func InitLLM(cfg *config.LLMConfig) {
@@ -25,38 +26,150 @@ func InitLLM(cfg *config.LLMConfig) {
 		log.Panicln("llm config is nil")
 		return
 	}
-	llm, err := openai.New(openai.WithBaseURL(cfg.BaseUrl),
-		openai.WithToken(cfg.ApiKey), openai.WithModel(cfg.Model))
-	if err != nil {
-		log.Panicln("init llm failed, err:", err)
-		return
+	limits := newParamsLimits(cfg)
+	endpoints := make([]*endpoint, 0)
+	for _, v := range endpointConfigs(cfg) {
+		ep, err := newEndpoint(v, cfg.Breaker, &limits.defaults)
+		if err != nil {
+			log.Panicln("init llm failed, err:", err)
+			return
+		}
+		endpoints = append(endpoints, ep)
 	}
 	LLMHandler = &LLMOperator{
-		llm: llm,
+		endpoints: endpoints,
+		retry:     newRetryPolicy(cfg.Retry),
+		limits:    limits,
+	}
+}
+
+// ResolveParams 合并请求覆盖的生成参数 超出配置范围时返回ErrParamsOutOfRange
+func (l *LLMOperator) ResolveParams(override *ParamsOverride) (*Params, error) {
+	return l.limits.resolve(override)
+}
+
+// Health 各服务状态
+func (l *LLMOperator) Health() []*EndpointHealth {
+	list := make([]*EndpointHealth, 0, len(l.endpoints))
+	for _, v := range l.endpoints {
+		list = append(list, v.health())
 	}
+	return list
+}
+
+// 生成参数调用选项 ctx未指定参数时使用配置的默认值 options中的选项优先
+func (l *LLMOperator) options(ctx context.Context, options []llms.CallOption) []llms.CallOption {
+	params := paramsFromCtx(ctx)
+	if params == nil {
+		params = &l.limits.defaults
+	}
+	return append(params.options(), options...)
 }
 
 func (l *LLMOperator) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
-	return l.llm.Call(ctx, prompt, options...)
-}
-
-// LoadStuffQA
-func (l *LLMOperator) LoadStuffQA(ctx context.Context, question string, documents []schema.Document) (any, error) {
-	stuffQAChain := chains.LoadStuffQA(l.llm)
-	// docs := make([]schema.Document, 0)
-	// for _, document := range documents {
-	// 	docs = append(docs, schema.Document{
-	// 		PageContent: document,
-	// 		Score:       0,
-	// 	})
-	// }
-	answer, err := chains.Call(context.Background(), stuffQAChain, map[string]any{
-		"input_documents": documents,
-		"question":        question,
-	})
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)}
+	resp, err := l.generate(ctx, messages, options)
 	if err != nil {
 		return "", err
 	}
+	return resp.Choices[0].Content, nil
+}
 
-	return answer["text"], nil
+// 对话角色
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// 对话消息
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Chat 使用系统提示词、历史消息和用户消息生成回答 options可传入llms.WithStreamingFunc流式输出
+func (l *LLMOperator) Chat(ctx context.Context, system string, history []*Message, prompt string, options ...llms.CallOption) (string, error) {
+	messages := make([]llms.MessageContent, 0, len(history)+2)
+	if system != "" {
+		messages = append(messages, llms.TextParts(llms.ChatMessageTypeSystem, system))
+	}
+	for _, v := range history {
+		typ := llms.ChatMessageTypeHuman
+		if v.Role == RoleAssistant {
+			typ = llms.ChatMessageTypeAI
+		}
+		messages = append(messages, llms.TextParts(typ, v.Content))
+	}
+	messages = append(messages, llms.TextParts(llms.ChatMessageTypeHuman, prompt))
+	resp, err := l.generate(ctx, messages, options)
+	if err != nil {
+		return "", err
+	}
+	return resp.Choices[0].Content, nil
+}
+
+// 按顺序调用服务 熔断中的服务跳过
+// 流式输出已经开始后不再重试 避免重复输出
+func (l *LLMOperator) generate(ctx context.Context, messages []llms.MessageContent, options []llms.CallOption) (*llms.ContentResponse, error) {
+	options = l.options(ctx, options)
+	opts := new(llms.CallOptions)
+	for _, v := range options {
+		v(opts)
+	}
+	streamed := false
+	if fn := opts.StreamingFunc; fn != nil {
+		options = append(options, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			if len(chunk) > 0 {
+				streamed = true
+			}
+			return fn(ctx, chunk)
+		}))
+	}
+	usage := usageFromCtx(ctx)
+
+	var lastErr error
+	tried := 0
+	for _, ep := range l.endpoints {
+		if !ep.breaker.allow() {
+			continue
+		}
+		if tried > 0 && usage != nil {
+			usage.failover()
+		}
+		tried++
+		for attempt := range l.retry.maxAttempts {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					ep.breaker.release()
+					return nil, ctx.Err()
+				case <-time.After(l.retry.wait(attempt)):
+				}
+			}
+			resp, err := ep.generate(ctx, messages, options)
+			if err == nil {
+				ep.breaker.success()
+				if usage != nil {
+					usage.add(ep, resp.Choices[0].GenerationInfo)
+				}
+				return resp, nil
+			}
+			// 调用方取消不计入服务失败
+			if ctx.Err() != nil {
+				ep.breaker.release()
+				return nil, err
+			}
+			lastErr = err
+			logger.Logger.Warnw("调用大模型失败", "err", err, "endpoint", ep.name, "attempt", attempt+1)
+			if streamed {
+				ep.breaker.failure()
+				return nil, err
+			}
+		}
+		ep.breaker.failure()
+	}
+	if lastErr == nil {
+		return nil, ErrNoAvailableEndpoint
+	}
+	return nil, lastErr
 }
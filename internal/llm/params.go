@@ -0,0 +1,191 @@
+package llm
+
+import (
+	"ai-knowledge/internal/config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	// 默认覆盖范围
+	defaultMaxTemperature = 2.0
+	defaultMaxPenalty     = 2.0
+)
+
+var (
+	ErrParamsOutOfRange = errors.New("llm params out of range")
+)
+
+type paramsCtxKey struct{}
+
+// 生成参数
+type Params struct {
+	Temperature      float64 `json:"temperature"`
+	MaxTokens        int     `json:"max_tokens"`
+	TopP             float64 `json:"top_p"`
+	FrequencyPenalty float64 `json:"frequency_penalty"`
+	PresencePenalty  float64 `json:"presence_penalty"`
+}
+
+// 请求覆盖的生成参数 为空的项使用配置的默认值
+type ParamsOverride struct {
+	Temperature      *float64 `json:"temperature"`
+	MaxTokens        *int     `json:"max_tokens"`
+	TopP             *float64 `json:"top_p"`
+	FrequencyPenalty *float64 `json:"frequency_penalty"`
+	PresencePenalty  *float64 `json:"presence_penalty"`
+}
+
+// 配置中的默认参数及覆盖范围
+type paramsLimits struct {
+	defaults       Params
+	maxTemperature float64
+	maxTokens      int
+	maxPenalty     float64
+}
+
+func newParamsLimits(cfg *config.LLMConfig) *paramsLimits {
+	limits := &paramsLimits{
+		defaults: Params{
+			Temperature:      cfg.Temperature,
+			MaxTokens:        cfg.MaxTokens,
+			TopP:             cfg.TopP,
+			FrequencyPenalty: cfg.FrequencyPenalty,
+			PresencePenalty:  cfg.PresencePenalty,
+		},
+		maxTemperature: defaultMaxTemperature,
+		maxTokens:      cfg.MaxTokens,
+		maxPenalty:     defaultMaxPenalty,
+	}
+	if cfg.Limits != nil {
+		if cfg.Limits.MaxTemperature > 0 {
+			limits.maxTemperature = cfg.Limits.MaxTemperature
+		}
+		if cfg.Limits.MaxTokens > 0 {
+			limits.maxTokens = cfg.Limits.MaxTokens
+		}
+		if cfg.Limits.MaxPenalty > 0 {
+			limits.maxPenalty = cfg.Limits.MaxPenalty
+		}
+	}
+	return limits
+}
+
+// 合并覆盖参数并检查范围
+func (l *paramsLimits) resolve(override *ParamsOverride) (*Params, error) {
+	params := l.defaults
+	if override == nil {
+		return &params, nil
+	}
+	if v := override.Temperature; v != nil {
+		if *v < 0 || *v > l.maxTemperature {
+			return nil, fmt.Errorf("%w: temperature需在0~%g之间", ErrParamsOutOfRange, l.maxTemperature)
+		}
+		params.Temperature = *v
+	}
+	if v := override.MaxTokens; v != nil {
+		if *v <= 0 || (l.maxTokens > 0 && *v > l.maxTokens) {
+			return nil, fmt.Errorf("%w: max_tokens需在1~%d之间", ErrParamsOutOfRange, l.maxTokens)
+		}
+		params.MaxTokens = *v
+	}
+	if v := override.TopP; v != nil {
+		if *v <= 0 || *v > 1 {
+			return nil, fmt.Errorf("%w: top_p需在0~1之间", ErrParamsOutOfRange)
+		}
+		params.TopP = *v
+	}
+	if v := override.FrequencyPenalty; v != nil {
+		if *v < -l.maxPenalty || *v > l.maxPenalty {
+			return nil, fmt.Errorf("%w: frequency_penalty需在-%g~%g之间", ErrParamsOutOfRange, l.maxPenalty, l.maxPenalty)
+		}
+		params.FrequencyPenalty = *v
+	}
+	if v := override.PresencePenalty; v != nil {
+		if *v < -l.maxPenalty || *v > l.maxPenalty {
+			return nil, fmt.Errorf("%w: presence_penalty需在-%g~%g之间", ErrParamsOutOfRange, l.maxPenalty, l.maxPenalty)
+		}
+		params.PresencePenalty = *v
+	}
+	return &params, nil
+}
+
+// 转换为模型调用选项
+func (p *Params) options() []llms.CallOption {
+	options := []llms.CallOption{
+		llms.WithTemperature(p.Temperature),
+		llms.WithFrequencyPenalty(p.FrequencyPenalty),
+		llms.WithPresencePenalty(p.PresencePenalty),
+	}
+	if p.MaxTokens > 0 {
+		options = append(options, llms.WithMaxTokens(p.MaxTokens))
+	}
+	if p.TopP > 0 {
+		options = append(options, llms.WithTopP(p.TopP))
+	}
+	return options
+}
+
+// WithParams 返回使用指定生成参数的ctx 使用该ctx的模型调用都使用这些参数
+func WithParams(ctx context.Context, params *Params) context.Context {
+	return context.WithValue(ctx, paramsCtxKey{}, params)
+}
+
+func paramsFromCtx(ctx context.Context) *Params {
+	params, _ := ctx.Value(paramsCtxKey{}).(*Params)
+	return params
+}
+
+// 补充top_p参数 langchaingo的openai chat请求不传递TopP
+// 最大长度只传递max_completion_tokens 部分兼容接口不支持 legacyMaxTokens开启时改为max_tokens
+type paramsTransport struct {
+	base            http.RoundTripper
+	defaults        *Params
+	legacyMaxTokens bool
+}
+
+func (t *paramsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	params := paramsFromCtx(req.Context())
+	if params == nil {
+		params = t.defaults
+	}
+	maxTokens := t.legacyMaxTokens && params.MaxTokens > 0
+	if (params.TopP <= 0 && !maxTokens) || req.Method != http.MethodPost || req.Body == nil ||
+		!strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return t.base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// 只修改需要的字段 其余字段保持原始内容
+	payload := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(body, &payload); err == nil {
+		if params.TopP > 0 {
+			payload["top_p"], _ = json.Marshal(params.TopP)
+		}
+		if maxTokens {
+			delete(payload, "max_completion_tokens")
+			payload["max_tokens"], _ = json.Marshal(params.MaxTokens)
+		}
+		if js, err := json.Marshal(payload); err == nil {
+			body = js
+		}
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	return t.base.RoundTrip(req)
+}
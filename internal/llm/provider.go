@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"ai-knowledge/internal/config"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+const (
+	// 内置服务类型
+	ProviderOpenAI = "openai" // openai兼容接口
+	ProviderOllama = "ollama" // ollama原生接口
+)
+
+// Provider 模型服务 其他服务实现该接口后通过RegisterProvider注册
+type Provider interface {
+	GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error)
+}
+
+// ProviderFactory 按配置创建服务 client已设置超时及生成参数补充
+type ProviderFactory func(cfg *config.LLMEndpointConfig, client *http.Client) (Provider, error)
+
+var (
+	providers   = make(map[string]ProviderFactory)
+	providersMu sync.RWMutex
+)
+
+func init() {
+	RegisterProvider(ProviderOpenAI, newOpenAIProvider)
+	RegisterProvider(ProviderOllama, newOllamaProvider)
+}
+
+// RegisterProvider 注册服务类型 需在InitLLM之前调用
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+}
+
+func newProvider(cfg *config.LLMEndpointConfig, client *http.Client) (Provider, error) {
+	name := cfg.Provider
+	if name == "" {
+		name = ProviderOpenAI
+	}
+	providersMu.RLock()
+	factory, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown llm provider %s", name)
+	}
+	return factory(cfg, client)
+}
+
+func newOpenAIProvider(cfg *config.LLMEndpointConfig, client *http.Client) (Provider, error) {
+	return openai.New(openai.WithBaseURL(cfg.BaseUrl),
+		openai.WithToken(cfg.ApiKey), openai.WithModel(cfg.Model),
+		openai.WithHTTPClient(client))
+}
+
+func newOllamaProvider(cfg *config.LLMEndpointConfig, client *http.Client) (Provider, error) {
+	return ollama.New(ollama.WithServerURL(cfg.BaseUrl),
+		ollama.WithModel(cfg.Model), ollama.WithHTTPClient(client))
+}
@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// EstimateTokens 估算文本token数 中文等非ASCII字符按1个token 其余按4个字符1个token
+// 只用于预算控制 不追求与模型分词结果一致
+func EstimateTokens(text string) int {
+	tokens, ascii := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			if !unicode.IsSpace(r) {
+				ascii++
+			}
+			continue
+		}
+		tokens++
+	}
+	return tokens + (ascii+3)/4
+}
+
+// TruncateTokens 截断文本使估算token数不超过maxTokens
+func TruncateTokens(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	tokens, ascii := 0, 0
+	for i, r := range text {
+		if r < utf8.RuneSelf {
+			if !unicode.IsSpace(r) {
+				ascii++
+			}
+		} else {
+			tokens++
+		}
+		if tokens+(ascii+3)/4 > maxTokens {
+			return text[:i]
+		}
+	}
+	return text
+}
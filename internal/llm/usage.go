@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+type usageCtxKey struct{}
+
+// Token用量
+type Usage struct {
+	PromptTokens     int              `json:"prompt_tokens"`
+	CompletionTokens int              `json:"completion_tokens"`
+	TotalTokens      int              `json:"total_tokens"`
+	Calls            int              `json:"calls"`     // 模型调用次数
+	Failovers        int              `json:"failovers"` // 故障转移次数
+	Endpoints        []*EndpointUsage `json:"endpoints"` // 实际响应的服务 按首次响应顺序
+	Embedding        *EmbeddingUsage  `json:"embedding"` // 向量化用量
+	Cost             float64          `json:"cost"`      // 按配置价格估算的费用
+
+	mu sync.Mutex
+}
+
+// 单个服务的用量
+type EndpointUsage struct {
+	Endpoint         string  `json:"endpoint"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Calls            int     `json:"calls"`
+	Cost             float64 `json:"cost"`
+}
+
+// 向量化用量
+type EmbeddingUsage struct {
+	Model        string  `json:"model"`
+	PromptTokens int     `json:"prompt_tokens"`
+	Calls        int     `json:"calls"`
+	Cost         float64 `json:"cost"`
+}
+
+// 累加一次模型调用的用量
+func (u *Usage) add(ep *endpoint, info map[string]any) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	prompt, completion, total := toInt(info["PromptTokens"]), toInt(info["CompletionTokens"]), toInt(info["TotalTokens"])
+	u.Calls++
+	u.PromptTokens += prompt
+	u.CompletionTokens += completion
+	u.TotalTokens += total
+
+	var eu *EndpointUsage
+	for _, v := range u.Endpoints {
+		if v.Endpoint == ep.name {
+			eu = v
+			break
+		}
+	}
+	if eu == nil {
+		eu = &EndpointUsage{
+			Endpoint: ep.name,
+			Provider: ep.provider,
+			Model:    ep.model,
+		}
+		u.Endpoints = append(u.Endpoints, eu)
+	}
+	eu.Calls++
+	eu.PromptTokens += prompt
+	eu.CompletionTokens += completion
+	eu.TotalTokens += total
+}
+
+// AddEmbeddingUsage 累加一次向量化调用的用量 ctx未统计用量时忽略
+func AddEmbeddingUsage(ctx context.Context, model string, tokens int) {
+	u := usageFromCtx(ctx)
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.Embedding == nil {
+		u.Embedding = new(EmbeddingUsage)
+	}
+	u.Embedding.Model = model
+	u.Embedding.PromptTokens += tokens
+	u.Embedding.Calls++
+}
+
+// 记录一次故障转移
+func (u *Usage) failover() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.Failovers++
+}
+
+// Snapshot 返回当前用量副本
+func (u *Usage) Snapshot() *Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	endpoints := make([]*EndpointUsage, 0, len(u.Endpoints))
+	for _, v := range u.Endpoints {
+		eu := *v
+		endpoints = append(endpoints, &eu)
+	}
+	embedding := EmbeddingUsage{}
+	if u.Embedding != nil {
+		embedding = *u.Embedding
+	}
+	return &Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+		Calls:            u.Calls,
+		Failovers:        u.Failovers,
+		Endpoints:        endpoints,
+		Embedding:        &embedding,
+		Cost:             u.Cost,
+	}
+}
+
+// WithUsage 返回统计用量的ctx 使用该ctx的全部模型调用都会累加到返回的Usage
+func WithUsage(ctx context.Context) (context.Context, *Usage) {
+	usage := &Usage{
+		Endpoints: make([]*EndpointUsage, 0),
+		Embedding: new(EmbeddingUsage),
+	}
+	return context.WithValue(ctx, usageCtxKey{}, usage), usage
+}
+
+func usageFromCtx(ctx context.Context) *Usage {
+	usage, _ := ctx.Value(usageCtxKey{}).(*Usage)
+	return usage
+}
+
+func toInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"unicode/utf8"
 
 	"github.com/milvus-io/milvus-sdk-go/v2/client"
 	"github.com/milvus-io/milvus-sdk-go/v2/entity"
@@ -18,6 +19,7 @@ var (
 const (
 	CollectionName                   = "knowledge"
 	dim                              = 1024
+	maxTextLen                       = 1024
 	idCol, questionCol, embeddingCol = "ID", "question", "embeddings"
 )
 
@@ -55,7 +57,7 @@ func InitMilvus(cfg *config.MilvusConfig) {
 		log.Println("创建集合", CollectionName)
 		schema := entity.NewSchema().WithName(CollectionName).WithDescription("存储问题").
 			WithField(entity.NewField().WithName(idCol).WithDataType(entity.FieldTypeInt64).WithIsPrimaryKey(true).WithIsAutoID(true)).
-			WithField(entity.NewField().WithName(questionCol).WithDataType(entity.FieldTypeVarChar).WithMaxLength(1024)).
+			WithField(entity.NewField().WithName(questionCol).WithDataType(entity.FieldTypeVarChar).WithMaxLength(maxTextLen)).
 			WithField(entity.NewField().WithName(embeddingCol).WithDataType(entity.FieldTypeFloatVector).WithDim(dim))
 
 		// 创建集合
@@ -88,10 +90,15 @@ func (m *MilvusOperator) GetClient() client.Client {
 
 // 批量插入数据
 func (m *MilvusOperator) Insert(ctx context.Context, questions []string, embeddings [][]float32) (ids []int64, err error) {
+	// 超长文本截断 完整内容存储在db
+	texts := make([]string, 0, len(questions))
+	for _, v := range questions {
+		texts = append(texts, truncateText(v, maxTextLen))
+	}
 	// 插入数据
 	columns := []entity.Column{
 		// entity.NewColumnInt64(idCol, nil),
-		entity.NewColumnVarChar(questionCol, questions),
+		entity.NewColumnVarChar(questionCol, texts),
 		entity.NewColumnFloatVector(embeddingCol, dim, embeddings),
 	}
 	result, err := m.c.Insert(ctx, CollectionName, "", columns...)
@@ -197,6 +204,19 @@ func (m *MilvusOperator) Search(ctx context.Context, vector32 []float32, topK in
 	return results, nil
 }
 
+// 按字节截断文本 不截断多字节字符
+func truncateText(text string, maxLen int) string {
+	if len(text) <= maxLen {
+		return text
+	}
+	for i := maxLen; i > 0; i-- {
+		if utf8.RuneStart(text[i]) {
+			return text[:i]
+		}
+	}
+	return ""
+}
+
 // 销毁
 func (m *MilvusOperator) Destroy() {
 	m.c.Close()
@@ -0,0 +1,105 @@
+package splitter
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+/* 文档切片 */
+
+const (
+	// 默认切片长度(字符数)
+	DefaultChunkSize = 300
+	// 默认切片重叠长度
+	DefaultChunkOverlap = 30
+)
+
+var (
+	headingReg = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+	// 中文优先按段落、句子切分
+	separators = []string{"\n\n", "\n", "。", "！", "？", "；", "!", "?", ";", " ", ""}
+)
+
+// 切片
+type Chunk struct {
+	Section string // 所属章节 多级标题使用 / 连接
+	Content string // 切片内容
+}
+
+// Split 按markdown标题划分章节后再按长度切片
+func Split(text string, chunkSize, chunkOverlap int) ([]*Chunk, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkOverlap < 0 || chunkOverlap >= chunkSize {
+		chunkOverlap = DefaultChunkOverlap
+	}
+	sp := textsplitter.NewRecursiveCharacter(
+		textsplitter.WithChunkSize(chunkSize),
+		textsplitter.WithChunkOverlap(chunkOverlap),
+		textsplitter.WithSeparators(separators),
+		textsplitter.WithKeepSeparator(true),
+	)
+
+	chunks := make([]*Chunk, 0)
+	for _, section := range splitSections(text) {
+		texts, err := sp.SplitText(section.Content)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range texts {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			chunks = append(chunks, &Chunk{
+				Section: section.Section,
+				Content: v,
+			})
+		}
+	}
+	return chunks, nil
+}
+
+// 按标题划分章节 代码块内的#不作为标题
+func splitSections(text string) []*Chunk {
+	sections := make([]*Chunk, 0)
+	headings := make([]string, 0)
+	body := new(strings.Builder)
+	inCode := false
+
+	flush := func() {
+		content := strings.TrimSpace(body.String())
+		body.Reset()
+		if content == "" {
+			return
+		}
+		sections = append(sections, &Chunk{
+			Section: strings.Join(headings, " / "),
+			Content: content,
+		})
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if !inCode {
+			if m := headingReg.FindStringSubmatch(line); m != nil {
+				flush()
+				level := len(m[1])
+				if level <= len(headings) {
+					headings = headings[:level-1]
+				}
+				headings = append(headings, m[2])
+				continue
+			}
+		}
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	flush()
+	return sections
+}
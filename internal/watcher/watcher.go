@@ -0,0 +1,167 @@
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/* 基于fsnotify监听文件及目录变化 同一路径的多个事件合并后回调 */
+
+// 默认事件合并间隔
+const defaultDebounce = 100 * time.Millisecond
+
+// 监听选项
+type Options struct {
+	Debounce  time.Duration          // 事件合并间隔 编辑器保存时通常产生多个事件
+	Recursive bool                   // 新建或移入的目录自动监听 并回调其中的文件
+	Match     func(path string) bool // 需要回调的文件 为空时全部回调
+	OnChange  func(path string)      // 文件新增、修改、删除时回调
+	OnError   func(err error)        // 监听错误 为空时忽略
+}
+
+// Watcher 文件及目录监听 fsnotify不支持递归监听 通过AddRecursive监听子目录
+type Watcher struct {
+	watcher *fsnotify.Watcher
+	opts    Options
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	timers map[string]*time.Timer
+}
+
+// New 创建监听 OnChange不能为空
+func New(opts Options) (*Watcher, error) {
+	if opts.OnChange == nil {
+		return nil, fmt.Errorf("watcher: OnChange不能为空")
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultDebounce
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		watcher: watcher,
+		opts:    opts,
+		timers:  make(map[string]*time.Timer),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Add 监听单个文件或目录 不包括子目录
+func (w *Watcher) Add(path string) error {
+	return w.watcher.Add(path)
+}
+
+// AddRecursive 监听目录及全部子目录 已存在的文件不回调
+func (w *Watcher) AddRecursive(dir string) error {
+	return w.walk(dir, false)
+}
+
+// Close 停止监听 未到合并间隔的事件不再回调
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	for _, v := range w.timers {
+		v.Stop()
+	}
+	w.mu.Unlock()
+	err := w.watcher.Close()
+	w.wg.Wait()
+	return err
+}
+
+// 处理文件变化事件
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if w.opts.Recursive && event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					w.walk(event.Name, true)
+					continue
+				}
+			}
+			// 递归监听时删除或移出的可能是目录 不判断Match
+			removed := event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+			if (w.opts.Recursive && removed) || w.match(event.Name) {
+				w.schedule(event.Name)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.error(err)
+		}
+	}
+}
+
+// 监听目录及子目录 schedule为true时回调其中的文件
+func (w *Watcher) walk(dir string, schedule bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			w.error(fmt.Errorf("遍历目录%s: %w", path, err))
+			return nil
+		}
+		if d.IsDir() {
+			if err = w.watcher.Add(path); err != nil {
+				w.error(fmt.Errorf("监听目录%s: %w", path, err))
+			}
+			return nil
+		}
+		if schedule && w.match(path) {
+			w.schedule(path)
+		}
+		return nil
+	})
+}
+
+// 同一路径的事件合并后再回调
+func (w *Watcher) schedule(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if timer, ok := w.timers[path]; ok {
+		timer.Reset(w.opts.Debounce)
+		return
+	}
+	w.timers[path] = time.AfterFunc(w.opts.Debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, path)
+		closed := w.closed
+		w.mu.Unlock()
+		if !closed {
+			w.opts.OnChange(path)
+		}
+	})
+}
+
+func (w *Watcher) match(path string) bool {
+	return w.opts.Match == nil || w.opts.Match(path)
+}
+
+func (w *Watcher) error(err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(err)
+	}
+}
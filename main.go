@@ -17,12 +17,20 @@ func main() {
 		log.Println(err)
 		os.Exit(1)
 	}
+	// 执行命令行子命令
+	if len(os.Args) > 1 {
+		if err := p.RunCommand(os.Args[1:]); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	// 运行
 	log.Println("启动程序成功")
 	p.Run()
 
 	// 监听退出信号
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	<-c
 	p.Stop()
@@ -0,0 +1,130 @@
+package program
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ai-knowledge/internal/milvus"
+	"ai-knowledge/program/service"
+)
+
+/* 命令行子命令 */
+
+var (
+	ErrUnknownCommand = errors.New("unknown command, usage: [ import | export | eval ]")
+)
+
+// RunCommand 执行命令行子命令
+func (p *Program) RunCommand(args []string) error {
+	if len(args) == 0 {
+		return ErrUnknownCommand
+	}
+	var run func(ctx context.Context, args []string) error
+	switch args[0] {
+	case "import":
+		run = p.importCommand
+	case "export":
+		run = p.exportCommand
+	case "eval":
+		run = p.evalCommand
+	default:
+		return ErrUnknownCommand
+	}
+	p.initComponents()
+	defer milvus.MilvusHandler.Destroy()
+	return run(context.Background(), args[1:])
+}
+
+// 批量导入 ai-knowledge import -file faq.csv [-format csv] [-dry-run]
+func (p *Program) importCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "导入文件路径")
+	format := fs.String("format", "", "文件格式 jsonl|csv 默认按扩展名判断")
+	dryRun := fs.Bool("dry-run", false, "只校验不写入")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		fs.Usage()
+		return errors.New("file is required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	result, err := service.Knowledge.Import(ctx, f, *format, *dryRun)
+	if result != nil {
+		js, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(js))
+	}
+	return err
+}
+
+// 导出全部知识 ai-knowledge export [-file knowledge.jsonl] [-format jsonl]
+func (p *Program) exportCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := fs.String("file", "", "导出文件路径 为空输出到标准输出")
+	format := fs.String("format", "", "文件格式 jsonl|csv 默认按扩展名判断")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+	if *format == "" {
+		*format = service.FormatJSONL
+	}
+
+	var w io.Writer = os.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return service.Knowledge.Export(ctx, w, *format)
+}
+
+// 执行评测集 ai-knowledge eval -set 1 [-top-k 3] [-knowledge-base name] [-answer] [-label 说明]
+func (p *Program) evalCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	setId := fs.Int64("set", 0, "评测集id")
+	topK := fs.Int("top-k", 0, "检索条数 默认3")
+	knowledgeBase := fs.String("knowledge-base", "", "知识库名称")
+	answer := fs.Bool("answer", false, "生成答案并计算答案相似度")
+	label := fs.String("label", "", "运行说明")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *setId <= 0 {
+		fs.Usage()
+		return errors.New("set is required")
+	}
+
+	run, err := service.Eval.Run(ctx, &service.EvalRunParams{
+		SetId:         *setId,
+		Label:         *label,
+		KnowledgeBase: *knowledgeBase,
+		TopK:          *topK,
+		Answer:        *answer,
+	})
+	if run != nil {
+		js, _ := json.MarshalIndent(run, "", "  ")
+		fmt.Println(string(js))
+	}
+	return err
+}
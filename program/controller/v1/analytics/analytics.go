@@ -0,0 +1,143 @@
+package analytics
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 问答查询记录及统计 start、end为日期范围 2006-01-02
+
+type AnalyticsController struct {
+}
+
+func (ac *AnalyticsController) Register(router *gin.RouterGroup) {
+	router.GET("/analytics/queryLogs", ginctx.Handle(ac.QueryLogs))
+	router.GET("/analytics/topQuestions", ginctx.Handle(ac.TopQuestions))
+	router.GET("/analytics/leastAnswered", ginctx.Handle(ac.LeastAnswered))
+	router.GET("/analytics/knowledgeHits", ginctx.Handle(ac.KnowledgeHits))
+	router.GET("/analytics/notRetrieved", ginctx.Handle(ac.NotRetrieved))
+	router.GET("/analytics/latency", ginctx.Handle(ac.Latency))
+}
+
+// 返回错误 参数错误时提示参数错误
+func fail(c *ginctx.Context, err error) {
+	if errors.Is(err, service.ErrInvalidParams) {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	logger.Logger.Errorw("处理数据错误", "err", err)
+	c.JSON(1, nil, "处理数据错误")
+}
+
+// 分页参数
+func pageParams(c *ginctx.Context) (int, int) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	return page, pageSize
+}
+
+// 查询记录列表 可按channel及fallback(0正常 1兜底)过滤
+func (ac *AnalyticsController) QueryLogs(c *ginctx.Context) {
+	page, pageSize := pageParams(c)
+	fallback, err := strconv.Atoi(c.Query("fallback"))
+	if err != nil {
+		fallback = -1
+	}
+	list, total, err := service.QueryLog.GetList(c, page, pageSize, c.Query("start"), c.Query("end"), c.Query("channel"), int32(fallback))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 查询次数最多的问题
+func (ac *AnalyticsController) TopQuestions(c *ginctx.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	list, err := service.QueryLog.TopQuestions(c, c.Query("start"), c.Query("end"), limit)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list": list,
+	}, "成功")
+}
+
+// 回答率最低的问题
+func (ac *AnalyticsController) LeastAnswered(c *ginctx.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	list, err := service.QueryLog.LeastAnswered(c, c.Query("start"), c.Query("end"), limit)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list": list,
+	}, "成功")
+}
+
+// 各知识被检索到的次数及命中率
+func (ac *AnalyticsController) KnowledgeHits(c *ginctx.Context) {
+	page, pageSize := pageParams(c)
+	list, total, err := service.QueryLog.KnowledgeHits(c, c.Query("start"), c.Query("end"), page, pageSize)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 最近days天未被检索到的知识
+func (ac *AnalyticsController) NotRetrieved(c *ginctx.Context) {
+	page, pageSize := pageParams(c)
+	days, _ := strconv.Atoi(c.Query("days"))
+	if days <= 0 {
+		logger.Logger.Warnw("参数不合法", "days", c.Query("days"))
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	list, total, err := service.QueryLog.NotRetrieved(c, days, page, pageSize)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 耗时百分位
+func (ac *AnalyticsController) Latency(c *ginctx.Context) {
+	stats, err := service.QueryLog.Latency(c, c.Query("start"), c.Query("end"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(0, stats, "成功")
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 语义答案缓存
+
+type CacheController struct {
+}
+
+func (cc *CacheController) Register(router *gin.RouterGroup) {
+	router.GET("/cache/stats", ginctx.Handle(cc.Stats))
+	router.POST("/cache/clear", ginctx.Handle(cc.Clear))
+}
+
+// 缓存条数及命中统计
+func (cc *CacheController) Stats(c *ginctx.Context) {
+	c.JSON(0, service.AnswerCache.Stats(c), "成功")
+}
+
+type ClearReq struct {
+	KnowledgeBase string `json:"knowledge_base"` // 为空时清空全部
+}
+
+// 清空缓存
+func (cc *CacheController) Clear(c *ginctx.Context) {
+	req := new(ClearReq)
+	err := c.Bind(req)
+	if err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	removed := service.AnswerCache.Clear(c, req.KnowledgeBase)
+	c.JSON(0, map[string]any{"removed": removed}, "成功")
+}
@@ -0,0 +1,96 @@
+package conflict
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 知识矛盾检测
+
+type ConflictController struct {
+}
+
+func (cc *ConflictController) Register(router *gin.RouterGroup) {
+	router.POST("/conflict/scan", ginctx.Handle(cc.Scan))
+	router.GET("/conflict/getList", ginctx.Handle(cc.GetList))
+	router.POST("/conflict/ignore", ginctx.Handle(cc.Ignore))
+}
+
+// 创建检测任务 返回任务id
+func (cc *ConflictController) Scan(c *ginctx.Context) {
+	req := new(service.ConflictParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	job, err := service.Conflict.Submit(c, req)
+	if err != nil {
+		logger.Logger.Errorw("创建任务错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, map[string]any{
+		"job_id": job.Id,
+	}, "成功")
+}
+
+// 获取矛盾列表 默认为待处理 status传-1时不过滤
+func (cc *ConflictController) GetList(c *ginctx.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	status, err := strconv.Atoi(c.DefaultQuery("status", "0"))
+	if err != nil {
+		status = -1
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	list, total, err := service.Conflict.GetList(c, page, pageSize, int32(status))
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+type IgnoreReq struct {
+	Ids []int64 `json:"ids"`
+}
+
+// 忽略矛盾
+func (cc *ConflictController) Ignore(c *ginctx.Context) {
+	req := new(IgnoreReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if len(req.Ids) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Conflict.Ignore(c, req.Ids)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
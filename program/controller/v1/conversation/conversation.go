@@ -0,0 +1,113 @@
+package conversation
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 多轮对话会话
+
+type ConversationController struct {
+}
+
+func (cc *ConversationController) Register(router *gin.RouterGroup) {
+	router.POST("/conversation/create", ginctx.Handle(cc.Create))
+	router.GET("/conversation/get", ginctx.Handle(cc.Get))
+	router.GET("/conversation/getList", ginctx.Handle(cc.GetList))
+	router.POST("/conversation/del", ginctx.Handle(cc.Del))
+}
+
+type CreateReq struct {
+	KnowledgeBase string `json:"knowledge_base"`
+}
+
+// 创建会话 返回的id作为queryQAndA的conversation_id
+func (cc *ConversationController) Create(c *ginctx.Context) {
+	req := new(CreateReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	conversation, err := service.Conversation.Create(c, req.KnowledgeBase)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, conversation, "成功")
+}
+
+// 获取会话及全部消息
+func (cc *ConversationController) Get(c *ginctx.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		logger.Logger.Warnw("参数不合法", "id", id)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	detail, err := service.Conversation.Get(c, id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "id", id)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, detail, "成功")
+}
+
+// 获取列表
+func (cc *ConversationController) GetList(c *ginctx.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	list, total, err := service.Conversation.GetList(c, page, pageSize)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+type DelReq struct {
+	Id int64 `json:"id"`
+}
+
+// 删除会话及全部消息
+func (cc *ConversationController) Del(c *ginctx.Context) {
+	req := new(DelReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Id <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Conversation.Del(c, req.Id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
@@ -0,0 +1,198 @@
+package document
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 源文档管理
+
+type DocumentController struct {
+}
+
+func (dc *DocumentController) Register(router *gin.RouterGroup) {
+	router.POST("/document/save", ginctx.Handle(dc.Save))
+	router.POST("/document/upload", ginctx.Handle(dc.Upload))
+	router.GET("/document/getList", ginctx.Handle(dc.GetList))
+	router.GET("/document/get", ginctx.Handle(dc.Get))
+	router.POST("/document/del", ginctx.Handle(dc.Del))
+}
+
+type SaveReq struct {
+	Title   string `json:"title"`
+	Origin  string `json:"origin"`
+	Content string `json:"content"`
+	Async   bool   `json:"async"` // 异步保存 返回任务id
+}
+
+// 保存文档 相同来源重复保存会替换全部切片
+func (dc *DocumentController) Save(c *ginctx.Context) {
+	req := new(SaveReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Origin == "" || req.Content == "" {
+		logger.Logger.Warnw("参数不合法", "title", req.Title, "origin", req.Origin)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	if req.Async {
+		dc.enqueue(c, req.Title, req.Origin, []byte(req.Content))
+		return
+	}
+
+	document, changed, err := service.Document.SaveDocument(c, req.Title, req.Origin, req.Content)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "title", req.Title, "origin", req.Origin)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, map[string]any{
+		"document": document,
+		"changed":  changed,
+	}, "成功")
+}
+
+// 上传文本文档
+func (dc *DocumentController) Upload(c *ginctx.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		logger.Logger.Errorw("读取上传文件错误", "err", err)
+		c.JSON(1, nil, "读取文件错误")
+		return
+	}
+	defer f.Close()
+	body, err := io.ReadAll(f)
+	if err != nil {
+		logger.Logger.Errorw("读取上传文件错误", "err", err)
+		c.JSON(1, nil, "读取文件错误")
+		return
+	}
+	if len(body) == 0 || !utf8.Valid(body) {
+		logger.Logger.Warnw("文件内容不是utf8文本", "filename", fh.Filename)
+		c.JSON(1, nil, "仅支持utf8编码的文本文件")
+		return
+	}
+	origin := c.PostForm("origin")
+	if origin == "" {
+		origin = fh.Filename
+	}
+
+	if async, _ := strconv.ParseBool(c.PostForm("async")); async {
+		dc.enqueue(c, c.PostForm("title"), origin, body)
+		return
+	}
+
+	document, changed, err := service.Document.SaveDocument(c, c.PostForm("title"), origin, string(body))
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "origin", origin)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, map[string]any{
+		"document": document,
+		"changed":  changed,
+	}, "成功")
+}
+
+// 创建异步保存文档任务
+func (dc *DocumentController) enqueue(c *ginctx.Context, title, origin string, content []byte) {
+	job, err := service.Job.Enqueue(service.JobTypeDocument, &service.DocumentJobParams{
+		Title:  title,
+		Origin: origin,
+	}, bytes.NewReader(content), filepath.Ext(origin))
+	if err != nil {
+		logger.Logger.Errorw("创建任务错误", "err", err, "origin", origin)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, map[string]any{
+		"job_id": job.Id,
+	}, "成功")
+}
+
+// 获取列表
+func (dc *DocumentController) GetList(c *ginctx.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	list, total, err := service.Document.GetList(c, page, pageSize)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 获取文档及全部切片
+func (dc *DocumentController) Get(c *ginctx.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		logger.Logger.Warnw("参数不合法", "id", id)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	detail, err := service.Document.GetDocument(c, id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "id", id)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, detail, "成功")
+}
+
+type DelReq struct {
+	Id int64 `json:"id"`
+}
+
+// 删除文档及全部切片
+func (dc *DocumentController) Del(c *ginctx.Context) {
+	req := new(DelReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Id <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Document.DelDocument(c, req.Id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
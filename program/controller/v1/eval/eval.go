@@ -0,0 +1,217 @@
+package eval
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 离线检索评测
+
+type EvalController struct {
+}
+
+func (ec *EvalController) Register(router *gin.RouterGroup) {
+	router.POST("/eval/saveSet", ginctx.Handle(ec.SaveSet))
+	router.GET("/eval/getSetList", ginctx.Handle(ec.GetSetList))
+	router.GET("/eval/getSet", ginctx.Handle(ec.GetSet))
+	router.POST("/eval/delSet", ginctx.Handle(ec.DelSet))
+	router.POST("/eval/run", ginctx.Handle(ec.Run))
+	router.GET("/eval/getRunList", ginctx.Handle(ec.GetRunList))
+	router.GET("/eval/getRun", ginctx.Handle(ec.GetRun))
+	router.GET("/eval/compare", ginctx.Handle(ec.Compare))
+}
+
+// 错误提示
+func errMsg(err error) string {
+	if errors.Is(err, service.ErrInvalidParams) || errors.Is(err, service.ErrDataNotFound) {
+		return "参数错误"
+	}
+	return "处理数据错误"
+}
+
+// 分页参数
+func pageParams(c *ginctx.Context) (int, int) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	return page, pageSize
+}
+
+// 保存评测集 id大于0时替换全部用例
+func (ec *EvalController) SaveSet(c *ginctx.Context) {
+	req := new(service.EvalSetParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Name == "" || len(req.Cases) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	id, err := service.Eval.SaveSet(c, req)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, map[string]any{
+		"id": id,
+	}, "成功")
+}
+
+// 获取评测集列表
+func (ec *EvalController) GetSetList(c *ginctx.Context) {
+	page, pageSize := pageParams(c)
+	list, total, err := service.Eval.GetSetList(c, page, pageSize)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 获取评测集及用例
+func (ec *EvalController) GetSet(c *ginctx.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		logger.Logger.Warnw("参数不合法", "id", c.Query("id"))
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	set, err := service.Eval.GetSet(c, id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "id", id)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, set, "成功")
+}
+
+type DelSetReq struct {
+	Id int64 `json:"id"`
+}
+
+// 删除评测集
+func (ec *EvalController) DelSet(c *ginctx.Context) {
+	req := new(DelSetReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Id <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	if err := service.Eval.DelSet(c, req.Id); err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
+
+// 创建评测任务 返回任务id 完成后任务结果中的run_id为运行记录id
+func (ec *EvalController) Run(c *ginctx.Context) {
+	req := new(service.EvalRunParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.SetId <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	job, err := service.Eval.Submit(c, req)
+	if err != nil {
+		logger.Logger.Errorw("创建任务错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, map[string]any{
+		"job_id": job.Id,
+	}, "成功")
+}
+
+// 获取运行记录列表
+func (ec *EvalController) GetRunList(c *ginctx.Context) {
+	page, pageSize := pageParams(c)
+	setId, _ := strconv.ParseInt(c.Query("set_id"), 10, 64)
+	list, total, err := service.Eval.GetRunList(c, page, pageSize, setId)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 获取运行记录及各用例结果
+func (ec *EvalController) GetRun(c *ginctx.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		logger.Logger.Warnw("参数不合法", "id", c.Query("id"))
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	run, err := service.Eval.GetRun(c, id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "id", id)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, run, "成功")
+}
+
+// 对比多次运行 ids为,分隔的运行记录id
+func (ec *EvalController) Compare(c *ginctx.Context) {
+	ids := make([]int64, 0)
+	for _, v := range strings.Split(c.Query("ids"), ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || id <= 0 {
+			logger.Logger.Warnw("参数不合法", "ids", c.Query("ids"))
+			c.JSON(1, nil, "参数错误")
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	compare, err := service.Eval.Compare(c, ids)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "ids", ids)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, compare, "成功")
+}
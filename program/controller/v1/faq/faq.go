@@ -0,0 +1,148 @@
+package faq
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 从纯知识中抽取候选问答
+
+type FaqController struct {
+}
+
+func (fc *FaqController) Register(router *gin.RouterGroup) {
+	router.POST("/faq/extract", ginctx.Handle(fc.Extract))
+	router.GET("/faq/getList", ginctx.Handle(fc.GetList))
+	router.POST("/faq/accept", ginctx.Handle(fc.Accept))
+	router.POST("/faq/reject", ginctx.Handle(fc.Reject))
+}
+
+// 错误提示
+func errMsg(err error) string {
+	if errors.Is(err, service.ErrInvalidParams) || errors.Is(err, service.ErrDataNotFound) {
+		return "参数错误"
+	}
+	return "处理数据错误"
+}
+
+// 创建抽取任务 返回任务id
+func (fc *FaqController) Extract(c *ginctx.Context) {
+	req := new(service.FaqExtractParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.DocumentId <= 0 && len(req.KnowledgeIds) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	job, err := service.Job.Enqueue(service.JobTypeFaqExtract, req, nil, "")
+	if err != nil {
+		logger.Logger.Errorw("创建任务错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, map[string]any{
+		"job_id": job.Id,
+	}, "成功")
+}
+
+// 获取候选问答列表
+func (fc *FaqController) GetList(c *ginctx.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	documentId, _ := strconv.ParseInt(c.Query("document_id"), 10, 64)
+	status, err := strconv.Atoi(c.Query("status"))
+	if err != nil {
+		status = -1
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	list, total, err := service.Faq.GetList(c, page, pageSize, int32(status), documentId)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+type AcceptReq struct {
+	Items []*service.FaqAcceptItem `json:"items"`
+}
+
+// 采纳候选问答 可编辑问题和答案后保存为问答分组
+func (fc *FaqController) Accept(c *ginctx.Context) {
+	req := new(AcceptReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if len(req.Items) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	for _, v := range req.Items {
+		if v == nil || v.Id <= 0 {
+			logger.Logger.Warnw("参数不合法", "req", req)
+			c.JSON(1, nil, "参数错误")
+			return
+		}
+	}
+
+	groupKeys, err := service.Faq.Accept(c, req.Items)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, map[string]any{
+		"group_keys": groupKeys,
+	}, "成功")
+}
+
+type RejectReq struct {
+	Ids []int64 `json:"ids"`
+}
+
+// 拒绝候选问答
+func (fc *FaqController) Reject(c *ginctx.Context) {
+	req := new(RejectReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if len(req.Ids) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Faq.Reject(c, req.Ids)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
@@ -0,0 +1,140 @@
+package feedback
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 答案评价及转为问答
+
+type FeedbackController struct {
+}
+
+func (fc *FeedbackController) Register(router *gin.RouterGroup) {
+	router.POST("/feedback/save", ginctx.Handle(fc.Save))
+	router.GET("/feedback/getList", ginctx.Handle(fc.GetList))
+	router.POST("/feedback/promote", ginctx.Handle(fc.Promote))
+	router.POST("/feedback/ignore", ginctx.Handle(fc.Ignore))
+}
+
+// 错误提示
+func errMsg(err error) string {
+	if errors.Is(err, service.ErrInvalidParams) || errors.Is(err, service.ErrDataNotFound) {
+		return "参数错误"
+	}
+	return "处理数据错误"
+}
+
+// 评价答案 返回评价id
+func (fc *FeedbackController) Save(c *ginctx.Context) {
+	req := new(service.FeedbackParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.QueryLogId <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	id, err := service.Feedback.Save(c, req)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, map[string]any{
+		"id": id,
+	}, "成功")
+}
+
+// 获取评价列表 默认为待处理的差评 rating传0、status传-1时不过滤
+func (fc *FeedbackController) GetList(c *ginctx.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	rating, err := strconv.Atoi(c.DefaultQuery("rating", "-1"))
+	if err != nil {
+		rating = 0
+	}
+	status, err := strconv.Atoi(c.DefaultQuery("status", "0"))
+	if err != nil {
+		status = -1
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	list, total, err := service.Feedback.GetList(c, page, pageSize, int32(rating), int32(status))
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 把模型生成的答案保存为问答分组
+func (fc *FeedbackController) Promote(c *ginctx.Context) {
+	req := new(service.PromoteParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.QueryLogId <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	groupKey, err := service.Feedback.Promote(c, req)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, map[string]any{
+		"group_key": groupKey,
+	}, "成功")
+}
+
+type IgnoreReq struct {
+	Ids []int64 `json:"ids"`
+}
+
+// 忽略评价
+func (fc *FeedbackController) Ignore(c *ginctx.Context) {
+	req := new(IgnoreReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if len(req.Ids) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Feedback.Ignore(c, req.Ids)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
@@ -0,0 +1,132 @@
+package gap
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 知识缺口分析
+
+type GapController struct {
+}
+
+func (gc *GapController) Register(router *gin.RouterGroup) {
+	router.POST("/gap/run", ginctx.Handle(gc.Run))
+	router.GET("/gap/getList", ginctx.Handle(gc.GetList))
+	router.POST("/gap/promote", ginctx.Handle(gc.Promote))
+	router.POST("/gap/ignore", ginctx.Handle(gc.Ignore))
+}
+
+// 错误提示
+func errMsg(err error) string {
+	if errors.Is(err, service.ErrInvalidParams) || errors.Is(err, service.ErrDataNotFound) {
+		return "参数错误"
+	}
+	return "处理数据错误"
+}
+
+// 创建分析任务 返回任务id
+func (gc *GapController) Run(c *ginctx.Context) {
+	req := new(service.GapParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	job, err := service.Gap.Submit(c, req)
+	if err != nil {
+		logger.Logger.Errorw("创建任务错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, map[string]any{
+		"job_id": job.Id,
+	}, "成功")
+}
+
+// 获取知识缺口列表 默认为待处理 status传-1时不过滤
+func (gc *GapController) GetList(c *ginctx.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	jobId, _ := strconv.ParseInt(c.Query("job_id"), 10, 64)
+	status, err := strconv.Atoi(c.DefaultQuery("status", "0"))
+	if err != nil {
+		status = -1
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	list, total, err := service.Gap.GetList(c, page, pageSize, jobId, int32(status))
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+// 补充答案并保存为问答分组
+func (gc *GapController) Promote(c *ginctx.Context) {
+	req := new(service.GapPromoteParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Id <= 0 || req.Answer == "" {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	groupKey, err := service.Gap.Promote(c, req)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, map[string]any{
+		"group_key": groupKey,
+	}, "成功")
+}
+
+type IgnoreReq struct {
+	Ids []int64 `json:"ids"`
+}
+
+// 忽略知识缺口
+func (gc *GapController) Ignore(c *ginctx.Context) {
+	req := new(IgnoreReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if len(req.Ids) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Gap.Ignore(c, req.Ids)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
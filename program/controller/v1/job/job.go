@@ -0,0 +1,94 @@
+package job
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 异步任务
+
+type JobController struct {
+}
+
+func (jc *JobController) Register(router *gin.RouterGroup) {
+	router.GET("/job/get", ginctx.Handle(jc.Get))
+	router.GET("/job/getList", ginctx.Handle(jc.GetList))
+	router.POST("/job/cancel", ginctx.Handle(jc.Cancel))
+}
+
+// 查询任务状态、进度及错误
+func (jc *JobController) Get(c *ginctx.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		logger.Logger.Warnw("参数不合法", "id", id)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	job, err := service.Job.GetJob(c, id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "id", id)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, job, "成功")
+}
+
+// 获取列表
+func (jc *JobController) GetList(c *ginctx.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	status, err := strconv.Atoi(c.Query("status"))
+	if err != nil {
+		status = -1
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.DefaultPageSize
+	}
+	list, total, err := service.Job.GetList(c, page, pageSize, c.Query("type"), int32(status))
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+
+	c.JSON(0, map[string]any{
+		"list":  list,
+		"total": total,
+	}, "成功")
+}
+
+type CancelReq struct {
+	Id int64 `json:"id"`
+}
+
+// 取消任务
+func (jc *JobController) Cancel(c *ginctx.Context) {
+	req := new(CancelReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Id <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Job.Cancel(c, req.Id)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "任务不可取消")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
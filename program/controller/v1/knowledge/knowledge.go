@@ -3,9 +3,16 @@ package knowledge
 import (
 	"ai-knowledge/internal/common"
 	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/llm"
 	"ai-knowledge/internal/logger"
 	"ai-knowledge/program/service"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,6 +24,8 @@ type KnowledgeController struct {
 
 func (kc *KnowledgeController) Register(router *gin.RouterGroup) {
 	router.POST("/knowledge/queryQAndA", ginctx.Handle(kc.QueryQAndA))
+	router.POST("/knowledge/queryQAndAStream", ginctx.Handle(kc.QueryQAndAStream))
+	router.POST("/knowledge/search", ginctx.Handle(kc.Search))
 	router.POST("/knowledge/saveQAndA", ginctx.Handle(kc.SaveQAndA))
 	router.POST("/knowledge/upQAndA", ginctx.Handle(kc.UpQAndA))
 	router.POST("/knowledge/saveKnowledge", ginctx.Handle(kc.SaveKnowledge))
@@ -24,10 +33,17 @@ func (kc *KnowledgeController) Register(router *gin.RouterGroup) {
 	router.GET("/knowledge/getList", ginctx.Handle(kc.GetList))
 	router.GET("/knowledge/getByGroupKey", ginctx.Handle(kc.GetByGroupKey))
 	router.POST("/knowledge/delByIds", ginctx.Handle(kc.DelByIds))
+	router.POST("/knowledge/import", ginctx.Handle(kc.Import))
+	router.GET("/knowledge/export", ginctx.Handle(kc.Export))
+	router.POST("/knowledge/importXlsx", ginctx.Handle(kc.ImportXlsx))
+	router.GET("/knowledge/importReport", ginctx.Handle(kc.ImportReport))
+	router.POST("/knowledge/genVariants", ginctx.Handle(kc.GenVariants))
+	router.POST("/knowledge/reviewVariants", ginctx.Handle(kc.ReviewVariants))
 }
 
 type SaveKnowledgeReq struct {
 	Texts []string `json:"texts"`
+	Tags  []string `json:"tags"`
 }
 
 // 保存知识点
@@ -51,7 +67,10 @@ func (kc *KnowledgeController) SaveKnowledge(c *ginctx.Context) {
 		}
 	}
 
-	err := service.Knowledge.SaveKnowledge(c, req.Texts)
+	_, err := service.Knowledge.SaveKnowledge(c, &service.KnowledgeGroup{
+		Texts: req.Texts,
+		Tags:  req.Tags,
+	})
 	if err != nil {
 		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
 		c.JSON(1, nil, "处理数据错误")
@@ -62,7 +81,7 @@ func (kc *KnowledgeController) SaveKnowledge(c *ginctx.Context) {
 
 type UpKnowledgeReq struct {
 	Texts []struct {
-		Id   int64  `json:"id"`
+		Id   int64  `json:"id"` // 知识id
 		Text string `json:"text"`
 	} `json:"texts"`
 }
@@ -96,15 +115,17 @@ func (kc *KnowledgeController) UpKnowledge(c *ginctx.Context) {
 	err := service.Knowledge.UpKnowledge(c, ids, texts)
 	if err != nil {
 		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
-		c.JSON(1, nil, "处理数据错误")
+		c.JSON(1, nil, errMsg(err))
 		return
 	}
 	c.JSON(0, nil, "成功")
 }
 
 type SaveQAndAReq struct {
-	Questions []string `json:"questions"`
-	Answer    string   `json:"answer"`
+	Questions   []string `json:"questions"`
+	Answer      string   `json:"answer"`
+	Tags        []string `json:"tags"`
+	GenVariants int      `json:"gen_variants"` // 每个问题由模型生成的变体数
 }
 
 // 保存问答
@@ -128,7 +149,12 @@ func (kc *KnowledgeController) SaveQAndA(c *ginctx.Context) {
 		}
 	}
 
-	err := service.Knowledge.SaveQAndA(c, req.Questions, req.Answer)
+	_, err := service.Knowledge.SaveQAndA(c, &service.QAndAGroup{
+		Questions:   req.Questions,
+		Answer:      req.Answer,
+		Tags:        req.Tags,
+		GenVariants: req.GenVariants,
+	})
 	if err != nil {
 		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
 		c.JSON(1, nil, "处理数据错误")
@@ -140,10 +166,11 @@ func (kc *KnowledgeController) SaveQAndA(c *ginctx.Context) {
 
 type UpQAndAReq struct {
 	Questions []struct {
-		Id       int64  `json:"id"`
+		Id       int64  `json:"id"` // 知识id
 		Question string `json:"question"`
 	} `json:"questions"`
-	Answer string `json:"answer"`
+	Answer      string `json:"answer"`
+	GenVariants int    `json:"gen_variants"` // 大于0时重新生成分组内的问题变体
 }
 
 // 修改问答
@@ -171,10 +198,10 @@ func (kc *KnowledgeController) UpQAndA(c *ginctx.Context) {
 		questions = append(questions, v.Question)
 	}
 
-	err := service.Knowledge.UpQAndA(c, ids, questions, req.Answer)
+	err := service.Knowledge.UpQAndA(c, ids, questions, req.Answer, req.GenVariants)
 	if err != nil {
 		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
-		c.JSON(1, nil, "处理数据错误")
+		c.JSON(1, nil, errMsg(err))
 		return
 	}
 
@@ -182,8 +209,81 @@ func (kc *KnowledgeController) UpQAndA(c *ginctx.Context) {
 }
 
 type QueryQAndAReq struct {
-	Question string `json:"question"`
-	TopK     int    `json:"top_k"`
+	Question       string                  `json:"question"`
+	TopK           int                     `json:"top_k"`
+	KnowledgeBase  string                  `json:"knowledge_base"`  // 知识库名称
+	Prompt         *service.PromptTemplate `json:"prompt"`          // 覆盖提示词模板
+	Params         *llm.ParamsOverride     `json:"params"`          // 覆盖生成参数
+	ConversationId int64                   `json:"conversation_id"` // 多轮对话会话id
+	DirectAnswer   *bool                   `json:"direct_answer"`   // 是否启用高相似度问答直接返回
+	Chain          string                  `json:"chain"`           // 回答方式 stuff、map_reduce、refine、map_rerank
+	Strategies     []string                `json:"strategies"`      // 检索前的问题处理 rewrite、multi_query、hyde 传入空列表时不处理
+	Cache          *bool                   `json:"cache"`           // 是否使用语义答案缓存
+	Channel        string                  `json:"channel"`         // 调用渠道 如web、app 用于统计
+}
+
+// 检索参数
+func (r *QueryQAndAReq) params() *service.SearchParams {
+	return &service.SearchParams{
+		Question:       r.Question,
+		TopK:           r.TopK,
+		KnowledgeBase:  r.KnowledgeBase,
+		Prompt:         r.Prompt,
+		LLMParams:      r.Params,
+		ConversationId: r.ConversationId,
+		DirectAnswer:   r.DirectAnswer,
+		Chain:          r.Chain,
+		Strategies:     r.Strategies,
+		Cache:          r.Cache,
+	}
+}
+
+// 错误提示
+func errMsg(err error) string {
+	if errors.Is(err, service.ErrInvalidParams) {
+		return "参数错误"
+	}
+	return "处理数据错误"
+}
+
+// 调用方密钥 X-Api-Key或Authorization: Bearer
+func apiKey(c *ginctx.Context) string {
+	if key := c.GetHeader("X-Api-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// 保存本次查询的token用量 出错时已消耗的用量同样保存
+func recordUsage(c *ginctx.Context, knowledgeBase string, usage *llm.Usage) {
+	if usage == nil {
+		return
+	}
+	_ = service.Usage.Record(c, &service.UsageMeta{
+		KnowledgeBase: knowledgeBase,
+		ApiKey:        apiKey(c),
+		Endpoint:      c.FullPath(),
+	}, usage)
+}
+
+// 问答查询的token用量
+func recordSearchUsage(c *ginctx.Context, req *QueryQAndAReq, result *service.SearchResult) {
+	if result == nil {
+		return
+	}
+	knowledgeBase := result.KnowledgeBase
+	if knowledgeBase == "" {
+		knowledgeBase = req.KnowledgeBase
+	}
+	recordUsage(c, knowledgeBase, result.Usage)
+}
+
+// 保存查询记录 出错的查询同样保存
+func recordQueryLog(c *ginctx.Context, req *QueryQAndAReq, result *service.SearchResult, err error) int64 {
+	return service.QueryLog.Record(c, &service.QueryLogMeta{
+		Question: req.Question,
+		Channel:  req.Channel,
+	}, result, err)
 }
 
 // 查询一个问题答案
@@ -203,21 +303,134 @@ func (kc *KnowledgeController) QueryQAndA(c *ginctx.Context) {
 		req.TopK = common.DefaultTopK
 	}
 
-	answer, knowledges, err := service.Knowledge.Search(c, req.Question, req.TopK)
+	result, err := service.Knowledge.Search(c, req.params())
+	recordSearchUsage(c, req, result)
+	queryLogId := recordQueryLog(c, req, result, err)
 	if err != nil {
 		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
-		c.JSON(1, nil, "处理数据错误")
+		c.JSON(1, nil, errMsg(err))
 		return
 	}
-	c.JSON(0, map[string]any{
-		"answer":     answer,
-		"knowledges": knowledges,
-	}, "成功")
+	result.QueryLogId = queryLogId
+	c.JSON(0, result, "成功")
+}
+
+// 流式查询问题答案 依次输出knowledges、token、done事件 出错时输出error事件
+func (kc *KnowledgeController) QueryQAndAStream(c *ginctx.Context) {
+	req := new(QueryQAndAReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Question == "" {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.TopK <= 0 {
+		req.TopK = common.DefaultTopK
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	// 客户端断开时取消检索和生成
+	ctx := c.Request.Context()
+	params := req.params()
+	params.OnKnowledges = func(knowledges []*service.SearchKnowledge) error {
+		c.SSEvent("knowledges", knowledges)
+		c.Writer.Flush()
+		return ctx.Err()
+	}
+	params.OnToken = func(ctx context.Context, chunk []byte) error {
+		c.SSEvent("token", map[string]any{
+			"text": string(chunk),
+		})
+		c.Writer.Flush()
+		return ctx.Err()
+	}
+	result, err := service.Knowledge.Search(ctx, params)
+	recordSearchUsage(c, req, result)
+	queryLogId := recordQueryLog(c, req, result, err)
+	if ctx.Err() != nil {
+		logger.Logger.Infow("客户端断开连接", "req", req)
+		return
+	}
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.SSEvent("error", c.JSONRoot(1, nil, errMsg(err)))
+		c.Writer.Flush()
+		return
+	}
+	result.QueryLogId = queryLogId
+	c.SSEvent("done", map[string]any{
+		"query_log_id":        result.QueryLogId,
+		"answer":              result.Answer,
+		"answer_source":       result.AnswerSource,
+		"chain":               result.Chain,
+		"queries":             result.Queries,
+		"cache":               result.Cache,
+		"segments":            result.Segments,
+		"params":              result.Params,
+		"conversation_id":     result.ConversationId,
+		"standalone_question": result.StandaloneQuestion,
+		"usage":               result.Usage,
+		"timing":              result.Timing,
+	})
+	c.Writer.Flush()
+}
+
+type SearchReq struct {
+	Question      string                  `json:"question"`
+	TopK          int                     `json:"top_k"`
+	KnowledgeBase string                  `json:"knowledge_base"` // 知识库名称 用于统计用量
+	Filter        *service.RetrieveFilter `json:"filter"`         // 过滤条件
+	Group         bool                    `json:"group"`          // 是否按分组汇总 只汇总前top_k条命中
+}
+
+// 只检索知识 返回命中的知识及相似度 不调用大模型
+func (kc *KnowledgeController) Search(c *ginctx.Context) {
+	req := new(SearchReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Question == "" {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.TopK <= 0 {
+		req.TopK = common.DefaultTopK
+	}
+
+	result, err := service.Knowledge.Retrieve(c, &service.RetrieveParams{
+		Question:      req.Question,
+		TopK:          req.TopK,
+		KnowledgeBase: req.KnowledgeBase,
+		Filter:        req.Filter,
+		Group:         req.Group,
+	})
+	if result != nil {
+		recordUsage(c, req.KnowledgeBase, result.Usage)
+	}
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+	c.JSON(0, result, "成功")
 }
 
 // 获取列表
 func (kc *KnowledgeController) GetList(c *ginctx.Context) {
 	typ, _ := strconv.Atoi(c.Query("type"))
+	generated, err := strconv.Atoi(c.Query("generated"))
+	if err != nil {
+		generated = -1
+	}
 	page, _ := strconv.Atoi(c.Query("page"))
 	pageSize, _ := strconv.Atoi(c.Query("page_size"))
 	if page <= 0 {
@@ -226,7 +439,7 @@ func (kc *KnowledgeController) GetList(c *ginctx.Context) {
 	if pageSize <= 0 {
 		pageSize = common.DefaultPageSize
 	}
-	list, total, err := service.Knowledge.GetList(c, page, pageSize, int32(typ))
+	list, total, err := service.Knowledge.GetList(c, page, pageSize, int32(typ), int32(generated))
 	if err != nil {
 		logger.Logger.Errorw("处理数据错误", "err", err)
 		c.JSON(1, nil, "处理数据错误")
@@ -281,9 +494,204 @@ func (kc *KnowledgeController) DelByIds(c *ginctx.Context) {
 	err := service.Knowledge.DelByIds(c, req.Ids)
 	if err != nil {
 		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, errMsg(err))
+		return
+	}
+
+	c.JSON(0, nil, "成功")
+}
+
+// 批量导入 支持jsonl和csv 请求体为文件内容或multipart上传的file
+func (kc *KnowledgeController) Import(c *ginctx.Context) {
+	format := c.DefaultQuery("format", service.FormatJSONL)
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	if format != service.FormatJSONL && format != service.FormatCSV {
+		logger.Logger.Warnw("参数不合法", "format", format)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	var r io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			logger.Logger.Warnw("参数不合法", "err", err)
+			c.JSON(1, nil, "参数错误")
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			logger.Logger.Errorw("读取上传文件错误", "err", err)
+			c.JSON(1, nil, "读取文件错误")
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// 异步导入 返回任务id
+	if async, _ := strconv.ParseBool(c.Query("async")); async {
+		job, err := service.Job.Enqueue(service.JobTypeImport, &service.ImportJobParams{
+			Format: format,
+			DryRun: dryRun,
+		}, r, "."+format)
+		if err != nil {
+			logger.Logger.Errorw("创建任务错误", "err", err, "format", format)
+			c.JSON(1, nil, "处理数据错误")
+			return
+		}
+		c.JSON(0, map[string]any{
+			"job_id": job.Id,
+		}, "成功")
+		return
+	}
+
+	result, err := service.Knowledge.Import(c, r, format, dryRun)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "format", format)
+		c.JSON(1, result, "处理数据错误")
+		return
+	}
+	c.JSON(0, result, "成功")
+}
+
+// 导出全部知识
+func (kc *KnowledgeController) Export(c *ginctx.Context) {
+	format := c.DefaultQuery("format", service.FormatJSONL)
+	contentType := "application/x-ndjson; charset=utf-8"
+	switch format {
+	case service.FormatJSONL:
+	case service.FormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		logger.Logger.Warnw("参数不合法", "format", format)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=knowledge.%s", format))
+	err := service.Knowledge.Export(c, c.Writer, format)
+	if err != nil {
+		// 响应已开始输出 只能记录日志
+		logger.Logger.Errorw("导出数据错误", "err", err, "format", format)
+	}
+}
+
+// 导入excel问答 file为xlsx文件 mapping为json格式的列映射
+func (kc *KnowledgeController) ImportXlsx(c *ginctx.Context) {
+	mapping := new(service.XlsxMapping)
+	if err := json.Unmarshal([]byte(c.PostForm("mapping")), mapping); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if err := mapping.Validate(); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err, "mapping", mapping)
+		c.JSON(1, nil, err.Error())
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.PostForm("dry_run"))
+	fh, err := c.FormFile("file")
+	if err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		logger.Logger.Errorw("读取上传文件错误", "err", err)
+		c.JSON(1, nil, "读取文件错误")
+		return
+	}
+	defer f.Close()
+
+	// 异步导入 返回任务id
+	if async, _ := strconv.ParseBool(c.PostForm("async")); async {
+		job, err := service.Job.Enqueue(service.JobTypeImportXlsx, &service.XlsxJobParams{
+			Mapping: mapping,
+			DryRun:  dryRun,
+		}, f, ".xlsx")
+		if err != nil {
+			logger.Logger.Errorw("创建任务错误", "err", err, "mapping", mapping)
+			c.JSON(1, nil, "处理数据错误")
+			return
+		}
+		c.JSON(0, map[string]any{
+			"job_id": job.Id,
+		}, "成功")
+		return
+	}
+
+	result, err := service.Knowledge.ImportXlsx(c, f, mapping, dryRun)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "mapping", mapping)
+		c.JSON(1, result, "处理数据错误")
+		return
+	}
+	c.JSON(0, result, "成功")
+}
+
+// 下载导入报告
+func (kc *KnowledgeController) ImportReport(c *ginctx.Context) {
+	path, err := service.GetReportPath(c.Query("name"))
+	if err != nil {
+		logger.Logger.Warnw("获取导入报告错误", "err", err, "name", c.Query("name"))
+		c.JSON(1, nil, "报告不存在")
+		return
+	}
+	c.FileAttachment(path, service.ReportFilename())
+}
+
+// 批量生成问题变体 异步执行 返回任务id
+func (kc *KnowledgeController) GenVariants(c *ginctx.Context) {
+	req := new(service.VariantJobParams)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.N <= 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	job, err := service.Job.Enqueue(service.JobTypeVariant, req, nil, "")
+	if err != nil {
+		logger.Logger.Errorw("创建任务错误", "err", err, "req", req)
 		c.JSON(1, nil, "处理数据错误")
 		return
 	}
+	c.JSON(0, map[string]any{
+		"job_id": job.Id,
+	}, "成功")
+}
 
+type ReviewVariantsReq struct {
+	Ids    []int64 `json:"ids"`
+	Accept bool    `json:"accept"`
+}
+
+// 审核模型生成的问题 通过的保留 不通过的删除
+func (kc *KnowledgeController) ReviewVariants(c *ginctx.Context) {
+	req := new(ReviewVariantsReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if len(req.Ids) == 0 {
+		logger.Logger.Warnw("参数不合法", "req", req)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+
+	err := service.Knowledge.ReviewVariants(c, req.Ids, req.Accept)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err, "req", req)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
 	c.JSON(0, nil, "成功")
 }
@@ -0,0 +1,22 @@
+package llm
+
+import (
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 大模型服务
+
+type LLMController struct {
+}
+
+func (lc *LLMController) Register(router *gin.RouterGroup) {
+	router.GET("/llm/health", ginctx.Handle(lc.Health))
+}
+
+// 查询各模型服务的熔断状态及调用统计
+func (lc *LLMController) Health(c *ginctx.Context) {
+	c.JSON(0, llm.LLMHandler.Health(), "成功")
+}
@@ -0,0 +1,46 @@
+package prompt
+
+import (
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 提示词模板
+
+type PromptController struct {
+}
+
+func (pc *PromptController) Register(router *gin.RouterGroup) {
+	router.POST("/prompt/validate", ginctx.Handle(pc.Validate))
+}
+
+type ValidateReq struct {
+	KnowledgeBase string                    `json:"knowledge_base"`
+	Prompt        *service.PromptTemplate   `json:"prompt"`
+	Question      string                    `json:"question"`
+	Documents     []*service.PromptDocument `json:"documents"` // 示例知识
+}
+
+// 使用示例知识渲染模板 返回渲染结果或模板错误
+func (pc *PromptController) Validate(c *ginctx.Context) {
+	req := new(ValidateReq)
+	if err := c.Bind(req); err != nil {
+		logger.Logger.Warnw("参数不合法", "err", err)
+		c.JSON(1, nil, "参数错误")
+		return
+	}
+	if req.Question == "" {
+		req.Question = "示例问题"
+	}
+
+	rendered, err := service.Prompt.Validate(c, req.KnowledgeBase, req.Prompt, req.Question, req.Documents)
+	if err != nil {
+		logger.Logger.Warnw("模板不合法", "err", err, "req", req)
+		c.JSON(1, nil, err.Error())
+		return
+	}
+	c.JSON(0, rendered, "成功")
+}
@@ -0,0 +1,35 @@
+package sync
+
+import (
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 目录同步
+
+type SyncController struct {
+}
+
+func (sc *SyncController) Register(router *gin.RouterGroup) {
+	router.GET("/sync/status", ginctx.Handle(sc.Status))
+	router.POST("/sync/rescan", ginctx.Handle(sc.Rescan))
+}
+
+// 查询同步目录及文件最近一次同步结果
+func (sc *SyncController) Status(c *ginctx.Context) {
+	c.JSON(0, service.Sync.Status(c), "成功")
+}
+
+// 重新全量扫描同步目录
+func (sc *SyncController) Rescan(c *ginctx.Context) {
+	err := service.Sync.Rescan(c)
+	if err != nil {
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "未配置同步目录")
+		return
+	}
+	c.JSON(0, nil, "成功")
+}
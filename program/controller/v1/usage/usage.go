@@ -0,0 +1,35 @@
+package usage
+
+import (
+	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/service"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// token用量统计
+
+type UsageController struct {
+}
+
+func (uc *UsageController) Register(router *gin.RouterGroup) {
+	router.GET("/usage/daily", ginctx.Handle(uc.Daily))
+}
+
+// 按天汇总用量及估算费用 可按knowledge_base、api_key、endpoint、type、provider、model分组
+func (uc *UsageController) Daily(c *ginctx.Context) {
+	report, err := service.Usage.Daily(c, c.Query("start"), c.Query("end"), c.Query("group_by"))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidParams) {
+			logger.Logger.Warnw("参数不合法", "err", err)
+			c.JSON(1, nil, "参数错误")
+			return
+		}
+		logger.Logger.Errorw("处理数据错误", "err", err)
+		c.JSON(1, nil, "处理数据错误")
+		return
+	}
+	c.JSON(0, report, "成功")
+}
@@ -2,7 +2,21 @@ package v1
 
 import (
 	"ai-knowledge/internal/ginctx"
+	"ai-knowledge/program/controller/v1/analytics"
+	"ai-knowledge/program/controller/v1/cache"
+	"ai-knowledge/program/controller/v1/conflict"
+	"ai-knowledge/program/controller/v1/conversation"
+	"ai-knowledge/program/controller/v1/document"
+	"ai-knowledge/program/controller/v1/eval"
+	"ai-knowledge/program/controller/v1/faq"
+	"ai-knowledge/program/controller/v1/feedback"
+	"ai-knowledge/program/controller/v1/gap"
+	"ai-knowledge/program/controller/v1/job"
 	"ai-knowledge/program/controller/v1/knowledge"
+	"ai-knowledge/program/controller/v1/llm"
+	"ai-knowledge/program/controller/v1/prompt"
+	"ai-knowledge/program/controller/v1/sync"
+	"ai-knowledge/program/controller/v1/usage"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,6 +29,20 @@ var (
 
 func init() {
 	allController = append(allController, new(knowledge.KnowledgeController))
+	allController = append(allController, new(document.DocumentController))
+	allController = append(allController, new(job.JobController))
+	allController = append(allController, new(faq.FaqController))
+	allController = append(allController, new(sync.SyncController))
+	allController = append(allController, new(prompt.PromptController))
+	allController = append(allController, new(conversation.ConversationController))
+	allController = append(allController, new(llm.LLMController))
+	allController = append(allController, new(cache.CacheController))
+	allController = append(allController, new(usage.UsageController))
+	allController = append(allController, new(feedback.FeedbackController))
+	allController = append(allController, new(eval.EvalController))
+	allController = append(allController, new(analytics.AnalyticsController))
+	allController = append(allController, new(gap.GapController))
+	allController = append(allController, new(conflict.ConflictController))
 }
 
 func Register(router *gin.RouterGroup) {
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 评价 1有帮助 -1没有帮助
+	FeedbackHelpful   = int32(1)
+	FeedbackUnhelpful = int32(-1)
+
+	// 处理状态 0待处理 1已转为问答 2已忽略
+	FeedbackStatusPending  = int32(0)
+	FeedbackStatusPromoted = int32(1)
+	FeedbackStatusIgnored  = int32(2)
+)
+
+// 用户对答案的评价
+type AnswerFeedback struct {
+	Id         int64  `gorm:"column:id;primary_key" json:"id"`
+	QueryLogId int64  `gorm:"column:query_log_id" json:"query_log_id"`
+	Rating     int32  `gorm:"column:rating" json:"rating"`
+	Comment    string `gorm:"column:comment" json:"comment"`
+	Status     int32  `gorm:"column:status" json:"status"`
+	CreatedAt  int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt  int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (AnswerFeedback) TableName() string {
+	return "answer_feedback"
+}
+
+// 创建
+func (m *AnswerFeedback) Create(feedback *AnswerFeedback) error {
+	return db.GormHandler.Table(m.TableName()).Create(feedback).Error
+}
+
+// 按状态批量更新
+func (m *AnswerFeedback) UpdateStatusByIds(ids []int64, fromStatus, status int32) error {
+	return db.GormHandler.Table(m.TableName()).
+		Where("id in (?) AND status = ?", ids, fromStatus).
+		Updates(map[string]any{
+			"status":     status,
+			"updated_at": time.Now().Unix(),
+		}).Error
+}
+
+// 更新查询记录的全部待处理评价
+func (m *AnswerFeedback) UpdateStatusByQueryLogId(queryLogId int64, fromStatus, status int32) error {
+	return db.GormHandler.Table(m.TableName()).
+		Where("query_log_id = ? AND status = ?", queryLogId, fromStatus).
+		Updates(map[string]any{
+			"status":     status,
+			"updated_at": time.Now().Unix(),
+		}).Error
+}
+
+// 分页查询 rating为0、status小于0时不过滤
+func (m *AnswerFeedback) GetList(page, pageSize int, rating, status int32) (list []*AnswerFeedback, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	if rating != 0 {
+		mydb = mydb.Where("rating = ?", rating)
+	}
+	if status >= 0 {
+		mydb = mydb.Where("status = ?", status)
+	}
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
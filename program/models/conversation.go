@@ -0,0 +1,117 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 多轮对话会话
+type Conversation struct {
+	Id            int64  `gorm:"column:id;primary_key" json:"id"`
+	KnowledgeBase string `gorm:"column:knowledge_base" json:"knowledge_base"`
+	Title         string `gorm:"column:title" json:"title"`
+	Summary       string `gorm:"column:summary" json:"summary"`             // 较早对话的摘要
+	SummarizedId  int64  `gorm:"column:summarized_id" json:"summarized_id"` // 已总结到的消息id
+	CreatedAt     int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt     int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (Conversation) TableName() string {
+	return "conversation"
+}
+
+// 创建
+func (m *Conversation) Create(conversation *Conversation) error {
+	return db.GormHandler.Table(m.TableName()).Create(conversation).Error
+}
+
+// 根据id查询
+func (m *Conversation) GetById(id int64) (*Conversation, error) {
+	conversation := new(Conversation)
+	err := db.GormHandler.Table(m.TableName()).Where("id = ?", id).First(conversation).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return conversation, err
+}
+
+// 更新数据
+func (m *Conversation) UpdateById(id int64, data map[string]any) error {
+	data["updated_at"] = time.Now().Unix()
+	return db.GormHandler.Table(m.TableName()).Where("id = ?", id).Updates(data).Error
+}
+
+// 保存摘要 summarized_id仍为fromSummarizedId时才更新 返回是否更新 防止并发请求互相覆盖
+func (m *Conversation) UpdateSummary(id, fromSummarizedId int64, summary string, summarizedId int64) (bool, error) {
+	ret := db.GormHandler.Table(m.TableName()).
+		Where("id = ? AND summarized_id = ?", id, fromSummarizedId).
+		Updates(map[string]any{
+			"summary":       summary,
+			"summarized_id": summarizedId,
+			"updated_at":    time.Now().Unix(),
+		})
+	return ret.RowsAffected > 0, ret.Error
+}
+
+// 分页查询
+func (m *Conversation) GetList(page, pageSize int) (list []*Conversation, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 删除会话及全部消息
+func (m *Conversation) DelWithMessages(id int64) error {
+	return db.GormHandler.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table(ConversationMessage{}.TableName()).Where("conversation_id = ?", id).Delete(&ConversationMessage{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Table(m.TableName()).Where("id = ?", id).Delete(m).Error
+	})
+}
+
+// 会话消息
+type ConversationMessage struct {
+	Id             int64  `gorm:"column:id;primary_key" json:"id"`
+	ConversationId int64  `gorm:"column:conversation_id" json:"conversation_id"`
+	Role           string `gorm:"column:role" json:"role"` // user assistant
+	Content        string `gorm:"column:content" json:"content"`
+	Question       string `gorm:"column:question" json:"question"` // 用户消息改写后的独立问题
+	CreatedAt      int64  `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName 表名
+func (ConversationMessage) TableName() string {
+	return "conversation_message"
+}
+
+// 批量创建
+func (m *ConversationMessage) BatchCreate(list []*ConversationMessage) error {
+	return db.GormHandler.Table(m.TableName()).Create(list).Error
+}
+
+// 查询会话中id大于afterId的消息
+func (m *ConversationMessage) GetAfter(conversationId, afterId int64) (list []*ConversationMessage, err error) {
+	err = db.GormHandler.Table(m.TableName()).
+		Where("conversation_id = ? AND id > ?", conversationId, afterId).
+		Order("id asc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 源文档
+type Document struct {
+	Id         int64  `gorm:"column:id;primary_key" json:"id"`
+	Title      string `gorm:"column:title" json:"title"`
+	Origin     string `gorm:"column:origin" json:"origin"`
+	Checksum   string `gorm:"column:checksum" json:"checksum"`
+	Version    int32  `gorm:"column:version" json:"version"`
+	ChunkCount int32  `gorm:"column:chunk_count" json:"chunk_count"`
+	CreatedAt  int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt  int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (Document) TableName() string {
+	return "document"
+}
+
+// 根据id查询
+func (m *Document) GetById(id int64) (*Document, error) {
+	document := new(Document)
+	err := db.GormHandler.Table(m.TableName()).Where("id = ?", id).First(document).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return document, err
+}
+
+// 根据来源查询
+func (m *Document) GetByOrigin(origin string) (*Document, error) {
+	document := new(Document)
+	err := db.GormHandler.Table(m.TableName()).Where("origin = ?", origin).First(document).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return document, err
+}
+
+// 查询来源以指定前缀开头的文档
+func (m *Document) GetByOriginPrefix(prefix string) ([]*Document, error) {
+	documents := make([]*Document, 0)
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)
+	err := db.GormHandler.Table(m.TableName()).Where("origin LIKE ?", escaped+"%").Order("id asc").Find(&documents).Error
+	if err == gorm.ErrRecordNotFound {
+		return documents, nil
+	}
+	return documents, err
+}
+
+// 根据id列表查询
+func (m *Document) BatchGetByIds(ids []int64) ([]*Document, error) {
+	documents := make([]*Document, 0)
+	err := db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Find(&documents).Error
+	if err == gorm.ErrRecordNotFound {
+		return documents, nil
+	}
+	return documents, err
+}
+
+// 分页查询
+func (m *Document) GetList(page, pageSize int) (list []*Document, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 保存文档并替换全部切片 文档id为0时新建文档
+func (m *Document) SaveWithChunks(document *Document, chunks []*Knowledge) error {
+	return db.GormHandler.Transaction(func(tx *gorm.DB) error {
+		if document.Id == 0 {
+			if err := tx.Table(m.TableName()).Create(document).Error; err != nil {
+				return err
+			}
+		} else {
+			err := tx.Table(m.TableName()).Where("id = ?", document.Id).Updates(map[string]any{
+				"title":       document.Title,
+				"checksum":    document.Checksum,
+				"version":     document.Version,
+				"chunk_count": document.ChunkCount,
+				"updated_at":  time.Now().Unix(),
+			}).Error
+			if err != nil {
+				return err
+			}
+			// 删除旧切片
+			err = tx.Table(Knowledge{}.TableName()).Where("document_id = ?", document.Id).Delete(&Knowledge{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		for _, v := range chunks {
+			v.DocumentId = document.Id
+		}
+		return tx.Table(Knowledge{}.TableName()).Create(chunks).Error
+	})
+}
+
+// 删除文档及全部切片
+func (m *Document) DelWithChunks(id int64) error {
+	return db.GormHandler.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table(Knowledge{}.TableName()).Where("document_id = ?", id).Delete(&Knowledge{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Table(m.TableName()).Where("id = ?", id).Delete(m).Error
+	})
+}
@@ -0,0 +1,260 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 评测运行状态 0执行中 1已完成 2失败
+	EvalRunStatusRunning  = int32(0)
+	EvalRunStatusFinished = int32(1)
+	EvalRunStatusFailed   = int32(2)
+)
+
+// 评测集
+type EvalSet struct {
+	Id          int64  `gorm:"column:id;primary_key" json:"id"`
+	Name        string `gorm:"column:name" json:"name"`
+	Description string `gorm:"column:description" json:"description"`
+	CreatedAt   int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (EvalSet) TableName() string {
+	return "eval_set"
+}
+
+// 创建评测集及用例
+func (m *EvalSet) CreateWithCases(set *EvalSet, cases []*EvalCase) error {
+	return db.GormHandler.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(m.TableName()).Create(set).Error; err != nil {
+			return err
+		}
+		if len(cases) == 0 {
+			return nil
+		}
+		for _, v := range cases {
+			v.SetId = set.Id
+		}
+		return tx.Table(EvalCase{}.TableName()).Create(cases).Error
+	})
+}
+
+// 更新评测集并替换全部用例
+func (m *EvalSet) UpdateWithCases(set *EvalSet, cases []*EvalCase) error {
+	return db.GormHandler.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table(m.TableName()).Where("id = ?", set.Id).Updates(map[string]any{
+			"name":        set.Name,
+			"description": set.Description,
+			"updated_at":  time.Now().Unix(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Table(EvalCase{}.TableName()).Where("set_id = ?", set.Id).Delete(&EvalCase{}).Error
+		if err != nil || len(cases) == 0 {
+			return err
+		}
+		for _, v := range cases {
+			v.SetId = set.Id
+		}
+		return tx.Table(EvalCase{}.TableName()).Create(cases).Error
+	})
+}
+
+// 根据id查询
+func (m *EvalSet) GetById(id int64) (*EvalSet, error) {
+	set := new(EvalSet)
+	err := db.GormHandler.Table(m.TableName()).Where("id = ?", id).First(set).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return set, err
+}
+
+// 分页查询
+func (m *EvalSet) GetList(page, pageSize int) (list []*EvalSet, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 删除评测集及用例 保留运行记录
+func (m *EvalSet) DelWithCases(id int64) error {
+	return db.GormHandler.Transaction(func(tx *gorm.DB) error {
+		err := tx.Table(EvalCase{}.TableName()).Where("set_id = ?", id).Delete(&EvalCase{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Table(m.TableName()).Where("id = ?", id).Delete(m).Error
+	})
+}
+
+// 评测用例
+type EvalCase struct {
+	Id             int64  `gorm:"column:id;primary_key" json:"id"`
+	SetId          int64  `gorm:"column:set_id" json:"set_id"`
+	Question       string `gorm:"column:question" json:"question"`
+	ExpectedIds    string `gorm:"column:expected_ids" json:"expected_ids"`       // 期望检索到的知识id 多个使用,分隔
+	ExpectedKeys   string `gorm:"column:expected_keys" json:"expected_keys"`     // 保存时由知识id转换的分组标识 知识修改后id变化仍可匹配
+	ExpectedAnswer string `gorm:"column:expected_answer" json:"expected_answer"` // 期望答案
+	CreatedAt      int64  `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName 表名
+func (EvalCase) TableName() string {
+	return "eval_case"
+}
+
+// 查询评测集的全部用例
+func (m *EvalCase) GetBySetId(setId int64) (list []*EvalCase, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("set_id = ?", setId).Order("id asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 评测运行 一次运行使用当时的配置执行评测集的全部用例
+type EvalRun struct {
+	Id               int64   `gorm:"column:id;primary_key" json:"id"`
+	SetId            int64   `gorm:"column:set_id" json:"set_id"`
+	JobId            int64   `gorm:"column:job_id" json:"job_id"` // 通过接口运行时的任务id
+	Label            string  `gorm:"column:label" json:"label"`   // 说明 如修改了哪些配置
+	Config           string  `gorm:"column:config" json:"config"` // 运行参数及配置快照 json
+	Status           int32   `gorm:"column:status" json:"status"`
+	Cases            int     `gorm:"column:cases" json:"cases"`
+	Recall           float64 `gorm:"column:recall" json:"recall"` // 平均recall@k
+	MRR              float64 `gorm:"column:mrr" json:"mrr"`
+	NDCG             float64 `gorm:"column:ndcg" json:"ndcg"`                           // 平均nDCG@k
+	AnswerSimilarity float64 `gorm:"column:answer_similarity" json:"answer_similarity"` // 答案与期望答案的平均相似度
+	Latency          int64   `gorm:"column:latency" json:"latency"`                     // 平均耗时(毫秒)
+	Message          string  `gorm:"column:message" json:"message"`
+	CreatedAt        int64   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt        int64   `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (EvalRun) TableName() string {
+	return "eval_run"
+}
+
+// 创建
+func (m *EvalRun) Create(run *EvalRun) error {
+	return db.GormHandler.Table(m.TableName()).Create(run).Error
+}
+
+// 根据id查询
+func (m *EvalRun) GetById(id int64) (*EvalRun, error) {
+	run := new(EvalRun)
+	err := db.GormHandler.Table(m.TableName()).Where("id = ?", id).First(run).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return run, err
+}
+
+// 根据任务id查询 任务重启后继续同一运行
+func (m *EvalRun) GetByJobId(jobId int64) (*EvalRun, error) {
+	run := new(EvalRun)
+	err := db.GormHandler.Table(m.TableName()).Where("job_id = ?", jobId).First(run).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return run, err
+}
+
+// 根据id列表查询
+func (m *EvalRun) BatchGetByIds(ids []int64) (list []*EvalRun, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Order("id asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 更新数据
+func (m *EvalRun) UpdateById(id int64, data map[string]any) error {
+	data["updated_at"] = time.Now().Unix()
+	return db.GormHandler.Table(m.TableName()).Where("id = ?", id).Updates(data).Error
+}
+
+// 分页查询 setId为0时查询全部
+func (m *EvalRun) GetList(page, pageSize int, setId int64) (list []*EvalRun, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	if setId > 0 {
+		mydb = mydb.Where("set_id = ?", setId)
+	}
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 单个用例的评测结果
+type EvalResult struct {
+	Id               int64   `gorm:"column:id;primary_key" json:"id"`
+	RunId            int64   `gorm:"column:run_id" json:"run_id"`
+	CaseId           int64   `gorm:"column:case_id" json:"case_id"`
+	Question         string  `gorm:"column:question" json:"question"`
+	RetrievedIds     string  `gorm:"column:retrieved_ids" json:"retrieved_ids"` // 检索到的知识id 按排名 多个使用,分隔
+	Recall           float64 `gorm:"column:recall" json:"recall"`
+	RR               float64 `gorm:"column:rr" json:"rr"` // 首个相关知识排名的倒数
+	NDCG             float64 `gorm:"column:ndcg" json:"ndcg"`
+	Answer           string  `gorm:"column:answer" json:"answer"`
+	AnswerSimilarity float64 `gorm:"column:answer_similarity" json:"answer_similarity"`
+	Latency          int64   `gorm:"column:latency" json:"latency"`
+	Error            string  `gorm:"column:error" json:"error"`
+	Missing          string  `gorm:"column:missing" json:"missing"` // 已不存在的期望知识标识 多个使用,分隔
+	CreatedAt        int64   `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName 表名
+func (EvalResult) TableName() string {
+	return "eval_result"
+}
+
+// 创建
+func (m *EvalResult) Create(result *EvalResult) error {
+	return db.GormHandler.Table(m.TableName()).Create(result).Error
+}
+
+// 查询一次运行的全部结果
+func (m *EvalResult) GetByRunId(runId int64) (list []*EvalResult, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("run_id = ?", runId).Order("id asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 查询多次运行的全部结果
+func (m *EvalResult) GetByRunIds(runIds []int64) (list []*EvalResult, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("run_id in (?)", runIds).Order("id asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
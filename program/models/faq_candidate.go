@@ -0,0 +1,94 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 候选问答状态 0待审核 1已采纳 2已拒绝
+	FaqStatusPending  = int32(0)
+	FaqStatusAccepted = int32(1)
+	FaqStatusRejected = int32(2)
+)
+
+// 模型从知识中抽取的候选问答
+type FaqCandidate struct {
+	Id          int64  `gorm:"column:id;primary_key" json:"id"`
+	KnowledgeId int64  `gorm:"column:knowledge_id" json:"knowledge_id"` // 来源知识切片 文档重新保存后切片id会变化
+	DocumentId  int64  `gorm:"column:document_id" json:"document_id"`
+	Position    int32  `gorm:"column:position" json:"position"` // 来源切片在文档中的序号 与document_id一起定位原文
+	Question    string `gorm:"column:question" json:"question"`
+	Answer      string `gorm:"column:answer" json:"answer"`
+	Status      int32  `gorm:"column:status" json:"status"`
+	GroupKey    string `gorm:"column:group_key" json:"group_key"` // 采纳后对应的问答分组
+	CreatedAt   int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (FaqCandidate) TableName() string {
+	return "faq_candidate"
+}
+
+// 批量创建
+func (m *FaqCandidate) BatchCreate(list []*FaqCandidate) error {
+	return db.GormHandler.Table(m.TableName()).Create(list).Error
+}
+
+// 根据id列表查询
+func (m *FaqCandidate) BatchGetByIds(ids []int64) (list []*FaqCandidate, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Order("id asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 更新数据
+func (m *FaqCandidate) UpdateById(id int64, data map[string]any) error {
+	data["updated_at"] = time.Now().Unix()
+	return db.GormHandler.Table(m.TableName()).Where("id = ?", id).Updates(data).Error
+}
+
+// 状态为fromStatus时更新 返回是否更新 用于并发处理同一候选时只有一个成功
+func (m *FaqCandidate) UpdateByStatus(id int64, fromStatus int32, data map[string]any) (bool, error) {
+	data["updated_at"] = time.Now().Unix()
+	ret := db.GormHandler.Table(m.TableName()).Where("id = ? AND status = ?", id, fromStatus).Updates(data)
+	return ret.RowsAffected > 0, ret.Error
+}
+
+// 按状态批量更新
+func (m *FaqCandidate) UpdateStatusByIds(ids []int64, fromStatus, status int32) error {
+	return db.GormHandler.Table(m.TableName()).
+		Where("id in (?) AND status = ?", ids, fromStatus).
+		Updates(map[string]any{
+			"status":     status,
+			"updated_at": time.Now().Unix(),
+		}).Error
+}
+
+// 分页查询
+func (m *FaqCandidate) GetList(page, pageSize int, status int32, documentId int64) (list []*FaqCandidate, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	if status >= 0 {
+		mydb = mydb.Where("status = ?", status)
+	}
+	if documentId > 0 {
+		mydb = mydb.Where("document_id = ?", documentId)
+	}
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 任务状态 0等待 1执行中 2成功 3失败 4已取消
+	JobStatusPending   = int32(0)
+	JobStatusRunning   = int32(1)
+	JobStatusSucceeded = int32(2)
+	JobStatusFailed    = int32(3)
+	JobStatusCanceled  = int32(4)
+)
+
+// 异步任务
+type Job struct {
+	Id         int64  `gorm:"column:id;primary_key" json:"id"`
+	Type       string `gorm:"column:type" json:"type"`
+	Status     int32  `gorm:"column:status" json:"status"`
+	Params     string `gorm:"column:params" json:"params"` // 任务参数 json
+	File       string `gorm:"column:file" json:"-"`        // 上传文件保存路径
+	Total      int64  `gorm:"column:total" json:"total"`
+	Processed  int64  `gorm:"column:processed" json:"processed"` // 已处理行数 重启后从此处继续
+	Succeeded  int64  `gorm:"column:succeeded" json:"succeeded"`
+	Failed     int64  `gorm:"column:failed" json:"failed"`
+	Errors     string `gorm:"column:errors" json:"errors"` // 行错误 json
+	Result     string `gorm:"column:result" json:"result"` // 任务结果 json
+	Message    string `gorm:"column:message" json:"message"`
+	FinishedAt int64  `gorm:"column:finished_at" json:"finished_at"`
+	CreatedAt  int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt  int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (Job) TableName() string {
+	return "job"
+}
+
+// 创建
+func (m *Job) Create(job *Job) error {
+	return db.GormHandler.Table(m.TableName()).Create(job).Error
+}
+
+// 根据id查询
+func (m *Job) GetById(id int64) (*Job, error) {
+	job := new(Job)
+	err := db.GormHandler.Table(m.TableName()).Where("id = ?", id).First(job).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return job, err
+}
+
+// 更新数据
+func (m *Job) UpdateById(id int64, data map[string]any) error {
+	data["updated_at"] = time.Now().Unix()
+	return db.GormHandler.Table(m.TableName()).Where("id = ?", id).Updates(data).Error
+}
+
+// 按状态更新 返回是否更新成功
+func (m *Job) UpdateByStatus(id int64, status []int32, data map[string]any) (bool, error) {
+	data["updated_at"] = time.Now().Unix()
+	ret := db.GormHandler.Table(m.TableName()).Where("id = ? AND status in (?)", id, status).Updates(data)
+	return ret.RowsAffected > 0, ret.Error
+}
+
+// 查询指定状态的任务
+func (m *Job) GetByStatus(status []int32) (list []*Job, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("status in (?)", status).Order("id asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 分页查询
+func (m *Job) GetList(page, pageSize int, typ string, status int32) (list []*Job, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	if typ != "" {
+		mydb = mydb.Where("type = ?", typ)
+	}
+	if status >= 0 {
+		mydb = mydb.Where("status = ?", status)
+	}
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
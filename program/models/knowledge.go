@@ -12,6 +12,11 @@ var (
 	// 类型 0问答 1纯知识
 	KnowledgeTypeQAndA = int32(0)
 	KnowledgeTypePure  = int32(1)
+
+	// 问题来源 0人工录入 1模型生成待审核 2模型生成已确认
+	GeneratedNone     = int32(0)
+	GeneratedPending  = int32(1)
+	GeneratedAccepted = int32(2)
 )
 
 // 知识库
@@ -23,8 +28,14 @@ type Knowledge struct {
 	VectorId  int64  `gorm:"column:vector_id" json:"vector_id"`
 	Type      int32  `gorm:"column:type" json:"type"`
 	GroupKey  string `gorm:"column:group_key" json:"group_key"`
-	CreatedAt int64  `gorm:"column:created_at" json:"created_at"`
-	UpdatedAt int64  `gorm:"column:updated_at" json:"updated_at"`
+	Tags      string `gorm:"column:tags" json:"tags"`           // 标签 多个使用,分隔
+	Generated int32  `gorm:"column:generated" json:"generated"` // 问题来源
+	// 来源文档及切片位置 非文档知识为0
+	DocumentId int64  `gorm:"column:document_id" json:"document_id"`
+	Position   int32  `gorm:"column:position" json:"position"`
+	Section    string `gorm:"column:section" json:"section"`
+	CreatedAt  int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt  int64  `gorm:"column:updated_at" json:"updated_at"`
 }
 
 // TableName 表名
@@ -52,6 +63,16 @@ func (m *Knowledge) BatchGetByIds(ids []int64) ([]*Knowledge, error) {
 	return knowledges, err
 }
 
+// 根据主键id列表查询
+func (m *Knowledge) BatchGetByPks(ids []int64) ([]*Knowledge, error) {
+	knowledges := make([]*Knowledge, 0)
+	err := db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Find(&knowledges).Error
+	if err == gorm.ErrRecordNotFound {
+		return knowledges, nil
+	}
+	return knowledges, err
+}
+
 // 根据id查询
 func (m *Knowledge) GetById(id int64) (*Knowledge, error) {
 	knowledge := new(Knowledge)
@@ -69,11 +90,14 @@ func (m *Knowledge) UpdateById(id int64, data map[string]any) error {
 }
 
 // 分组查询分页
-func (m *Knowledge) GetList(page, pageSize int, typ int32) (list []*Knowledge, total int64, err error) {
+func (m *Knowledge) GetList(page, pageSize int, typ int32, generated int32) (list []*Knowledge, total int64, err error) {
 	mydb := db.GormHandler.Table(m.TableName())
 	if typ > 0 {
 		mydb = mydb.Where("type =?", typ)
 	}
+	if generated >= 0 {
+		mydb = mydb.Where("generated =?", generated)
+	}
 	err = mydb.Count(&total).Error
 	if err != nil {
 		return
@@ -97,7 +121,124 @@ func (m *Knowledge) GetByGroupKey(groupKey string) (list []*Knowledge, err error
 	return
 }
 
+// 查询全部分组标识 按创建顺序排列 不包含文档切片
+func (m *Knowledge) GetGroupKeys() (groupKeys []string, err error) {
+	err = db.GormHandler.Table(m.TableName()).
+		Where("document_id = 0").
+		Group("group_key").
+		Order("MIN(id) asc").
+		Pluck("group_key", &groupKeys).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 查询全部分组标识 包含文档切片 按创建顺序排列
+func (m *Knowledge) GetAllGroupKeys() (groupKeys []string, err error) {
+	err = db.GormHandler.Table(m.TableName()).
+		Where("group_key <> ''").
+		Group("group_key").
+		Order("MIN(id) asc").
+		Pluck("group_key", &groupKeys).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 查询指定类型的全部分组标识 不包含文档切片
+func (m *Knowledge) GetGroupKeysByType(typ int32) (groupKeys []string, err error) {
+	err = db.GormHandler.Table(m.TableName()).
+		Where("document_id = 0 AND type = ?", typ).
+		Group("group_key").
+		Order("MIN(id) asc").
+		Pluck("group_key", &groupKeys).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 根据分组标识列表查询
+func (m *Knowledge) GetByGroupKeys(groupKeys []string) (list []*Knowledge, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("group_key in (?)", groupKeys).Order("id asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 统计各分组的知识条数
+func (m *Knowledge) CountByGroupKeys(groupKeys []string) (map[string]int64, error) {
+	type groupCount struct {
+		GroupKey string
+		Total    int64
+	}
+	list := make([]*groupCount, 0)
+	err := db.GormHandler.Table(m.TableName()).
+		Select("group_key, COUNT(*) AS total").
+		Where("group_key in (?)", groupKeys).
+		Group("group_key").
+		Scan(&list).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(list))
+	for _, v := range list {
+		counts[v.GroupKey] = v.Total
+	}
+	return counts, nil
+}
+
+// 分页查询since之前创建且之后未被检索到的知识
+func (m *Knowledge) GetNotRetrieved(since int64, page, pageSize int) (list []*Knowledge, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName()).
+		Where("created_at < ?", since).
+		Where("id NOT IN (?)", db.GormHandler.Table(QueryLogHit{}.TableName()).
+			Select("DISTINCT knowledge_id").
+			Where("created_at >= ?", since))
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id asc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 根据问题查询问答 用于判重
+func (m *Knowledge) GetByQuestions(questions []string) (list []*Knowledge, err error) {
+	err = db.GormHandler.Table(m.TableName()).
+		Where("type = ? AND question in (?)", KnowledgeTypeQAndA, questions).
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 根据主键id更新
+func (m *Knowledge) UpdateByPks(ids []int64, data map[string]any) error {
+	data["updated_at"] = time.Now().Unix()
+	return db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Updates(data).Error
+}
+
 // 根据id删除
 func (m *Knowledge) DelByIds(ids []int64) error {
 	return db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Delete(m).Error
 }
+
+// 根据文档id查询全部切片
+func (m *Knowledge) GetByDocumentId(documentId int64) (list []*Knowledge, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("document_id =?", documentId).Order("position asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 是否矛盾 0否 1是
+	ConflictNo  = int32(0)
+	ConflictYes = int32(1)
+
+	// 审核状态 0待处理 1已忽略
+	ConflictStatusPending = int32(0)
+	ConflictStatusIgnored = int32(1)
+)
+
+// 知识矛盾检查结果 不矛盾的结果同样保存 内容未变化时不再重复检查
+type KnowledgeConflict struct {
+	Id           int64   `gorm:"column:id;primary_key" json:"id"`
+	GroupKeyA    string  `gorm:"column:group_key_a" json:"group_key_a"`
+	KnowledgeIdA int64   `gorm:"column:knowledge_id_a" json:"knowledge_id_a"` // 相似的知识
+	ContentA     string  `gorm:"column:content_a" json:"content_a"`           // 检查时的内容 问答为整组问题及答案
+	GroupKeyB    string  `gorm:"column:group_key_b" json:"group_key_b"`
+	KnowledgeIdB int64   `gorm:"column:knowledge_id_b" json:"knowledge_id_b"`
+	ContentB     string  `gorm:"column:content_b" json:"content_b"`
+	Similarity   float32 `gorm:"column:similarity" json:"similarity"`
+	Conflict     int32   `gorm:"column:conflict" json:"conflict"`
+	Explanation  string  `gorm:"column:explanation" json:"explanation"` // 矛盾说明
+	Digest       string  `gorm:"column:digest" json:"-"`                // 两条内容的校验和
+	Status       int32   `gorm:"column:status" json:"status"`
+	CreatedAt    int64   `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt    int64   `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (KnowledgeConflict) TableName() string {
+	return "knowledge_conflict"
+}
+
+// 创建
+func (m *KnowledgeConflict) Create(conflict *KnowledgeConflict) error {
+	return db.GormHandler.Table(m.TableName()).Create(conflict).Error
+}
+
+// 根据内容校验和查询
+func (m *KnowledgeConflict) GetByDigest(digest string) (*KnowledgeConflict, error) {
+	conflict := new(KnowledgeConflict)
+	err := db.GormHandler.Table(m.TableName()).Where("digest = ?", digest).First(conflict).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return conflict, err
+}
+
+// 查询分组相关的全部检查结果
+func (m *KnowledgeConflict) GetByGroupKey(groupKey string) (list []*KnowledgeConflict, err error) {
+	err = db.GormHandler.Table(m.TableName()).
+		Where("group_key_a = ? OR group_key_b = ?", groupKey, groupKey).
+		Order("id asc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 根据id列表删除
+func (m *KnowledgeConflict) DelByIds(ids []int64) error {
+	return db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Delete(m).Error
+}
+
+// 按状态批量更新
+func (m *KnowledgeConflict) UpdateStatusByIds(ids []int64, fromStatus, status int32) error {
+	return db.GormHandler.Table(m.TableName()).
+		Where("id in (?) AND status = ?", ids, fromStatus).
+		Updates(map[string]any{
+			"status":     status,
+			"updated_at": time.Now().Unix(),
+		}).Error
+}
+
+// 分页查询矛盾 status小于0时不过滤 按相似度从高到低
+func (m *KnowledgeConflict) GetList(page, pageSize int, status int32) (list []*KnowledgeConflict, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName()).Where("conflict = ?", ConflictYes)
+	if status >= 0 {
+		mydb = mydb.Where("status = ?", status)
+	}
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("similarity desc, id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 知识缺口状态 0待处理 1已转为问答 2已忽略
+	GapStatusPending  = int32(0)
+	GapStatusPromoted = int32(1)
+	GapStatusIgnored  = int32(2)
+)
+
+// 知识缺口 未回答问题聚类后由模型总结的主题
+type KnowledgeGap struct {
+	Id          int64  `gorm:"column:id;primary_key" json:"id"`
+	JobId       int64  `gorm:"column:job_id" json:"job_id"` // 分析任务id
+	Topic       string `gorm:"column:topic" json:"topic"`
+	Description string `gorm:"column:description" json:"description"`
+	Samples     string `gorm:"column:samples" json:"-"`           // 示例问题 json数组
+	Queries     int64  `gorm:"column:queries" json:"queries"`     // 主题下的查询次数
+	Questions   int64  `gorm:"column:questions" json:"questions"` // 主题下的不同问题数
+	Status      int32  `gorm:"column:status" json:"status"`
+	GroupKey    string `gorm:"column:group_key" json:"group_key"` // 转为问答后的分组
+	CreatedAt   int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (KnowledgeGap) TableName() string {
+	return "knowledge_gap"
+}
+
+// 创建
+func (m *KnowledgeGap) Create(gap *KnowledgeGap) error {
+	return db.GormHandler.Table(m.TableName()).Create(gap).Error
+}
+
+// 根据id查询
+func (m *KnowledgeGap) GetById(id int64) (*KnowledgeGap, error) {
+	gap := new(KnowledgeGap)
+	err := db.GormHandler.Table(m.TableName()).Where("id = ?", id).First(gap).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return gap, err
+}
+
+// 更新数据
+func (m *KnowledgeGap) UpdateById(id int64, data map[string]any) error {
+	data["updated_at"] = time.Now().Unix()
+	return db.GormHandler.Table(m.TableName()).Where("id = ?", id).Updates(data).Error
+}
+
+// 状态为fromStatus时更新 返回是否更新 用于并发处理同一缺口时只有一个成功
+func (m *KnowledgeGap) UpdateByStatus(id int64, fromStatus int32, data map[string]any) (bool, error) {
+	data["updated_at"] = time.Now().Unix()
+	ret := db.GormHandler.Table(m.TableName()).Where("id = ? AND status = ?", id, fromStatus).Updates(data)
+	return ret.RowsAffected > 0, ret.Error
+}
+
+// 按状态批量更新
+func (m *KnowledgeGap) UpdateStatusByIds(ids []int64, fromStatus, status int32) error {
+	return db.GormHandler.Table(m.TableName()).
+		Where("id in (?) AND status = ?", ids, fromStatus).
+		Updates(map[string]any{
+			"status":     status,
+			"updated_at": time.Now().Unix(),
+		}).Error
+}
+
+// 分页查询 jobId为0、status小于0时不过滤 同一任务按查询次数从多到少
+func (m *KnowledgeGap) GetList(page, pageSize int, jobId int64, status int32) (list []*KnowledgeGap, total int64, err error) {
+	mydb := db.GormHandler.Table(m.TableName())
+	if jobId > 0 {
+		mydb = mydb.Where("job_id = ?", jobId)
+	}
+	if status >= 0 {
+		mydb = mydb.Where("status = ?", status)
+	}
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("job_id desc, queries desc, id asc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
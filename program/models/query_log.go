@@ -0,0 +1,284 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 是否兜底回答 0否 1是 未检索到知识、模型无法回答或出错时为兜底
+	QueryFallbackNo  = int32(0)
+	QueryFallbackYes = int32(1)
+)
+
+// 问答查询记录
+type QueryLog struct {
+	Id                 int64  `gorm:"column:id;primary_key" json:"id"`
+	KnowledgeBase      string `gorm:"column:knowledge_base" json:"knowledge_base"`
+	ConversationId     int64  `gorm:"column:conversation_id" json:"conversation_id"`
+	Question           string `gorm:"column:question" json:"question"`
+	StandaloneQuestion string `gorm:"column:standalone_question" json:"standalone_question"` // 多轮对话改写后的问题
+	Answer             string `gorm:"column:answer" json:"answer"`
+	AnswerSource       string `gorm:"column:answer_source" json:"answer_source"` // retrieved generated cached
+	KnowledgeIds       string `gorm:"column:knowledge_ids" json:"knowledge_ids"` // 检索到的知识id 多个使用,分隔
+	Channel            string `gorm:"column:channel" json:"channel"`             // 调用渠道
+	Fallback           int32  `gorm:"column:fallback" json:"fallback"`
+	Latency            int64  `gorm:"column:latency" json:"latency"` // 耗时(毫秒)
+	Error              string `gorm:"column:error" json:"error"`
+	GroupKey           string `gorm:"column:group_key" json:"group_key"` // 答案转为问答后的分组
+	CreatedAt          int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt          int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 表名
+func (QueryLog) TableName() string {
+	return "query_log"
+}
+
+// 创建查询记录及检索命中
+func (m *QueryLog) CreateWithHits(log *QueryLog, hits []*QueryLogHit) error {
+	return db.GormHandler.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(m.TableName()).Create(log).Error; err != nil {
+			return err
+		}
+		if len(hits) == 0 {
+			return nil
+		}
+		for _, v := range hits {
+			v.QueryLogId = log.Id
+		}
+		return tx.Table(QueryLogHit{}.TableName()).Create(hits).Error
+	})
+}
+
+// 根据id查询
+func (m *QueryLog) GetById(id int64) (*QueryLog, error) {
+	log := new(QueryLog)
+	err := db.GormHandler.Table(m.TableName()).Where("id = ?", id).First(log).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return log, err
+}
+
+// 根据id列表查询
+func (m *QueryLog) BatchGetByIds(ids []int64) (list []*QueryLog, err error) {
+	err = db.GormHandler.Table(m.TableName()).Where("id in (?)", ids).Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 更新数据
+func (m *QueryLog) UpdateById(id int64, data map[string]any) error {
+	data["updated_at"] = time.Now().Unix()
+	return db.GormHandler.Table(m.TableName()).Where("id = ?", id).Updates(data).Error
+}
+
+// 分组标识为fromGroupKey时更新 返回是否更新 用于并发转换同一答案时只有一个成功
+func (m *QueryLog) UpdateGroupKey(id int64, fromGroupKey, groupKey string) (bool, error) {
+	ret := db.GormHandler.Table(m.TableName()).
+		Where("id = ? AND group_key = ?", id, fromGroupKey).
+		Updates(map[string]any{
+			"group_key":  groupKey,
+			"updated_at": time.Now().Unix(),
+		})
+	return ret.RowsAffected > 0, ret.Error
+}
+
+// 按创建时间范围过滤 为0时不限制
+func (m *QueryLog) between(start, end int64) *gorm.DB {
+	mydb := db.GormHandler.Table(m.TableName())
+	if start > 0 {
+		mydb = mydb.Where("created_at >= ?", start)
+	}
+	if end > 0 {
+		mydb = mydb.Where("created_at < ?", end)
+	}
+	return mydb
+}
+
+// 分页查询 channel为空、fallback小于0时不过滤
+func (m *QueryLog) GetList(page, pageSize int, start, end int64, channel string, fallback int32) (list []*QueryLog, total int64, err error) {
+	mydb := m.between(start, end)
+	if channel != "" {
+		mydb = mydb.Where("channel = ?", channel)
+	}
+	if fallback >= 0 {
+		mydb = mydb.Where("fallback = ?", fallback)
+	}
+	err = mydb.Count(&total).Error
+	if err != nil {
+		return
+	}
+	err = mydb.Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Order("id desc").
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 查询次数
+func (m *QueryLog) Count(start, end int64) (total int64, err error) {
+	err = m.between(start, end).Count(&total).Error
+	return
+}
+
+// 按问题汇总
+type QuestionStat struct {
+	Question  string `gorm:"column:question" json:"question"`
+	Total     int64  `gorm:"column:total" json:"total"`         // 查询次数
+	Fallbacks int64  `gorm:"column:fallbacks" json:"fallbacks"` // 兜底次数
+	LastAt    int64  `gorm:"column:last_at" json:"last_at"`     // 最近一次查询时间
+}
+
+// 查询次数最多的问题
+func (m *QueryLog) GetTopQuestions(start, end int64, limit int) ([]*QuestionStat, error) {
+	list := make([]*QuestionStat, 0)
+	err := m.between(start, end).
+		Select("question, COUNT(*) AS total, SUM(fallback) AS fallbacks, MAX(created_at) AS last_at").
+		Group("question").
+		Order("total desc").
+		Limit(limit).
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		return list, nil
+	}
+	return list, err
+}
+
+// 回答率最低的问题 只包含有兜底的问题 回答率相同时查询次数多的在前
+func (m *QueryLog) GetLeastAnswered(start, end int64, limit int) ([]*QuestionStat, error) {
+	list := make([]*QuestionStat, 0)
+	err := m.between(start, end).
+		Select("question, COUNT(*) AS total, SUM(fallback) AS fallbacks, MAX(created_at) AS last_at").
+		Group("question").
+		Having("SUM(fallback) > 0").
+		Order("(COUNT(*) - SUM(fallback)) / COUNT(*) asc, total desc").
+		Limit(limit).
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		return list, nil
+	}
+	return list, err
+}
+
+// 耗时的百分位数 percents取值0到1
+func (m *QueryLog) GetLatencyPercentiles(start, end int64, percents []float64) (total int64, avg float64, values []int64, err error) {
+	values = make([]int64, len(percents))
+	row := struct {
+		Total int64
+		Avg   float64
+	}{}
+	err = m.between(start, end).Where("error = ''").
+		Select("COUNT(*) AS total, COALESCE(AVG(latency), 0) AS avg").
+		Scan(&row).Error
+	if err != nil || row.Total == 0 {
+		return
+	}
+	total, avg = row.Total, row.Avg
+	for i, p := range percents {
+		latencies := make([]int64, 0, 1)
+		err = m.between(start, end).Where("error = ''").
+			Order("latency asc").
+			Offset(int(p*float64(total-1))).
+			Limit(1).
+			Pluck("latency", &latencies).Error
+		if err != nil {
+			return
+		}
+		if len(latencies) > 0 {
+			values[i] = latencies[0]
+		}
+	}
+	return
+}
+
+// 查询检索到的知识
+type QueryLogHit struct {
+	Id          int64   `gorm:"column:id;primary_key" json:"id"`
+	QueryLogId  int64   `gorm:"column:query_log_id" json:"query_log_id"`
+	KnowledgeId int64   `gorm:"column:knowledge_id" json:"knowledge_id"`
+	Position    int32   `gorm:"column:position" json:"position"` // 排名 从1开始
+	Similarity  float32 `gorm:"column:similarity" json:"similarity"`
+	CreatedAt   int64   `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName 表名
+func (QueryLogHit) TableName() string {
+	return "query_log_hit"
+}
+
+// 按知识汇总命中
+type KnowledgeHitStat struct {
+	KnowledgeId   int64   `gorm:"column:knowledge_id" json:"knowledge_id"`
+	Hits          int64   `gorm:"column:hits" json:"hits"`                     // 被检索到的次数
+	TopHits       int64   `gorm:"column:top_hits" json:"top_hits"`             // 排名第一的次数
+	AvgSimilarity float64 `gorm:"column:avg_similarity" json:"avg_similarity"` // 平均相似度
+	LastAt        int64   `gorm:"column:last_at" json:"last_at"`               // 最近一次被检索到的时间
+}
+
+// 按创建时间范围过滤 为0时不限制
+func (m *QueryLogHit) between(start, end int64) *gorm.DB {
+	mydb := db.GormHandler.Table(m.TableName())
+	if start > 0 {
+		mydb = mydb.Where("created_at >= ?", start)
+	}
+	if end > 0 {
+		mydb = mydb.Where("created_at < ?", end)
+	}
+	return mydb
+}
+
+// 各知识被检索到的次数 按次数从多到少分页
+func (m *QueryLogHit) GetKnowledgeStats(start, end int64, page, pageSize int) (list []*KnowledgeHitStat, total int64, err error) {
+	err = m.between(start, end).Select("COUNT(DISTINCT knowledge_id)").Scan(&total).Error
+	if err != nil {
+		return
+	}
+	err = m.between(start, end).
+		Select("knowledge_id, COUNT(*) AS hits, SUM(CASE WHEN position = 1 THEN 1 ELSE 0 END) AS top_hits, " +
+			"AVG(similarity) AS avg_similarity, MAX(created_at) AS last_at").
+		Group("knowledge_id").
+		Order("hits desc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	return
+}
+
+// 未回答的查询 兜底回答、检索到的最高相似度低于minSimilarity(包括未检索到知识)或评价为没有帮助 按id从新到旧
+// until大于0时只计入until及之前的评价 任务重启后得到同一批查询
+func (m *QueryLog) GetUnanswered(start, end, until int64, knowledgeBase string, minSimilarity float32, limit int) ([]*QueryLog, error) {
+	list := make([]*QueryLog, 0)
+	mydb := m.between(start, end)
+	if knowledgeBase != "" {
+		mydb = mydb.Where("knowledge_base = ?", knowledgeBase)
+	}
+	answered := db.GormHandler.Table(QueryLogHit{}.TableName()).
+		Select("query_log_id").
+		Where("position = 1 AND similarity >= ?", minSimilarity)
+	unhelpful := db.GormHandler.Table(AnswerFeedback{}.TableName()).
+		Select("query_log_id").
+		Where("rating = ?", FeedbackUnhelpful)
+	if until > 0 {
+		unhelpful = unhelpful.Where("created_at <= ?", until)
+	}
+	err := mydb.Where("fallback = ? OR id NOT IN (?) OR id IN (?)", QueryFallbackYes, answered, unhelpful).
+		Order("id desc").
+		Limit(limit).
+		Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		return list, nil
+	}
+	return list, err
+}
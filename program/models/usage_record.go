@@ -0,0 +1,81 @@
+package models
+
+import (
+	"ai-knowledge/internal/db"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// 用量类型
+	UsageTypeLLM       = "llm"
+	UsageTypeEmbedding = "embedding"
+)
+
+// 用量记录 每次请求每个模型一条
+type UsageRecord struct {
+	Id               int64   `gorm:"column:id;primary_key" json:"id"`
+	RequestId        string  `gorm:"column:request_id" json:"request_id"` // 同一请求的记录相同
+	KnowledgeBase    string  `gorm:"column:knowledge_base" json:"knowledge_base"`
+	ApiKey           string  `gorm:"column:api_key" json:"api_key"`   // 调用方密钥 脱敏
+	Endpoint         string  `gorm:"column:endpoint" json:"endpoint"` // 接口路径
+	Type             string  `gorm:"column:type" json:"type"`         // llm embedding
+	Provider         string  `gorm:"column:provider" json:"provider"` // 实际响应的模型服务
+	Model            string  `gorm:"column:model" json:"model"`
+	PromptTokens     int     `gorm:"column:prompt_tokens" json:"prompt_tokens"`
+	CompletionTokens int     `gorm:"column:completion_tokens" json:"completion_tokens"`
+	TotalTokens      int     `gorm:"column:total_tokens" json:"total_tokens"`
+	Calls            int     `gorm:"column:calls" json:"calls"`
+	Cost             float64 `gorm:"column:cost" json:"cost"`
+	Day              string  `gorm:"column:day" json:"day"` // 日期 2006-01-02
+	CreatedAt        int64   `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName 表名
+func (UsageRecord) TableName() string {
+	return "usage_record"
+}
+
+// 批量创建
+func (m *UsageRecord) BatchCreate(records []*UsageRecord) error {
+	return db.GormHandler.Table(m.TableName()).Create(records).Error
+}
+
+// 按天汇总
+type UsageDaily struct {
+	Day              string  `gorm:"column:day" json:"day"`
+	Key              string  `gorm:"column:group_key" json:"key"` // 分组字段的值
+	Requests         int64   `gorm:"column:requests" json:"requests"`
+	PromptTokens     int64   `gorm:"column:prompt_tokens" json:"prompt_tokens"`
+	CompletionTokens int64   `gorm:"column:completion_tokens" json:"completion_tokens"`
+	TotalTokens      int64   `gorm:"column:total_tokens" json:"total_tokens"`
+	Calls            int64   `gorm:"column:calls" json:"calls"`
+	Cost             float64 `gorm:"column:cost" json:"cost"`
+}
+
+// 按天及指定字段汇总 groupBy为空时只按天汇总 调用方需校验groupBy
+func (m *UsageRecord) GetDaily(startDay, endDay, groupBy string) ([]*UsageDaily, error) {
+	list := make([]*UsageDaily, 0)
+	key := "''"
+	group := "day"
+	if groupBy != "" {
+		key = fmt.Sprintf("`%s`", groupBy)
+		group = fmt.Sprintf("day, `%s`", groupBy)
+	}
+	mydb := db.GormHandler.Table(m.TableName()).
+		Select(fmt.Sprintf("day, %s as group_key, count(distinct request_id) as requests, "+
+			"sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens, "+
+			"sum(total_tokens) as total_tokens, sum(calls) as calls, sum(cost) as cost", key))
+	if startDay != "" {
+		mydb = mydb.Where("day >= ?", startDay)
+	}
+	if endDay != "" {
+		mydb = mydb.Where("day <= ?", endDay)
+	}
+	err := mydb.Group(group).Order("day asc").Scan(&list).Error
+	if err == gorm.ErrRecordNotFound {
+		return list, nil
+	}
+	return list, err
+}
@@ -15,6 +15,7 @@ import (
 	"ai-knowledge/internal/logger"
 	"ai-knowledge/internal/milvus"
 	"ai-knowledge/program/controller"
+	"ai-knowledge/program/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -47,14 +48,15 @@ func (p *Program) Run() {
 		js, _ := json.Marshal(p.cfg)
 		log.Println(string(js))
 	}
-	// 连接数据库
-	db.InitDB(p.cfg.Debug, p.cfg.DB)
-	// 初始化milvus
-	milvus.InitMilvus(p.cfg.Milvus)
-	// 初始化向量处理
-	embedding.InitTextEmbeddingOperator(p.cfg.Embedding)
-	// 初始化llm
-	llm.InitLLM(p.cfg.LLM)
+	// 初始化依赖组件
+	p.initComponents()
+
+	// 启动异步任务
+	service.Job.Start()
+	// 启动目录同步
+	service.Sync.Start()
+	// 启动保存知识后的矛盾检测
+	service.Conflict.Start()
 
 	// 启动http监听
 	router := gin.Default()
@@ -77,6 +79,20 @@ func (p *Program) Run() {
 	}()
 }
 
+// 初始化数据库、向量库、模型等组件
+func (p *Program) initComponents() {
+	// 连接数据库
+	db.InitDB(p.cfg.Debug, p.cfg.DB)
+	// 初始化milvus
+	milvus.InitMilvus(p.cfg.Milvus)
+	// 初始化向量处理
+	embedding.InitTextEmbeddingOperator(p.cfg.Embedding)
+	// 初始化llm
+	llm.InitLLM(p.cfg.LLM)
+	// 初始化业务配置
+	service.InitService(p.cfg)
+}
+
 // Stop 程序结束要做的事
 func (p *Program) Stop() {
 	if p.srv == nil {
@@ -88,6 +104,13 @@ func (p *Program) Stop() {
 		log.Fatal("Server forced to shutdown:", err)
 	}
 
+	// 停止目录同步
+	service.Sync.Stop()
+	// 停止矛盾检测 未提交的分组保存为任务
+	service.Conflict.Stop()
+	// 停止异步任务 执行中的任务下次启动后继续
+	service.Job.Stop()
+
 	// 销毁链接
 	milvus.MilvusHandler.Destroy()
 
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+/* 语义答案缓存 */
+
+const (
+	defaultCacheThreshold  = 0.95
+	defaultCacheMaxEntries = 10000
+	// 知识失效记录保留时间 生成答案期间知识被修改时不写入缓存
+	cacheInvalidationKeep = 10 * time.Minute
+)
+
+var (
+	AnswerCache = &AnswerCacheService{
+		invalidated: make(map[int64]time.Time),
+	}
+)
+
+// 缓存相似问题的已生成答案 知识修改或删除时使相关缓存失效
+type AnswerCacheService struct {
+	mu          sync.RWMutex
+	entries     []*cacheEntry       // 按写入顺序
+	invalidated map[int64]time.Time // 知识id -> 最近失效时间
+	nextId      int64
+	hits        int64 // 命中次数
+	misses      int64 // 未命中次数
+}
+
+// 缓存条目
+type cacheEntry struct {
+	id            int64
+	knowledgeBase string
+	topK          int
+	question      string
+	vector        []float32
+	answer        string
+	segments      []*AnswerSegment
+	knowledges    []*SearchKnowledge
+	createdAt     time.Time
+}
+
+// 缓存命中信息
+type SearchCache struct {
+	Id         int64   `json:"id"`
+	Question   string  `json:"question"`   // 缓存的问题
+	Similarity float32 `json:"similarity"` // 与缓存问题的相似度
+}
+
+// 缓存统计
+type CacheStats struct {
+	Enabled bool  `json:"enabled"`
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// 是否使用缓存 多轮对话及覆盖了模板、生成参数、回答方式、问题处理、直接返回问答的请求不使用
+func (s *AnswerCacheService) usable(params *SearchParams) bool {
+	if cfg.Cache == nil || !cfg.Cache.Enabled {
+		return false
+	}
+	if params.RetrieveOnly || (params.Cache != nil && !*params.Cache) {
+		return false
+	}
+	return params.ConversationId == 0 && params.Prompt == nil && params.LLMParams == nil &&
+		params.Chain == "" && params.Strategies == nil && params.DirectAnswer == nil
+}
+
+func cacheConfig() config.CacheConfig {
+	c := config.CacheConfig{}
+	if cfg.Cache != nil {
+		c = *cfg.Cache
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = defaultCacheThreshold
+	}
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = defaultCacheMaxEntries
+	}
+	return c
+}
+
+// 向量距离 与milvus的L2距离一致(平方和)
+func l2Distance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return -1
+	}
+	sum := float32(0)
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+// 查找相似度达到阈值的最相似缓存
+func (s *AnswerCacheService) lookup(knowledgeBase string, topK int, vector []float32) (*cacheEntry, float32) {
+	c := cacheConfig()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *cacheEntry
+	bestSimilarity := float32(0)
+	for _, v := range s.entries {
+		if v.knowledgeBase != knowledgeBase || v.topK != topK {
+			continue
+		}
+		if c.TTL > 0 && time.Since(v.createdAt) > time.Duration(c.TTL)*time.Second {
+			continue
+		}
+		distance := l2Distance(v.vector, vector)
+		if distance < 0 {
+			continue
+		}
+		if similarity := 1 / (1 + distance); similarity >= c.Threshold && similarity > bestSimilarity {
+			best, bestSimilarity = v, similarity
+		}
+	}
+	if best == nil {
+		s.misses++
+		return nil, 0
+	}
+	s.hits++
+	return best, bestSimilarity
+}
+
+// 写入缓存 生成期间使用的知识已失效时不写入
+func (s *AnswerCacheService) store(knowledgeBase string, topK int, question string, vector []float32, result *SearchResult, since time.Time) {
+	if len(vector) == 0 || result.Answer == "" || len(result.Knowledges) == 0 {
+		return
+	}
+	c := cacheConfig()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range result.Knowledges {
+		ids := []int64{v.Id}
+		for _, q := range v.MatchedQuestions {
+			ids = append(ids, q.Id)
+		}
+		for _, id := range ids {
+			if t, ok := s.invalidated[id]; ok && !t.Before(since) {
+				logger.Logger.Infow("知识已修改 不写入缓存", "question", question, "knowledge_id", id)
+				return
+			}
+		}
+	}
+	// 清理过期缓存 超出上限时淘汰最早的缓存
+	if c.TTL > 0 {
+		s.entries = slices.DeleteFunc(s.entries, func(v *cacheEntry) bool {
+			return time.Since(v.createdAt) > time.Duration(c.TTL)*time.Second
+		})
+	}
+	if over := len(s.entries) + 1 - c.MaxEntries; over > 0 {
+		s.entries = slices.Delete(s.entries, 0, over)
+	}
+	s.nextId++
+	s.entries = append(s.entries, &cacheEntry{
+		id:            s.nextId,
+		knowledgeBase: knowledgeBase,
+		topK:          topK,
+		question:      question,
+		vector:        vector,
+		answer:        result.Answer,
+		segments:      result.Segments,
+		knowledges:    result.Knowledges,
+		createdAt:     time.Now(),
+	})
+}
+
+// Invalidate 使用到指定知识的缓存失效 知识修改或删除后调用
+func (s *AnswerCacheService) Invalidate(list []*models.Knowledge) {
+	if len(list) == 0 {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.invalidated {
+		if now.Sub(t) > cacheInvalidationKeep {
+			delete(s.invalidated, id)
+		}
+	}
+	ids := make([]int64, 0, len(list))
+	for _, v := range list {
+		ids = append(ids, v.Id)
+		s.invalidated[v.Id] = now
+	}
+	before := len(s.entries)
+	s.entries = slices.DeleteFunc(s.entries, func(v *cacheEntry) bool {
+		return slices.ContainsFunc(v.knowledges, func(k *SearchKnowledge) bool {
+			return slices.Contains(ids, k.Id) || slices.ContainsFunc(k.MatchedQuestions, func(q *MatchedQuestion) bool {
+				return slices.Contains(ids, q.Id)
+			})
+		})
+	})
+	if removed := before - len(s.entries); removed > 0 {
+		logger.Logger.Infow("缓存失效", "knowledge_ids", ids, "removed", removed)
+	}
+}
+
+// Clear 清空缓存 knowledgeBase不为空时只清空该知识库
+func (s *AnswerCacheService) Clear(ctx context.Context, knowledgeBase string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.entries)
+	s.entries = slices.DeleteFunc(s.entries, func(v *cacheEntry) bool {
+		return knowledgeBase == "" || v.knowledgeBase == knowledgeBase
+	})
+	return before - len(s.entries)
+}
+
+// Stats 缓存统计
+func (s *AnswerCacheService) Stats(ctx context.Context) *CacheStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &CacheStats{
+		Enabled: cfg.Cache != nil && cfg.Cache.Enabled,
+		Entries: len(s.entries),
+		Hits:    s.hits,
+		Misses:  s.misses,
+	}
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+/* 回答方式及上下文预算 */
+
+const (
+	// 回答方式
+	ChainStuff     = "stuff"      // 全部知识放入一次调用
+	ChainMapReduce = "map_reduce" // 逐条提取后汇总
+	ChainRefine    = "refine"     // 逐条完善答案
+	ChainMapRerank = "map_rerank" // 逐条回答并打分 取最高分
+
+	// 知识超出上限时的处理方式
+	OverflowTrim      = "trim"
+	OverflowSummarize = "summarize"
+
+	// 默认知识token上限
+	defaultContextTokens = 3000
+	// 截断后剩余不足该token数时丢弃该知识
+	minDocumentTokens = 50
+	// 逐条调用的并发数
+	chainConcurrency = 4
+
+	noRelevantInfo = "无相关信息"
+	cannotAnswer   = "根据已知信息无法回答该问题"
+
+	compressPrompt = `请从下面的资料中摘取与问题相关的内容，尽量保持原文表述，不超过%d字。资料中没有相关内容时只输出"` + noRelevantInfo + `"。
+问题：%s
+资料：
+%s`
+
+	mapPrompt = `请只根据下面的资料回答问题，资料与问题无关时只输出"` + noRelevantInfo + `"。
+资料：
+%s
+问题：%s`
+
+	refinePrompt = `已有答案：
+%s
+
+补充资料：
+%s
+
+请结合补充资料完善已有答案，补充资料没有帮助时原样输出已有答案。保留已有答案中的引用标注，引用补充资料的句子末尾标注[%d]。只输出答案。
+问题：%s`
+
+	rerankPrompt = `请只根据下面的资料回答问题，并给出0到100的分数表示资料对回答问题的帮助程度，资料与问题无关时分数为0。
+只输出JSON，例如：{"answer": "答案", "score": 80}
+资料：
+%s
+问题：%s`
+)
+
+var (
+	chainModes = []string{ChainStuff, ChainMapReduce, ChainRefine, ChainMapRerank}
+)
+
+// 提示词中的单条知识
+type chainDocument struct {
+	Index int // 知识序号 从1开始
+	Text  string
+}
+
+// 一次回答的上下文
+type chainRun struct {
+	question string
+	prompt   *promptTemplates
+	cc       *conversationContext
+	search   *config.SearchConfig
+	options  []llms.CallOption // 最终调用的选项 包含流式输出
+	onToken  func(ctx context.Context, chunk []byte) error
+}
+
+// 选择回答方式 请求优先
+func resolveChain(chain string, search *config.SearchConfig) (string, error) {
+	if chain == "" {
+		chain = search.Chain
+	}
+	if chain == "" {
+		return ChainStuff, nil
+	}
+	if !slices.Contains(chainModes, chain) {
+		return "", fmt.Errorf("%w: 不支持的回答方式%s", ErrInvalidParams, chain)
+	}
+	return chain, nil
+}
+
+// 知识token上限
+func (r *chainRun) budget() int {
+	if r.search.ContextTokens > 0 {
+		return r.search.ContextTokens
+	}
+	return defaultContextTokens
+}
+
+// 执行回答
+func (r *chainRun) run(ctx context.Context, chain string, documents []*chainDocument) (string, error) {
+	switch chain {
+	case ChainMapReduce:
+		return r.mapReduce(ctx, documents)
+	case ChainRefine:
+		return r.refine(ctx, documents)
+	case ChainMapRerank:
+		return r.mapRerank(ctx, documents)
+	default:
+		return r.stuff(ctx, documents)
+	}
+}
+
+// 按问题模板回答 带上对话历史
+func (r *chainRun) chat(ctx context.Context, documents []*chainDocument, options ...llms.CallOption) (string, error) {
+	texts := make([]string, 0, len(documents))
+	for _, v := range documents {
+		texts = append(texts, v.Text)
+	}
+	rendered, err := r.prompt.RenderQuestion(r.question, texts)
+	if err != nil {
+		return "", err
+	}
+	system, history := rendered.System, make([]*llm.Message, 0)
+	if r.cc != nil {
+		system, history = r.cc.system(system), r.cc.history
+	}
+	return llm.LLMHandler.Chat(ctx, system, history, rendered.User, options...)
+}
+
+// 中间步骤调用
+func (r *chainRun) call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	text, err := llm.LLMHandler.Call(ctx, prompt, options...)
+	return strings.TrimSpace(text), err
+}
+
+// 全部知识放入一次调用
+func (r *chainRun) stuff(ctx context.Context, documents []*chainDocument) (string, error) {
+	documents, err := r.fit(ctx, documents)
+	if err != nil {
+		return "", err
+	}
+	return r.chat(ctx, documents, r.options...)
+}
+
+// 逐条提取相关信息 再汇总回答
+func (r *chainRun) mapReduce(ctx context.Context, documents []*chainDocument) (string, error) {
+	partials := make([]*chainDocument, len(documents))
+	err := mapConcurrent(ctx, len(documents), func(ctx context.Context, i int) error {
+		text, err := r.call(ctx, fmt.Sprintf(mapPrompt, llm.TruncateTokens(documents[i].Text, r.budget()), r.question))
+		if err != nil {
+			return err
+		}
+		if text != "" && !strings.Contains(text, noRelevantInfo) {
+			partials[i] = &chainDocument{
+				Index: documents[i].Index,
+				Text:  fmt.Sprintf("[%d] %s", documents[i].Index, text),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	reduced := make([]*chainDocument, 0, len(partials))
+	for _, v := range partials {
+		if v != nil {
+			reduced = append(reduced, v)
+		}
+	}
+	if len(reduced) == 0 {
+		// 没有相关信息时交给最终调用按原知识回答
+		return r.stuff(ctx, documents)
+	}
+	return r.stuff(ctx, reduced)
+}
+
+// 按顺序逐条完善答案
+func (r *chainRun) refine(ctx context.Context, documents []*chainDocument) (string, error) {
+	answer := ""
+	for i, v := range documents {
+		last := i == len(documents)-1
+		document := &chainDocument{
+			Index: v.Index,
+			Text:  llm.TruncateTokens(v.Text, r.budget()),
+		}
+		if i == 0 {
+			if last {
+				return r.chat(ctx, []*chainDocument{document}, r.options...)
+			}
+			text, err := r.chat(ctx, []*chainDocument{document})
+			if err != nil {
+				return "", err
+			}
+			answer = text
+			continue
+		}
+		prompt := fmt.Sprintf(refinePrompt, answer, document.Text, document.Index, r.question)
+		options := make([]llms.CallOption, 0)
+		if last {
+			options = r.options
+		}
+		text, err := r.call(ctx, prompt, options...)
+		if err != nil {
+			return "", err
+		}
+		if text != "" {
+			answer = text
+		}
+	}
+	return answer, nil
+}
+
+// 逐条回答并打分 返回最高分的答案
+func (r *chainRun) mapRerank(ctx context.Context, documents []*chainDocument) (string, error) {
+	type rerankAnswer struct {
+		Answer string  `json:"answer"`
+		Score  float64 `json:"score"`
+	}
+	answers := make([]*rerankAnswer, len(documents))
+	err := mapConcurrent(ctx, len(documents), func(ctx context.Context, i int) error {
+		text, err := r.call(ctx, fmt.Sprintf(rerankPrompt, llm.TruncateTokens(documents[i].Text, r.budget()), r.question))
+		if err != nil {
+			return err
+		}
+		answer := new(rerankAnswer)
+		if err = extractJSON(text, answer); err != nil {
+			return err
+		}
+		answers[i] = answer
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	best := -1
+	for i, v := range answers {
+		if v == nil || v.Answer == "" || v.Score <= 0 || strings.Contains(v.Answer, noRelevantInfo) {
+			continue
+		}
+		if best < 0 || v.Score > answers[best].Score {
+			best = i
+		}
+	}
+	answer := cannotAnswer
+	if best >= 0 {
+		answer = answers[best].Answer
+		// 标注答案来源
+		if citationRegexp.FindStringIndex(answer) == nil {
+			answer = fmt.Sprintf("%s[%d]", answer, documents[best].Index)
+		}
+	}
+	if r.onToken != nil {
+		if err = r.onToken(ctx, []byte(answer)); err != nil {
+			return "", err
+		}
+	}
+	return answer, nil
+}
+
+// 使知识不超过token上限 超出时按配置先总结 再按顺序截断
+func (r *chainRun) fit(ctx context.Context, documents []*chainDocument) ([]*chainDocument, error) {
+	budget := r.budget()
+	total := 0
+	for _, v := range documents {
+		total += llm.EstimateTokens(v.Text)
+	}
+	if total <= budget || len(documents) == 0 {
+		return documents, nil
+	}
+	logger.Logger.Infow("知识超出上下文上限", "tokens", total, "budget", budget, "overflow", r.search.Overflow)
+
+	if r.search.Overflow == OverflowSummarize {
+		per := budget / len(documents)
+		compressed := make([]*chainDocument, len(documents))
+		err := mapConcurrent(ctx, len(documents), func(ctx context.Context, i int) error {
+			v := documents[i]
+			if llm.EstimateTokens(v.Text) <= per {
+				compressed[i] = v
+				return nil
+			}
+			text, err := r.call(ctx, fmt.Sprintf(compressPrompt, per, r.question, v.Text))
+			if err != nil {
+				// 总结失败时保留原知识 由后面的截断处理
+				logger.Logger.Errorw("总结知识错误", "err", err, "index", v.Index)
+				compressed[i] = v
+				return nil
+			}
+			if text != "" && !strings.Contains(text, noRelevantInfo) {
+				compressed[i] = &chainDocument{
+					Index: v.Index,
+					Text:  fmt.Sprintf("[%d] %s", v.Index, text),
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		summarized := make([]*chainDocument, 0, len(compressed))
+		for _, v := range compressed {
+			if v != nil {
+				summarized = append(summarized, v)
+			}
+		}
+		// 全部没有相关信息时按原知识截断 不使用空的知识调用模型
+		if len(summarized) > 0 {
+			documents = summarized
+		}
+	}
+
+	// 按相关度顺序保留 放不下的截断或丢弃
+	fitted := make([]*chainDocument, 0, len(documents))
+	remaining := budget
+	for _, v := range documents {
+		tokens := llm.EstimateTokens(v.Text)
+		if tokens <= remaining {
+			fitted = append(fitted, v)
+			remaining -= tokens
+			continue
+		}
+		if remaining >= minDocumentTokens || len(fitted) == 0 {
+			fitted = append(fitted, &chainDocument{
+				Index: v.Index,
+				Text:  llm.TruncateTokens(v.Text, remaining),
+			})
+		}
+		break
+	}
+	return fitted, nil
+}
+
+// 并发执行 单条失败时跳过 全部失败或ctx取消时返回错误
+func mapConcurrent(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	if n == 0 {
+		return nil
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var lastErr error
+	failed := 0
+	sem := make(chan struct{}, chainConcurrency)
+	for i := range n {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(ctx, i); err != nil {
+				logger.Logger.Errorw("调用大模型错误", "err", err, "index", i)
+				mu.Lock()
+				failed++
+				lastErr = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failed == n {
+		return lastErr
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"ai-knowledge/internal/logger"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/* 答案引用标注 */
+
+var (
+	// 引用标注 [1]、[1,2]、【1】
+	citationRegexp = regexp.MustCompile(`[\[【]\s*(\d+(?:\s*[,，、]\s*\d+)*)\s*[\]】]`)
+	// 句末标点后的引用标注 移到标点前归属前一句
+	trailingCitationRegexp = regexp.MustCompile(`([。！？!?；;])(\s*)((?:[\[【]\s*\d+(?:\s*[,，、]\s*\d+)*\s*[\]】])+)`)
+	// 引用编号分隔符
+	citationSepRegexp = regexp.MustCompile(`\s*[,，、]\s*`)
+)
+
+// 答案片段
+type AnswerSegment struct {
+	Text         string  `json:"text"`
+	KnowledgeIds []int64 `json:"knowledge_ids"` // 支撑该片段的知识id
+}
+
+// 句子结束符
+func isSentenceEnd(r rune) bool {
+	return strings.ContainsRune("。！？!?；;\n", r)
+}
+
+// 解析答案中的引用标注 返回去掉标注的答案和按句子切分的片段
+// 编号对应提示词中从1开始的知识序号 超出范围的编号视为无效引用丢弃
+func parseCitations(answer string, knowledges []*SearchKnowledge) (string, []*AnswerSegment) {
+	answer = trailingCitationRegexp.ReplaceAllString(answer, "$3$1$2")
+	segments := make([]*AnswerSegment, 0)
+	builder := new(strings.Builder)
+	ids := make([]int64, 0)
+	invalid := make([]string, 0)
+	flush := func() {
+		if builder.Len() == 0 && len(ids) == 0 {
+			return
+		}
+		segments = append(segments, &AnswerSegment{
+			Text:         builder.String(),
+			KnowledgeIds: ids,
+		})
+		builder.Reset()
+		ids = make([]int64, 0)
+	}
+
+	rest := answer
+	for rest != "" {
+		loc := citationRegexp.FindStringSubmatchIndex(rest)
+		text := rest
+		if loc != nil {
+			text = rest[:loc[0]]
+		}
+		for _, r := range text {
+			builder.WriteRune(r)
+			if isSentenceEnd(r) {
+				flush()
+			}
+		}
+		if loc == nil {
+			break
+		}
+		for _, v := range citationSepRegexp.Split(rest[loc[2]:loc[3]], -1) {
+			index, err := strconv.Atoi(v)
+			if err != nil || index < 1 || index > len(knowledges) {
+				invalid = append(invalid, v)
+				continue
+			}
+			id := knowledges[index-1].Id
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		rest = rest[loc[1]:]
+	}
+	flush()
+	if len(invalid) > 0 {
+		logger.Logger.Warnw("丢弃无效引用", "invalid", invalid, "knowledges", len(knowledges))
+	}
+
+	// 只有引用没有文本的片段合并到前一个片段
+	merged := make([]*AnswerSegment, 0, len(segments))
+	clean := new(strings.Builder)
+	for _, v := range segments {
+		clean.WriteString(v.Text)
+		if v.Text == "" && len(merged) > 0 {
+			last := merged[len(merged)-1]
+			for _, id := range v.KnowledgeIds {
+				if !slices.Contains(last.KnowledgeIds, id) {
+					last.KnowledgeIds = append(last.KnowledgeIds, id)
+				}
+			}
+			continue
+		}
+		merged = append(merged, v)
+	}
+	return clean.String(), merged
+}
@@ -0,0 +1,489 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/embedding"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/internal/milvus"
+	"ai-knowledge/program/models"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* 知识矛盾检测 相似的不同分组知识由模型判断是否矛盾 */
+
+const (
+	defaultConflictThreshold = 0.8
+	defaultConflictNeighbors = 5
+	defaultConflictDebounce  = 10 * time.Second
+	// 问答内容最多包含的问题数
+	conflictQuestions = 5
+
+	conflictPrompt = `你是知识库审核员，请判断下面两条知识是否互相矛盾。
+要求：
+1. 矛盾指对同一事项给出了不同的说法，如不同的时间、金额、条件或流程
+2. 只是侧重点不同、互相补充或说的不是同一件事不算矛盾
+3. 只输出JSON，例如：{"conflict": true, "explanation": "说明矛盾之处"}，不矛盾时explanation为空
+
+知识A：
+%s
+
+知识B：
+%s`
+)
+
+var (
+	Conflict = new(ConflictService)
+)
+
+// 知识矛盾检测 保存知识后合并一段时间内变化的分组再检查
+type ConflictService struct {
+	mu      sync.Mutex
+	started bool
+	pending []string
+	timer   *time.Timer
+}
+
+// 检查任务参数
+type ConflictParams struct {
+	GroupKeys []string `json:"group_keys"` // 为空时检查全部分组 首次执行时保存到任务参数 重启后检查同一批分组
+}
+
+// 模型输出的判断
+type conflictVerdict struct {
+	Conflict    bool   `json:"conflict"`
+	Explanation string `json:"explanation"`
+}
+
+// 一对相似知识
+type conflictPair struct {
+	a, b       *models.Knowledge
+	similarity float32
+}
+
+// 单次检查中按分组缓存知识
+type conflictGroups map[string][]*models.Knowledge
+
+func (g conflictGroups) get(groupKey string) ([]*models.Knowledge, error) {
+	if list, ok := g[groupKey]; ok {
+		return list, nil
+	}
+	list, err := new(models.Knowledge).GetByGroupKey(groupKey)
+	if err != nil {
+		return nil, err
+	}
+	g[groupKey] = list
+	return list, nil
+}
+
+func conflictConfig() config.ConflictConfig {
+	c := config.ConflictConfig{}
+	if cfg.Conflict != nil {
+		c = *cfg.Conflict
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = defaultConflictThreshold
+	}
+	if c.Neighbors <= 0 {
+		c.Neighbors = defaultConflictNeighbors
+	}
+	return c
+}
+
+// Start 启用保存知识后的增量检查
+func (s *ConflictService) Start() {
+	if cfg.Conflict == nil || !cfg.Conflict.OnSave {
+		return
+	}
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+}
+
+// Stop 停止增量检查 尚未提交的分组立即创建任务 下次启动后执行
+func (s *ConflictService) Stop() {
+	s.mu.Lock()
+	s.started = false
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	groupKeys := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	s.submit(groupKeys)
+}
+
+// Schedule 记录变化的分组 合并一段时间内的变化后创建一个检查任务
+func (s *ConflictService) Schedule(groupKeys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		return
+	}
+	for _, v := range groupKeys {
+		if v != "" && !slices.Contains(s.pending, v) {
+			s.pending = append(s.pending, v)
+		}
+	}
+	if s.timer != nil || len(s.pending) == 0 {
+		return
+	}
+	debounce := defaultConflictDebounce
+	if cfg.Conflict.Debounce > 0 {
+		debounce = time.Duration(cfg.Conflict.Debounce) * time.Second
+	}
+	s.timer = time.AfterFunc(debounce, func() {
+		s.mu.Lock()
+		groupKeys := s.pending
+		s.pending = nil
+		s.timer = nil
+		s.mu.Unlock()
+		s.submit(groupKeys)
+	})
+}
+
+func (s *ConflictService) submit(groupKeys []string) {
+	if len(groupKeys) == 0 {
+		return
+	}
+	if _, err := Job.Enqueue(JobTypeConflict, &ConflictParams{GroupKeys: groupKeys}, nil, ""); err != nil {
+		logger.Logger.Errorw("创建矛盾检测任务错误", "err", err, "group_keys", groupKeys)
+	}
+}
+
+// Submit 创建矛盾检测任务
+func (s *ConflictService) Submit(ctx context.Context, params *ConflictParams) (*models.Job, error) {
+	params.GroupKeys = trimValues(params.GroupKeys)
+	return Job.Enqueue(JobTypeConflict, params, nil, "")
+}
+
+// 检查任务 每个分组为一行
+func (s *ConflictService) conflictJob(ctx context.Context, job *models.Job, progress *JobProgress) (any, error) {
+	params := new(ConflictParams)
+	if err := json.Unmarshal([]byte(job.Params), params); err != nil {
+		return nil, err
+	}
+	groupKeys := params.GroupKeys
+	if len(groupKeys) == 0 {
+		var err error
+		if groupKeys, err = new(models.Knowledge).GetAllGroupKeys(); err != nil {
+			return nil, err
+		}
+		// 分组替换或删除后顺序会变化 保存本次的分组列表 重启后按同一顺序继续
+		params.GroupKeys = groupKeys
+		js, _ := json.Marshal(params)
+		if err = new(models.Job).UpdateById(job.Id, map[string]any{"params": string(js)}); err != nil {
+			return nil, err
+		}
+		job.Params = string(js)
+	}
+	progress.SetTotal(int64(len(groupKeys)))
+
+	c := conflictConfig()
+	checked, conflicts := 0, 0
+	for i, groupKey := range groupKeys {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !progress.Next() {
+			continue
+		}
+		n, m, err := s.scanGroup(ctx, groupKey, c)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			err = fmt.Errorf("分组%s: %w", groupKey, err)
+		}
+		checked += n
+		conflicts += m
+		progress.Done(i+1, err)
+	}
+	return map[string]any{
+		"checked":   checked,
+		"conflicts": conflicts,
+	}, nil
+}
+
+// 检查一个分组与其他分组的相似知识 返回新检查的对数及其中矛盾的对数
+func (s *ConflictService) scanGroup(ctx context.Context, groupKey string, c config.ConflictConfig) (checked, conflicts int, err error) {
+	groups := make(conflictGroups)
+	if err = s.cleanup(groups, groupKey); err != nil {
+		return
+	}
+	group, err := groups.get(groupKey)
+	if err != nil || len(group) == 0 {
+		return
+	}
+	pairs, err := s.similarPairs(ctx, group, c)
+	if err != nil {
+		return
+	}
+
+	errs := make([]error, 0)
+	for _, pair := range pairs {
+		if ctx.Err() != nil {
+			return
+		}
+		isNew, conflict, e := s.check(ctx, groups, pair)
+		if e != nil {
+			logger.Logger.Errorw("检查知识矛盾错误", "err", e, "a", pair.a.Id, "b", pair.b.Id)
+			errs = append(errs, fmt.Errorf("知识%d与%d: %w", pair.a.Id, pair.b.Id, e))
+			continue
+		}
+		if isNew {
+			checked++
+		}
+		if conflict {
+			conflicts++
+		}
+	}
+	err = errors.Join(errs...)
+	return
+}
+
+// 问答以分组为单位 纯知识以切片为单位
+func conflictUnit(k *models.Knowledge) string {
+	if k.Type == models.KnowledgeTypeQAndA {
+		return k.GroupKey
+	}
+	return strconv.FormatInt(k.Id, 10)
+}
+
+// 检索分组内各知识在其他分组中相似度达到阈值的知识 按相似度从高到低
+func (s *ConflictService) similarPairs(ctx context.Context, group []*models.Knowledge, c config.ConflictConfig) ([]*conflictPair, error) {
+	// 待审核的问题变体不参与检查
+	group = slices.DeleteFunc(slices.Clone(group), func(v *models.Knowledge) bool {
+		return v.Generated == models.GeneratedPending
+	})
+	if len(group) == 0 {
+		return nil, nil
+	}
+	texts := make([]string, 0, len(group))
+	for _, v := range group {
+		if v.Type == models.KnowledgeTypeQAndA {
+			texts = append(texts, v.Question)
+		} else {
+			texts = append(texts, v.Text)
+		}
+	}
+	vectors, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, texts)
+	if err != nil {
+		logger.Logger.Errorw("处理知识为向量错误", "err", err, "group_key", group[0].GroupKey)
+		return nil, err
+	}
+	if len(vectors) != len(group) {
+		return nil, ErrVectorTransform
+	}
+
+	// 同组知识也会被检索到 多检索同组知识的条数
+	fetch := min(c.Neighbors+len(group), maxRetrieveTopK)
+	results := make([][]*milvus.SearchResult, 0, len(vectors))
+	vectorIds := make([]int64, 0)
+	for _, v := range vectors {
+		list, err := milvus.MilvusHandler.Search(ctx, v, fetch)
+		if err != nil {
+			logger.Logger.Errorw("查询向量数据库错误", "err", err, "group_key", group[0].GroupKey)
+			return nil, err
+		}
+		results = append(results, list)
+		for _, r := range list {
+			vectorIds = append(vectorIds, r.Id)
+		}
+	}
+	knowledges, err := new(models.Knowledge).BatchGetByIds(vectorIds)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "vector_ids", vectorIds)
+		return nil, err
+	}
+	knowledgeMap := make(map[int64]*models.Knowledge)
+	for _, v := range knowledges {
+		knowledgeMap[v.VectorId] = v
+	}
+
+	pairs := make([]*conflictPair, 0)
+	pairMap := make(map[string]*conflictPair)
+	for i, list := range results {
+		own := group[i]
+		neighbors := 0
+		for _, r := range list {
+			other, ok := knowledgeMap[r.Id]
+			if !ok || other.GroupKey == own.GroupKey || other.Generated == models.GeneratedPending {
+				continue
+			}
+			similarity := 1 / (1 + r.Score)
+			if neighbors >= c.Neighbors || similarity < c.Threshold {
+				break
+			}
+			neighbors++
+			key := conflictUnit(own) + "|" + conflictUnit(other)
+			if pair, ok := pairMap[key]; ok {
+				if similarity > pair.similarity {
+					pair.a, pair.b, pair.similarity = own, other, similarity
+				}
+				continue
+			}
+			pair := &conflictPair{a: own, b: other, similarity: similarity}
+			pairMap[key] = pair
+			pairs = append(pairs, pair)
+		}
+	}
+	slices.SortStableFunc(pairs, func(a, b *conflictPair) int {
+		return cmp.Compare(b.similarity, a.similarity)
+	})
+	return pairs, nil
+}
+
+// 用于判断的知识内容 问答为分组内人工录入或已确认的问题及答案 纯知识为切片内容
+func conflictContent(k *models.Knowledge, group []*models.Knowledge) string {
+	if k.Type != models.KnowledgeTypeQAndA {
+		if k.Section != "" {
+			return k.Section + "\n" + k.Text
+		}
+		return k.Text
+	}
+	var b strings.Builder
+	count := 0
+	for _, v := range group {
+		if v.Generated == models.GeneratedPending || count >= conflictQuestions {
+			continue
+		}
+		b.WriteString("问：" + v.Question + "\n")
+		count++
+	}
+	b.WriteString("答：" + k.Answer)
+	return b.String()
+}
+
+// 检查结果中一侧知识的当前内容 已删除时返回空
+func currentContent(groups conflictGroups, groupKey string, knowledgeId int64) (string, error) {
+	group, err := groups.get(groupKey)
+	if err != nil || len(group) == 0 {
+		return "", err
+	}
+	if group[0].Type == models.KnowledgeTypeQAndA {
+		return conflictContent(group[0], group), nil
+	}
+	for _, v := range group {
+		if v.Id == knowledgeId {
+			return conflictContent(v, group), nil
+		}
+	}
+	return "", nil
+}
+
+// 删除分组相关的过期检查结果 任一侧知识已修改或删除时过期
+func (s *ConflictService) cleanup(groups conflictGroups, groupKey string) error {
+	list, err := new(models.KnowledgeConflict).GetByGroupKey(groupKey)
+	if err != nil || len(list) == 0 {
+		return err
+	}
+	ids := make([]int64, 0)
+	for _, v := range list {
+		contentA, err := currentContent(groups, v.GroupKeyA, v.KnowledgeIdA)
+		if err != nil {
+			return err
+		}
+		contentB, err := currentContent(groups, v.GroupKeyB, v.KnowledgeIdB)
+		if err != nil {
+			return err
+		}
+		if contentA != v.ContentA || contentB != v.ContentB {
+			ids = append(ids, v.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return new(models.KnowledgeConflict).DelByIds(ids)
+}
+
+// 由模型判断一对知识是否矛盾并保存结果 相同内容已检查过时跳过
+func (s *ConflictService) check(ctx context.Context, groups conflictGroups, pair *conflictPair) (isNew, conflict bool, err error) {
+	groupA, err := groups.get(pair.a.GroupKey)
+	if err != nil {
+		return
+	}
+	groupB, err := groups.get(pair.b.GroupKey)
+	if err != nil {
+		return
+	}
+	a, b := pair.a, pair.b
+	contentA, contentB := conflictContent(a, groupA), conflictContent(b, groupB)
+	// 按内容排序 同一对知识从任一侧检查时校验和相同
+	if contentA > contentB {
+		a, b = b, a
+		contentA, contentB = contentB, contentA
+	}
+	digest := Checksum(contentA + "\n" + contentB)
+	existing, err := new(models.KnowledgeConflict).GetByDigest(digest)
+	if err != nil || existing != nil {
+		return
+	}
+
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(conflictPrompt, contentA, contentB))
+	if err != nil {
+		return
+	}
+	verdict := new(conflictVerdict)
+	if err = extractJSON(text, verdict); err != nil {
+		return
+	}
+	now := time.Now().Unix()
+	record := &models.KnowledgeConflict{
+		GroupKeyA:    a.GroupKey,
+		KnowledgeIdA: a.Id,
+		ContentA:     contentA,
+		GroupKeyB:    b.GroupKey,
+		KnowledgeIdB: b.Id,
+		ContentB:     contentB,
+		Similarity:   pair.similarity,
+		Conflict:     models.ConflictNo,
+		Digest:       digest,
+		Status:       models.ConflictStatusPending,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if verdict.Conflict {
+		record.Conflict = models.ConflictYes
+		record.Explanation = verdict.Explanation
+	}
+	if err = new(models.KnowledgeConflict).Create(record); err != nil {
+		return
+	}
+	return true, verdict.Conflict, nil
+}
+
+// GetList 分页查询矛盾 按相似度从高到低
+func (s *ConflictService) GetList(ctx context.Context, page, pageSize int, status int32) ([]*models.KnowledgeConflict, int64, error) {
+	return new(models.KnowledgeConflict).GetList(page, pageSize, status)
+}
+
+// Ignore 忽略待处理的矛盾 内容修改后重新检查
+func (s *ConflictService) Ignore(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return ErrInvalidParams
+	}
+	return new(models.KnowledgeConflict).UpdateStatusByIds(ids, models.ConflictStatusPending, models.ConflictStatusIgnored)
+}
+
+// 知识所在的分组
+func knowledgeGroupKeys(list []*models.Knowledge) []string {
+	groupKeys := make([]string, 0)
+	for _, v := range list {
+		if !slices.Contains(groupKeys, v.GroupKey) {
+			groupKeys = append(groupKeys, v.GroupKey)
+		}
+	}
+	return groupKeys
+}
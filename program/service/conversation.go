@@ -0,0 +1,278 @@
+package service
+
+import (
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+/* 多轮对话 */
+
+const (
+	// 默认历史消息token上限
+	defaultHistoryTokens = 1500
+	// 默认总结时保留的最近对话轮数
+	defaultKeepTurns = 2
+	// 会话标题最大长度
+	maxConversationTitleLen = 50
+
+	condensePrompt = `下面是用户与知识库助手的对话，请结合对话内容把用户的最新问题改写为一个不依赖上下文、可以独立理解的问题。
+要求：
+1. 补全问题中省略的主语、指代等信息
+2. 问题本身已经完整时原样输出
+3. 只输出改写后的问题，不要回答问题
+
+%s
+最新问题：%s`
+
+	summaryPrompt = `请将下面的对话总结为一段简洁的摘要，保留用户关心的主题、关键事实和已经给出的结论，不超过300字。
+%s
+只输出摘要内容。`
+)
+
+var (
+	Conversation = new(ConversationService)
+)
+
+// 多轮对话会话管理
+type ConversationService struct {
+}
+
+// 会话详情
+type ConversationDetail struct {
+	*models.Conversation
+	Messages []*models.ConversationMessage `json:"messages"`
+}
+
+// 查询时的会话上下文
+type conversationContext struct {
+	conversation *models.Conversation
+	history      []*llm.Message // 摘要之后的最近消息
+}
+
+// 创建会话
+func (s *ConversationService) Create(ctx context.Context, knowledgeBase string) (*models.Conversation, error) {
+	if _, err := getKnowledgeBase(knowledgeBase); err != nil {
+		return nil, err
+	}
+	conversation := &models.Conversation{
+		KnowledgeBase: knowledgeBase,
+	}
+	err := new(models.Conversation).Create(conversation)
+	if err != nil {
+		logger.Logger.Errorw("创建会话错误", "err", err)
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// 获取会话及全部消息
+func (s *ConversationService) Get(ctx context.Context, id int64) (*ConversationDetail, error) {
+	conversation, err := new(models.Conversation).GetById(id)
+	if err != nil {
+		return nil, err
+	}
+	if conversation == nil {
+		return nil, ErrDataNotFound
+	}
+	messages, err := new(models.ConversationMessage).GetAfter(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	if messages == nil {
+		messages = make([]*models.ConversationMessage, 0)
+	}
+	return &ConversationDetail{
+		Conversation: conversation,
+		Messages:     messages,
+	}, nil
+}
+
+// 分页查询
+func (s *ConversationService) GetList(ctx context.Context, page, pageSize int) (list []*models.Conversation, total int64, err error) {
+	return new(models.Conversation).GetList(page, pageSize)
+}
+
+// 删除会话
+func (s *ConversationService) Del(ctx context.Context, id int64) error {
+	if id <= 0 {
+		return ErrInvalidParams
+	}
+	return new(models.Conversation).DelWithMessages(id)
+}
+
+// 加载会话历史 历史超出token预算时先把较早的对话总结为摘要 仍超出时只保留预算内的最近消息
+func (s *ConversationService) load(ctx context.Context, id int64) (*conversationContext, error) {
+	conversation, err := new(models.Conversation).GetById(id)
+	if err != nil {
+		logger.Logger.Errorw("查询会话错误", "err", err, "id", id)
+		return nil, err
+	}
+	if conversation == nil {
+		return nil, ErrDataNotFound
+	}
+	messages, err := new(models.ConversationMessage).GetAfter(id, conversation.SummarizedId)
+	if err != nil {
+		logger.Logger.Errorw("查询会话消息错误", "err", err, "id", id)
+		return nil, err
+	}
+
+	historyTokens, keepTurns := defaultHistoryTokens, defaultKeepTurns
+	if cfg.Conversation != nil {
+		if cfg.Conversation.HistoryTokens > 0 {
+			historyTokens = cfg.Conversation.HistoryTokens
+		}
+		if cfg.Conversation.KeepTurns > 0 {
+			keepTurns = cfg.Conversation.KeepTurns
+		}
+	}
+	tokens := llm.EstimateTokens(conversation.Summary)
+	for _, v := range messages {
+		tokens += llm.EstimateTokens(v.Content)
+	}
+	if keep := keepTurns * 2; tokens > historyTokens && len(messages) > keep {
+		old := messages[:len(messages)-keep]
+		messages = messages[len(messages)-keep:]
+		summary, err := s.summarize(ctx, conversation.Summary, old)
+		if err != nil {
+			// 总结失败时本次只使用最近的对话
+			logger.Logger.Errorw("总结会话错误", "err", err, "id", id)
+		} else {
+			summarizedId := old[len(old)-1].Id
+			ok, err := new(models.Conversation).UpdateSummary(id, conversation.SummarizedId, summary, summarizedId)
+			if err != nil {
+				logger.Logger.Errorw("保存会话摘要错误", "err", err, "id", id)
+			} else if !ok {
+				// 并发请求已先保存摘要 本次仍使用自己的摘要
+				logger.Logger.Infow("会话摘要已被更新", "id", id)
+			}
+			conversation.Summary = summary
+			conversation.SummarizedId = summarizedId
+		}
+	}
+
+	history := make([]*llm.Message, 0, len(messages))
+	for _, v := range messages {
+		history = append(history, &llm.Message{
+			Role:    v.Role,
+			Content: v.Content,
+		})
+	}
+	return &conversationContext{
+		conversation: conversation,
+		history:      fitHistory(history, historyTokens-llm.EstimateTokens(conversation.Summary)),
+	}, nil
+}
+
+// 从最近的消息开始保留不超过maxTokens的历史 单轮很长或总结失败时 放不下的较早消息丢弃 最早保留的一条截断
+func fitHistory(history []*llm.Message, maxTokens int) []*llm.Message {
+	start := len(history)
+	for start > 0 {
+		v := history[start-1]
+		tokens := llm.EstimateTokens(v.Content)
+		if tokens <= maxTokens {
+			maxTokens -= tokens
+			start--
+			continue
+		}
+		if content := llm.TruncateTokens(v.Content, maxTokens); content != "" {
+			history[start-1] = &llm.Message{
+				Role:    v.Role,
+				Content: content,
+			}
+			start--
+		}
+		break
+	}
+	return history[start:]
+}
+
+// 对话文本
+func formatDialogue(summary string, messages []*llm.Message) string {
+	builder := new(strings.Builder)
+	if summary != "" {
+		fmt.Fprintf(builder, "此前对话摘要：%s\n", summary)
+	}
+	for _, v := range messages {
+		role := "用户"
+		if v.Role == llm.RoleAssistant {
+			role = "助手"
+		}
+		fmt.Fprintf(builder, "%s：%s\n", role, v.Content)
+	}
+	return builder.String()
+}
+
+// 把已有摘要和较早的对话合并为新摘要
+func (s *ConversationService) summarize(ctx context.Context, summary string, messages []*models.ConversationMessage) (string, error) {
+	history := make([]*llm.Message, 0, len(messages))
+	for _, v := range messages {
+		history = append(history, &llm.Message{
+			Role:    v.Role,
+			Content: v.Content,
+		})
+	}
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(summaryPrompt, formatDialogue(summary, history)),
+		llms.WithTemperature(0))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// 结合历史把问题改写为独立问题 没有历史时返回原问题
+func (s *ConversationService) condense(ctx context.Context, cc *conversationContext, question string) (string, error) {
+	if len(cc.history) == 0 && cc.conversation.Summary == "" {
+		return question, nil
+	}
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(condensePrompt, formatDialogue(cc.conversation.Summary, cc.history), question),
+		llms.WithTemperature(0))
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return question, nil
+	}
+	return text, nil
+}
+
+// 系统提示词追加对话摘要
+func (cc *conversationContext) system(system string) string {
+	if cc.conversation.Summary == "" {
+		return system
+	}
+	return fmt.Sprintf("%s\n\n此前对话摘要：%s", system, cc.conversation.Summary)
+}
+
+// 保存本轮问答
+func (s *ConversationService) save(ctx context.Context, cc *conversationContext, question, standalone, answer string) error {
+	messages := []*models.ConversationMessage{
+		{
+			ConversationId: cc.conversation.Id,
+			Role:           llm.RoleUser,
+			Content:        question,
+			Question:       standalone,
+		},
+		{
+			ConversationId: cc.conversation.Id,
+			Role:           llm.RoleAssistant,
+			Content:        answer,
+		},
+	}
+	err := new(models.ConversationMessage).BatchCreate(messages)
+	if err != nil {
+		return err
+	}
+	data := make(map[string]any)
+	if cc.conversation.Title == "" {
+		title := []rune(question)
+		data["title"] = string(title[:min(len(title), maxConversationTitleLen)])
+	}
+	return new(models.Conversation).UpdateById(cc.conversation.Id, data)
+}
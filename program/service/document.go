@@ -0,0 +1,273 @@
+package service
+
+import (
+	"ai-knowledge/internal/embedding"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/internal/milvus"
+	"ai-knowledge/internal/splitter"
+	"ai-knowledge/program/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+var (
+	Document = new(DocumentService)
+)
+
+// 源文档管理
+type DocumentService struct {
+}
+
+// 文档详情
+type DocumentDetail struct {
+	*models.Document
+	Chunks []*models.Knowledge `json:"chunks"`
+}
+
+// 计算内容校验和
+func Checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// 保存文档 相同来源的文档会整体替换全部切片 内容未变化时不做处理
+func (s *DocumentService) SaveDocument(ctx context.Context, title, origin, content string) (document *models.Document, changed bool, err error) {
+	if origin == "" || content == "" {
+		err = ErrInvalidParams
+		return
+	}
+	if title == "" {
+		title = origin
+	}
+	checksum := Checksum(content)
+	old, err := new(models.Document).GetByOrigin(origin)
+	if err != nil {
+		logger.Logger.Errorw("查询文档错误", "err", err, "origin", origin)
+		return
+	}
+	if old != nil && old.Checksum == checksum && old.Title == title {
+		return old, false, nil
+	}
+
+	// 切片
+	chunkSize, chunkOverlap := 0, 0
+	if cfg.Document != nil {
+		chunkSize, chunkOverlap = cfg.Document.ChunkSize, cfg.Document.ChunkOverlap
+	}
+	chunks, err := splitter.Split(content, chunkSize, chunkOverlap)
+	if err != nil {
+		logger.Logger.Errorw("文档切片错误", "err", err, "origin", origin)
+		return
+	}
+	if len(chunks) == 0 {
+		err = ErrInvalidParams
+		return
+	}
+	// 旧切片中内容相同的复用原向量 只为变化的切片计算向量
+	reuse := make(map[string][]int64)
+	oldChunks := make([]*models.Knowledge, 0)
+	if old != nil {
+		oldChunks, err = new(models.Knowledge).GetByDocumentId(old.Id)
+		if err != nil {
+			logger.Logger.Errorw("查询文档切片错误", "err", err, "document_id", old.Id)
+			return nil, false, err
+		}
+		for _, v := range oldChunks {
+			reuse[v.Text] = append(reuse[v.Text], v.VectorId)
+		}
+	}
+	vectorIds := make([]int64, len(chunks))
+	texts := make([]string, 0, len(chunks))
+	indexes := make([]int, 0, len(chunks))
+	for i, v := range chunks {
+		if ids := reuse[v.Content]; len(ids) > 0 {
+			vectorIds[i] = ids[0]
+			reuse[v.Content] = ids[1:]
+			continue
+		}
+		texts = append(texts, v.Content)
+		indexes = append(indexes, i)
+	}
+
+	// 先写入新向量 db替换成功后再删除不再使用的旧向量
+	insertedIds := make([]int64, 0, len(texts))
+	if len(texts) > 0 {
+		vectors, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, texts)
+		if err != nil {
+			logger.Logger.Errorw("处理切片为向量错误", "err", err, "origin", origin)
+			return nil, false, err
+		}
+		if len(vectors) != len(texts) {
+			return nil, false, errors.New("向量数与切片数不一致")
+		}
+		insertedIds, err = milvus.MilvusHandler.Insert(ctx, texts, vectors)
+		if err != nil {
+			logger.Logger.Errorw("写入向量数据库错误", "err", err, "origin", origin)
+			return nil, false, err
+		}
+		if len(insertedIds) != len(texts) {
+			return nil, false, errors.New("向量插入数与切片数不一致")
+		}
+		for i, index := range indexes {
+			vectorIds[index] = insertedIds[i]
+		}
+	}
+
+	document = &models.Document{
+		Title:      title,
+		Origin:     origin,
+		Checksum:   checksum,
+		Version:    1,
+		ChunkCount: int32(len(chunks)),
+	}
+	if old != nil {
+		document.Id = old.Id
+		document.Version = old.Version + 1
+		document.CreatedAt = old.CreatedAt
+	}
+
+	groupKey := new(models.Knowledge).GenGroupKey()
+	knowledges := make([]*models.Knowledge, 0, len(chunks))
+	for i, v := range chunks {
+		knowledges = append(knowledges, &models.Knowledge{
+			Text:     v.Content,
+			VectorId: vectorIds[i],
+			Type:     models.KnowledgeTypePure,
+			GroupKey: groupKey,
+			Position: int32(i),
+			Section:  v.Section,
+		})
+	}
+	err = new(models.Document).SaveWithChunks(document, knowledges)
+	if err != nil {
+		logger.Logger.Errorw("写入文档db错误", "err", err, "origin", origin)
+		if len(insertedIds) > 0 {
+			s.rollbackVectors(ctx, insertedIds)
+		}
+		return nil, false, err
+	}
+	AnswerCache.Invalidate(oldChunks)
+	Conflict.Schedule(append(knowledgeGroupKeys(oldChunks), groupKey)...)
+
+	oldVectorIds := make([]int64, 0)
+	for _, ids := range reuse {
+		oldVectorIds = append(oldVectorIds, ids...)
+	}
+	if len(oldVectorIds) > 0 {
+		err = milvus.MilvusHandler.Delete(ctx, oldVectorIds)
+		if err != nil {
+			// 旧切片db数据已删除 残留向量检索时查不到db数据会被忽略
+			logger.Logger.Errorw("删除旧切片向量错误", "err", err, "vector_ids", oldVectorIds)
+			err = nil
+		}
+	}
+	return document, true, nil
+}
+
+// 删除新写入的向量
+func (s *DocumentService) rollbackVectors(ctx context.Context, vectorIds []int64) {
+	if err := milvus.MilvusHandler.Delete(ctx, vectorIds); err != nil {
+		logger.Logger.Errorw("回滚向量错误", "err", err, "vector_ids", vectorIds)
+	}
+}
+
+// 删除文档及其全部切片
+func (s *DocumentService) DelDocument(ctx context.Context, id int64) (err error) {
+	if id <= 0 {
+		err = ErrInvalidParams
+		return
+	}
+	document, err := new(models.Document).GetById(id)
+	if err != nil {
+		logger.Logger.Errorw("查询文档错误", "err", err, "id", id)
+		return
+	}
+	if document == nil {
+		err = ErrDataNotFound
+		return
+	}
+	chunks, err := new(models.Knowledge).GetByDocumentId(id)
+	if err != nil {
+		logger.Logger.Errorw("查询文档切片错误", "err", err, "id", id)
+		return
+	}
+	vectorIds := make([]int64, 0, len(chunks))
+	for _, v := range chunks {
+		vectorIds = append(vectorIds, v.VectorId)
+	}
+	if len(vectorIds) > 0 {
+		err = milvus.MilvusHandler.Delete(ctx, vectorIds)
+		if err != nil {
+			logger.Logger.Errorw("删除向量数据库错误", "err", err, "vector_ids", vectorIds)
+			return
+		}
+	}
+	err = new(models.Document).DelWithChunks(id)
+	if err != nil {
+		logger.Logger.Errorw("删除文档db错误", "err", err, "id", id)
+		return
+	}
+	AnswerCache.Invalidate(chunks)
+	Conflict.Schedule(knowledgeGroupKeys(chunks)...)
+	return
+}
+
+// 获取文档及切片
+func (s *DocumentService) GetDocument(ctx context.Context, id int64) (detail *DocumentDetail, err error) {
+	document, err := new(models.Document).GetById(id)
+	if err != nil {
+		return
+	}
+	if document == nil {
+		err = ErrDataNotFound
+		return
+	}
+	chunks, err := new(models.Knowledge).GetByDocumentId(id)
+	if err != nil {
+		return
+	}
+	return &DocumentDetail{
+		Document: document,
+		Chunks:   chunks,
+	}, nil
+}
+
+// 分页查询
+func (s *DocumentService) GetList(ctx context.Context, page, pageSize int) (list []*models.Document, total int64, err error) {
+	return new(models.Document).GetList(page, pageSize)
+}
+
+// 文档任务参数
+type DocumentJobParams struct {
+	Title  string `json:"title"`
+	Origin string `json:"origin"`
+}
+
+// 异步保存文档任务 文件内容为文档内容
+func (s *DocumentService) documentJob(ctx context.Context, job *models.Job, progress *JobProgress) (any, error) {
+	params := new(DocumentJobParams)
+	if err := json.Unmarshal([]byte(job.Params), params); err != nil {
+		return nil, err
+	}
+	progress.SetTotal(1)
+	if !progress.Next() {
+		return nil, nil
+	}
+	content, err := os.ReadFile(job.File)
+	if err != nil {
+		return nil, err
+	}
+	document, changed, err := s.SaveDocument(ctx, params.Title, params.Origin, string(content))
+	progress.Done(0, err)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"document": document,
+		"changed":  changed,
+	}, nil
+}
@@ -0,0 +1,685 @@
+package service
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/embedding"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* 离线检索评测 */
+
+var (
+	Eval = new(EvalService)
+)
+
+// 使用评测集评估当前检索及回答效果 每次运行保存结果用于对比不同配置
+type EvalService struct {
+}
+
+// 评测用例参数
+type EvalCaseParams struct {
+	Question       string  `json:"question"`
+	ExpectedIds    []int64 `json:"expected_ids"`    // 期望检索到的知识id
+	ExpectedAnswer string  `json:"expected_answer"` // 期望答案
+}
+
+// 评测集参数
+type EvalSetParams struct {
+	Id          int64             `json:"id"` // 大于0时更新并替换全部用例
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Cases       []*EvalCaseParams `json:"cases"`
+}
+
+// 评测集及用例
+type EvalSetDetail struct {
+	*models.EvalSet
+	Cases []*models.EvalCase `json:"cases"`
+}
+
+// 运行参数
+type EvalRunParams struct {
+	SetId         int64    `json:"set_id"`
+	Label         string   `json:"label"` // 说明 如修改了哪些配置
+	KnowledgeBase string   `json:"knowledge_base"`
+	TopK          int      `json:"top_k"`
+	Answer        bool     `json:"answer"`     // 是否生成答案并计算答案相似度 会调用大模型
+	Chain         string   `json:"chain"`      // 回答方式 为空时按配置
+	Strategies    []string `json:"strategies"` // 检索前的问题处理 为nil时按配置
+}
+
+// 运行及各用例结果
+type EvalRunDetail struct {
+	*models.EvalRun
+	Results []*models.EvalResult `json:"results"`
+}
+
+// 多次运行对比
+type EvalCompare struct {
+	Runs  []*models.EvalRun  `json:"runs"`
+	Cases []*EvalCompareCase `json:"cases"`
+}
+
+// 同一问题在各次运行中的结果 与runs顺序一致 未运行该问题时为null
+type EvalCompareCase struct {
+	Question string               `json:"question"`
+	Results  []*models.EvalResult `json:"results"`
+}
+
+// SaveSet 保存评测集 返回评测集id
+func (s *EvalService) SaveSet(ctx context.Context, params *EvalSetParams) (int64, error) {
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(params.Cases) == 0 {
+		return 0, ErrInvalidParams
+	}
+	now := time.Now().Unix()
+	cases := make([]*models.EvalCase, 0, len(params.Cases))
+	for i, v := range params.Cases {
+		question := strings.TrimSpace(v.Question)
+		if question == "" || (len(v.ExpectedIds) == 0 && v.ExpectedAnswer == "") {
+			return 0, fmt.Errorf("%w: 第%d个用例缺少问题或期望结果", ErrInvalidParams, i+1)
+		}
+		ids := make([]string, 0, len(v.ExpectedIds))
+		for _, id := range v.ExpectedIds {
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+		// 知识id在修改后会变化 同时保存分组标识
+		keys, err := expectedKeys(v.ExpectedIds)
+		if err != nil {
+			return 0, err
+		}
+		missing, err := missingKeys(keys)
+		if err != nil {
+			return 0, err
+		}
+		if len(missing) > 0 {
+			return 0, fmt.Errorf("%w: 第%d个用例的期望知识不存在: %s", ErrInvalidParams, i+1, strings.Join(missing, ","))
+		}
+		cases = append(cases, &models.EvalCase{
+			Question:       question,
+			ExpectedIds:    strings.Join(ids, ","),
+			ExpectedKeys:   strings.Join(keys, ","),
+			ExpectedAnswer: v.ExpectedAnswer,
+			CreatedAt:      now,
+		})
+	}
+	set := &models.EvalSet{
+		Id:          params.Id,
+		Name:        name,
+		Description: params.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if params.Id <= 0 {
+		err := new(models.EvalSet).CreateWithCases(set, cases)
+		return set.Id, err
+	}
+	old, err := new(models.EvalSet).GetById(params.Id)
+	if err != nil {
+		return 0, err
+	}
+	if old == nil {
+		return 0, ErrDataNotFound
+	}
+	return set.Id, new(models.EvalSet).UpdateWithCases(set, cases)
+}
+
+// GetSet 查询评测集及用例
+func (s *EvalService) GetSet(ctx context.Context, id int64) (*EvalSetDetail, error) {
+	set, err := new(models.EvalSet).GetById(id)
+	if err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return nil, ErrDataNotFound
+	}
+	cases, err := new(models.EvalCase).GetBySetId(id)
+	if err != nil {
+		return nil, err
+	}
+	return &EvalSetDetail{
+		EvalSet: set,
+		Cases:   cases,
+	}, nil
+}
+
+// GetSetList 分页查询评测集
+func (s *EvalService) GetSetList(ctx context.Context, page, pageSize int) ([]*models.EvalSet, int64, error) {
+	return new(models.EvalSet).GetList(page, pageSize)
+}
+
+// DelSet 删除评测集及用例 运行记录保留
+func (s *EvalService) DelSet(ctx context.Context, id int64) error {
+	if id <= 0 {
+		return ErrInvalidParams
+	}
+	return new(models.EvalSet).DelWithCases(id)
+}
+
+// 校验运行参数并返回用例
+func (s *EvalService) prepare(params *EvalRunParams) ([]*models.EvalCase, error) {
+	if params.SetId <= 0 {
+		return nil, ErrInvalidParams
+	}
+	if params.TopK <= 0 {
+		params.TopK = common.DefaultTopK
+	}
+	if params.TopK > maxRetrieveTopK {
+		return nil, fmt.Errorf("%w: top_k不能超过%d", ErrInvalidParams, maxRetrieveTopK)
+	}
+	search := searchConfig(params.KnowledgeBase)
+	if _, err := resolveChain(params.Chain, search); err != nil {
+		return nil, err
+	}
+	if _, err := resolveStrategies(params.Strategies, search); err != nil {
+		return nil, err
+	}
+	cases, err := new(models.EvalCase).GetBySetId(params.SetId)
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, ErrDataNotFound
+	}
+	return cases, nil
+}
+
+// Submit 创建评测任务 返回任务
+func (s *EvalService) Submit(ctx context.Context, params *EvalRunParams) (*models.Job, error) {
+	if _, err := s.prepare(params); err != nil {
+		return nil, err
+	}
+	return Job.Enqueue(JobTypeEval, params, nil, "")
+}
+
+// Run 同步执行一次评测 用于命令行
+func (s *EvalService) Run(ctx context.Context, params *EvalRunParams) (*models.EvalRun, error) {
+	cases, err := s.prepare(params)
+	if err != nil {
+		return nil, err
+	}
+	run, err := s.createRun(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.execute(ctx, run, params, cases, nil); err != nil {
+		return run, err
+	}
+	return new(models.EvalRun).GetById(run.Id)
+}
+
+// 评测任务 每个用例为一行 重启后继续同一运行
+func (s *EvalService) evalJob(ctx context.Context, job *models.Job, progress *JobProgress) (any, error) {
+	params := new(EvalRunParams)
+	if err := json.Unmarshal([]byte(job.Params), params); err != nil {
+		return nil, err
+	}
+	cases, err := s.prepare(params)
+	if err != nil {
+		return nil, err
+	}
+	run, err := new(models.EvalRun).GetByJobId(job.Id)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		if run, err = s.createRun(params, job.Id); err != nil {
+			return nil, err
+		}
+	} else {
+		err = new(models.EvalRun).UpdateById(run.Id, map[string]any{
+			"status":  models.EvalRunStatusRunning,
+			"message": "",
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	progress.SetTotal(int64(len(cases)))
+	if err = s.execute(ctx, run, params, cases, progress); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"run_id": run.Id,
+	}, nil
+}
+
+// 创建运行记录 保存运行参数及当前配置
+func (s *EvalService) createRun(params *EvalRunParams, jobId int64) (*models.EvalRun, error) {
+	snapshot := map[string]any{
+		"params":   params,
+		"search":   searchConfig(params.KnowledgeBase),
+		"document": cfg.Document,
+	}
+	if cfg.Embedding != nil {
+		snapshot["embedding_model"] = cfg.Embedding.Model
+	}
+	if llm.LLMHandler != nil {
+		endpoints := make([]string, 0)
+		for _, v := range llm.LLMHandler.Health() {
+			endpoints = append(endpoints, v.Name+":"+v.Model)
+		}
+		snapshot["llm_endpoints"] = endpoints
+	}
+	js, _ := json.Marshal(snapshot)
+	now := time.Now().Unix()
+	run := &models.EvalRun{
+		SetId:     params.SetId,
+		JobId:     jobId,
+		Label:     params.Label,
+		Config:    string(js),
+		Status:    models.EvalRunStatusRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := new(models.EvalRun).Create(run); err != nil {
+		logger.Logger.Errorw("创建评测运行错误", "err", err, "params", params)
+		return nil, err
+	}
+	return run, nil
+}
+
+// 依次评测用例并汇总 progress为nil时不记录进度
+func (s *EvalService) execute(ctx context.Context, run *models.EvalRun, params *EvalRunParams, cases []*models.EvalCase, progress *JobProgress) error {
+	for i, c := range cases {
+		if ctx.Err() != nil {
+			break
+		}
+		if progress != nil && !progress.Next() {
+			continue
+		}
+		result := s.evalCase(ctx, run.Id, params, c)
+		if ctx.Err() != nil {
+			break
+		}
+		var err error
+		if result.Error != "" {
+			err = fmt.Errorf("用例%d: %s", c.Id, result.Error)
+		}
+		if saveErr := new(models.EvalResult).Create(result); saveErr != nil {
+			logger.Logger.Errorw("保存评测结果错误", "err", saveErr, "run_id", run.Id, "case_id", c.Id)
+			err = saveErr
+		}
+		if progress != nil {
+			progress.Done(i+1, err)
+		}
+	}
+	if ctx.Err() != nil {
+		_ = new(models.EvalRun).UpdateById(run.Id, map[string]any{
+			"status":  models.EvalRunStatusFailed,
+			"message": "评测已中断",
+		})
+		return ctx.Err()
+	}
+	return s.summarize(run, params, cases)
+}
+
+// 评测单个用例
+func (s *EvalService) evalCase(ctx context.Context, runId int64, params *EvalRunParams, c *models.EvalCase) *models.EvalResult {
+	result := &models.EvalResult{
+		RunId:     runId,
+		CaseId:    c.Id,
+		Question:  c.Question,
+		CreatedAt: time.Now().Unix(),
+	}
+	start := time.Now()
+	cache := false
+	search, err := Knowledge.Search(ctx, &SearchParams{
+		Question:      c.Question,
+		TopK:          params.TopK,
+		KnowledgeBase: params.KnowledgeBase,
+		Chain:         params.Chain,
+		Strategies:    params.Strategies,
+		Cache:         &cache,
+		RetrieveOnly:  !params.Answer,
+	})
+	result.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// 优先使用保存时的分组标识 之前保存的用例没有时按知识id转换
+	expected := parseKeys(c.ExpectedKeys)
+	if len(expected) == 0 {
+		if expected, err = expectedKeys(parseIds(c.ExpectedIds)); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+	// 期望知识已删除或文档切片变化时标记 便于区分检索效果下降和评测集过期
+	missing, err := missingKeys(expected)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Missing = strings.Join(missing, ",")
+	retrieved := make([]string, 0, len(search.Knowledges))
+	ids := make([]string, 0, len(search.Knowledges))
+	for _, v := range search.Knowledges {
+		retrieved = append(retrieved, evalKey(v.Knowledge))
+		ids = append(ids, strconv.FormatInt(v.Id, 10))
+	}
+	result.RetrievedIds = strings.Join(ids, ",")
+	result.Recall, result.RR, result.NDCG = retrievalMetrics(retrieved, expected, params.TopK)
+
+	result.Answer = search.Answer
+	if c.ExpectedAnswer != "" && search.Answer != "" {
+		vectors, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, []string{search.Answer, c.ExpectedAnswer})
+		if err != nil || len(vectors) != 2 {
+			logger.Logger.Warnw("计算答案相似度错误", "err", err, "case_id", c.Id)
+		} else {
+			result.AnswerSimilarity = cosineSimilarity(vectors[0], vectors[1])
+		}
+	}
+	return result
+}
+
+// 汇总全部用例结果 检索指标只统计有期望知识的用例 答案相似度只统计生成了答案且有期望答案的用例
+func (s *EvalService) summarize(run *models.EvalRun, params *EvalRunParams, cases []*models.EvalCase) error {
+	results, err := new(models.EvalResult).GetByRunId(run.Id)
+	if err != nil {
+		return err
+	}
+	caseMap := make(map[int64]*models.EvalCase)
+	for _, v := range cases {
+		caseMap[v.Id] = v
+	}
+	var recall, mrr, ndcg, similarity float64
+	var retrievalCases, answerCases, latencyCases, failed, stale int
+	var latency int64
+	for _, v := range results {
+		c, ok := caseMap[v.CaseId]
+		if !ok {
+			continue
+		}
+		if v.Missing != "" {
+			stale++
+		}
+		if v.Error != "" {
+			failed++
+		} else {
+			latency += v.Latency
+			latencyCases++
+		}
+		// 出错的用例检索指标计为0
+		if c.ExpectedIds != "" {
+			recall += v.Recall
+			mrr += v.RR
+			ndcg += v.NDCG
+			retrievalCases++
+		}
+		if params.Answer && c.ExpectedAnswer != "" && v.Error == "" {
+			similarity += v.AnswerSimilarity
+			answerCases++
+		}
+	}
+	data := map[string]any{
+		"status": models.EvalRunStatusFinished,
+		"cases":  len(results),
+	}
+	if retrievalCases > 0 {
+		data["recall"] = recall / float64(retrievalCases)
+		data["mrr"] = mrr / float64(retrievalCases)
+		data["ndcg"] = ndcg / float64(retrievalCases)
+	}
+	if answerCases > 0 {
+		data["answer_similarity"] = similarity / float64(answerCases)
+	}
+	if latencyCases > 0 {
+		data["latency"] = latency / int64(latencyCases)
+	}
+	messages := make([]string, 0)
+	if failed > 0 {
+		messages = append(messages, fmt.Sprintf("%d个用例出错", failed))
+	}
+	if stale > 0 {
+		messages = append(messages, fmt.Sprintf("%d个用例的期望知识已不存在", stale))
+	}
+	if len(messages) > 0 {
+		data["message"] = strings.Join(messages, "，")
+	}
+	return new(models.EvalRun).UpdateById(run.Id, data)
+}
+
+// GetRun 查询运行及各用例结果
+func (s *EvalService) GetRun(ctx context.Context, id int64) (*EvalRunDetail, error) {
+	run, err := new(models.EvalRun).GetById(id)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, ErrDataNotFound
+	}
+	results, err := new(models.EvalResult).GetByRunId(id)
+	if err != nil {
+		return nil, err
+	}
+	return &EvalRunDetail{
+		EvalRun: run,
+		Results: results,
+	}, nil
+}
+
+// GetRunList 分页查询运行记录 setId为0时查询全部
+func (s *EvalService) GetRunList(ctx context.Context, page, pageSize int, setId int64) ([]*models.EvalRun, int64, error) {
+	return new(models.EvalRun).GetList(page, pageSize, setId)
+}
+
+// Compare 对比多次运行 按问题对齐各次运行的结果
+func (s *EvalService) Compare(ctx context.Context, ids []int64) (*EvalCompare, error) {
+	if len(ids) == 0 {
+		return nil, ErrInvalidParams
+	}
+	runs, err := new(models.EvalRun).BatchGetByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	// 按传入顺序排列
+	slices.SortStableFunc(runs, func(a, b *models.EvalRun) int {
+		return slices.Index(ids, a.Id) - slices.Index(ids, b.Id)
+	})
+	runIds := make([]int64, 0, len(runs))
+	for _, v := range runs {
+		runIds = append(runIds, v.Id)
+	}
+	compare := &EvalCompare{
+		Runs:  runs,
+		Cases: make([]*EvalCompareCase, 0),
+	}
+	if len(runIds) == 0 {
+		return compare, nil
+	}
+	results, err := new(models.EvalResult).GetByRunIds(runIds)
+	if err != nil {
+		return nil, err
+	}
+	caseMap := make(map[string]*EvalCompareCase)
+	for _, v := range results {
+		c, ok := caseMap[v.Question]
+		if !ok {
+			c = &EvalCompareCase{
+				Question: v.Question,
+				Results:  make([]*models.EvalResult, len(runIds)),
+			}
+			caseMap[v.Question] = c
+			compare.Cases = append(compare.Cases, c)
+		}
+		c.Results[slices.Index(runIds, v.RunId)] = v
+	}
+	return compare, nil
+}
+
+// 知识的评测标识 文档切片为文档id及序号 其余为分组标识
+// 问答按分组汇总后一个排名对应一个分组 同一分组的多个期望问题只算一个相关结果
+func evalKey(k *models.Knowledge) string {
+	if k.DocumentId > 0 {
+		return fmt.Sprintf("doc:%d:%d", k.DocumentId, k.Position)
+	}
+	if k.GroupKey != "" {
+		return "group:" + k.GroupKey
+	}
+	return "id:" + strconv.FormatInt(k.Id, 10)
+}
+
+// 期望知识id转为去重后的评测标识 不存在的知识保留id标识 不会被检索到
+func expectedKeys(ids []int64) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	list, err := new(models.Knowledge).BatchGetByPks(ids)
+	if err != nil {
+		return nil, err
+	}
+	knowledgeMap := make(map[int64]*models.Knowledge)
+	for _, v := range list {
+		knowledgeMap[v.Id] = v
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key := "id:" + strconv.FormatInt(id, 10)
+		if v, ok := knowledgeMap[id]; ok {
+			key = evalKey(v)
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// 查询已不存在的评测标识
+func missingKeys(keys []string) ([]string, error) {
+	exists := make(map[string]bool)
+	groupKeys := make([]string, 0)
+	ids := make([]int64, 0)
+	documentIds := make([]int64, 0)
+	for _, key := range keys {
+		typ, value, _ := strings.Cut(key, ":")
+		switch typ {
+		case "group":
+			groupKeys = append(groupKeys, value)
+		case "id":
+			id, _ := strconv.ParseInt(value, 10, 64)
+			ids = append(ids, id)
+		case "doc":
+			documentId, _, _ := strings.Cut(value, ":")
+			id, _ := strconv.ParseInt(documentId, 10, 64)
+			if !slices.Contains(documentIds, id) {
+				documentIds = append(documentIds, id)
+			}
+		}
+	}
+	knowledgeHandler := new(models.Knowledge)
+	if len(groupKeys) > 0 {
+		counts, err := knowledgeHandler.CountByGroupKeys(groupKeys)
+		if err != nil {
+			return nil, err
+		}
+		for groupKey := range counts {
+			exists["group:"+groupKey] = true
+		}
+	}
+	if len(ids) > 0 {
+		list, err := knowledgeHandler.BatchGetByPks(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range list {
+			exists["id:"+strconv.FormatInt(v.Id, 10)] = true
+		}
+	}
+	for _, documentId := range documentIds {
+		list, err := knowledgeHandler.GetByDocumentId(documentId)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range list {
+			exists[evalKey(v)] = true
+		}
+	}
+	missing := make([]string, 0)
+	for _, key := range keys {
+		if !exists[key] {
+			missing = append(missing, key)
+		}
+	}
+	return missing, nil
+}
+
+// 计算recall@k、倒数排名和nDCG@k retrieved为各排名的评测标识 expected为去重后的期望标识
+// 同一标识在多个排名出现时只有第一次计为相关 dcg不会超过idcg
+func retrievalMetrics(retrieved, expected []string, k int) (recall, rr, ndcg float64) {
+	if len(expected) == 0 {
+		return
+	}
+	retrieved = retrieved[:min(len(retrieved), k)]
+	found := make(map[string]bool)
+	var dcg float64
+	for i, key := range retrieved {
+		if found[key] || !slices.Contains(expected, key) {
+			continue
+		}
+		found[key] = true
+		if rr == 0 {
+			rr = 1 / float64(i+1)
+		}
+		dcg += 1 / math.Log2(float64(i+2))
+	}
+	var idcg float64
+	for i := range min(len(expected), k) {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	recall = float64(len(found)) / float64(len(expected))
+	ndcg = dcg / idcg
+	return
+}
+
+// 余弦相似度
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// 解析,分隔的评测标识
+func parseKeys(s string) []string {
+	keys := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			keys = append(keys, v)
+		}
+	}
+	return keys
+}
+
+// 解析,分隔的id
+func parseIds(s string) []int64 {
+	ids := make([]int64, 0)
+	for _, v := range strings.Split(s, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
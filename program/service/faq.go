@@ -0,0 +1,278 @@
+package service
+
+import (
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+/* 从纯知识中抽取候选问答 */
+
+const (
+	// 每个切片默认最多抽取的问答数
+	defaultFaqPerChunk = 3
+	maxFaqPerChunk     = 10
+
+	faqPrompt = `你是知识库编辑，请阅读下面的资料，整理出用户最可能提出的问题及对应答案，最多%d组。
+要求：
+1. 答案只能依据资料内容，资料中没有的信息不要编造
+2. 问题使用用户的口吻，简洁明确
+3. 只输出JSON数组，例如：[{"question": "问题", "answer": "答案"}]，资料中没有可整理的内容时输出[]
+
+资料：
+%s`
+)
+
+var (
+	Faq = new(FaqService)
+)
+
+// 候选问答
+type FaqService struct {
+}
+
+// 抽取任务参数
+type FaqExtractParams struct {
+	KnowledgeIds []int64 `json:"knowledge_ids"` // 指定纯知识
+	DocumentId   int64   `json:"document_id"`   // 指定文档的全部切片
+	MaxPerChunk  int     `json:"max_per_chunk"` // 每个切片最多抽取的问答数
+}
+
+// 模型输出的问答
+type faqPair struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// 查询待抽取的知识切片
+func (s *FaqService) getSources(params *FaqExtractParams) ([]*models.Knowledge, error) {
+	sources := make([]*models.Knowledge, 0)
+	knowledgeHandler := new(models.Knowledge)
+	if params.DocumentId > 0 {
+		list, err := knowledgeHandler.GetByDocumentId(params.DocumentId)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, list...)
+	}
+	if len(params.KnowledgeIds) > 0 {
+		list, err := knowledgeHandler.BatchGetByPks(params.KnowledgeIds)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range list {
+			if v.Type == models.KnowledgeTypePure {
+				sources = append(sources, v)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// Extract 对单个知识切片抽取候选问答
+func (s *FaqService) Extract(ctx context.Context, source *models.Knowledge, maxPerChunk int) ([]*models.FaqCandidate, error) {
+	content := source.Text
+	if source.Section != "" {
+		content = source.Section + "\n" + content
+	}
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(faqPrompt, maxPerChunk, content))
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]*faqPair, 0)
+	if err = extractJSON(text, &pairs); err != nil {
+		return nil, err
+	}
+
+	candidates := make([]*models.FaqCandidate, 0, len(pairs))
+	for _, v := range pairs {
+		if v == nil || v.Question == "" || v.Answer == "" {
+			continue
+		}
+		candidates = append(candidates, &models.FaqCandidate{
+			KnowledgeId: source.Id,
+			DocumentId:  source.DocumentId,
+			Position:    source.Position,
+			Question:    v.Question,
+			Answer:      v.Answer,
+			Status:      models.FaqStatusPending,
+		})
+		if len(candidates) >= maxPerChunk {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+	err = new(models.FaqCandidate).BatchCreate(candidates)
+	return candidates, err
+}
+
+// 抽取任务 每个知识切片为一行
+func (s *FaqService) extractJob(ctx context.Context, job *models.Job, progress *JobProgress) (any, error) {
+	params := new(FaqExtractParams)
+	if err := json.Unmarshal([]byte(job.Params), params); err != nil {
+		return nil, err
+	}
+	maxPerChunk := params.MaxPerChunk
+	if maxPerChunk <= 0 {
+		maxPerChunk = defaultFaqPerChunk
+	}
+	maxPerChunk = min(maxPerChunk, maxFaqPerChunk)
+	sources, err := s.getSources(params)
+	if err != nil {
+		return nil, err
+	}
+	progress.SetTotal(int64(len(sources)))
+
+	total := 0
+	for i, source := range sources {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !progress.Next() {
+			continue
+		}
+		candidates, err := s.Extract(ctx, source, maxPerChunk)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			logger.Logger.Errorw("抽取候选问答错误", "err", err, "knowledge_id", source.Id)
+			err = fmt.Errorf("知识%d: %w", source.Id, err)
+		}
+		total += len(candidates)
+		progress.Done(i+1, err)
+	}
+	return map[string]any{
+		"candidates": total,
+	}, nil
+}
+
+// 采纳时编辑后的问答
+type FaqAcceptItem struct {
+	Id        int64    `json:"id"`
+	Questions []string `json:"questions"` // 为空时使用候选问题 可补充相似问题
+	Answer    string   `json:"answer"`    // 为空时使用候选答案
+	Tags      []string `json:"tags"`
+}
+
+// Accept 采纳候选问答 每条通过SaveQAndA创建一个问答分组 有不存在或已处理的候选时不保存任何一条
+func (s *FaqService) Accept(ctx context.Context, items []*FaqAcceptItem) (groupKeys map[int64]string, err error) {
+	if len(items) == 0 {
+		err = ErrInvalidParams
+		return
+	}
+	ids := make([]int64, 0, len(items))
+	for _, v := range items {
+		ids = append(ids, v.Id)
+	}
+	list, err := new(models.FaqCandidate).BatchGetByIds(ids)
+	if err != nil {
+		logger.Logger.Errorw("查询候选问答错误", "err", err, "ids", ids)
+		return
+	}
+	candidates := make(map[int64]*models.FaqCandidate)
+	for _, v := range list {
+		candidates[v.Id] = v
+	}
+	accepted := make(map[int64]bool)
+	for _, item := range items {
+		candidate, ok := candidates[item.Id]
+		if !ok {
+			err = fmt.Errorf("%w: 候选问答%d不存在", ErrDataNotFound, item.Id)
+			return
+		}
+		if candidate.Status != models.FaqStatusPending || accepted[item.Id] {
+			err = fmt.Errorf("%w: 候选问答%d已处理", ErrInvalidParams, item.Id)
+			return
+		}
+		accepted[item.Id] = true
+	}
+
+	// 先把候选标记为已采纳并分配分组标识 并发采纳同一候选时只有一个成功
+	candidateHandler := new(models.FaqCandidate)
+	claimed := make(map[int64]string)
+	for _, item := range items {
+		groupKey := new(models.Knowledge).GenGroupKey()
+		ok, err := candidateHandler.UpdateByStatus(item.Id, models.FaqStatusPending, map[string]any{
+			"status":    models.FaqStatusAccepted,
+			"group_key": groupKey,
+		})
+		if err == nil && !ok {
+			err = fmt.Errorf("%w: 候选问答%d已处理", ErrInvalidParams, item.Id)
+		}
+		if err != nil {
+			logger.Logger.Errorw("更新候选问答错误", "err", err, "id", item.Id)
+			s.release(claimed)
+			return nil, err
+		}
+		claimed[item.Id] = groupKey
+	}
+
+	groupKeys = make(map[int64]string)
+	for _, item := range items {
+		candidate := candidates[item.Id]
+		questions := trimValues(item.Questions)
+		if len(questions) == 0 {
+			questions = []string{candidate.Question}
+		}
+		answer := item.Answer
+		if answer == "" {
+			answer = candidate.Answer
+		}
+		groupKey, err := Knowledge.SaveQAndA(ctx, &QAndAGroup{
+			GroupKey:  claimed[item.Id],
+			Questions: questions,
+			Answer:    answer,
+			Tags:      item.Tags,
+		})
+		if err != nil {
+			logger.Logger.Errorw("保存问答错误", "err", err, "id", item.Id)
+			// 未保存的候选恢复为待审核
+			for id := range groupKeys {
+				delete(claimed, id)
+			}
+			s.release(claimed)
+			return groupKeys, err
+		}
+		groupKeys[item.Id] = groupKey
+		err = candidateHandler.UpdateById(item.Id, map[string]any{
+			"question": questions[0],
+			"answer":   answer,
+		})
+		if err != nil {
+			logger.Logger.Errorw("更新候选问答错误", "err", err, "id", item.Id)
+		}
+	}
+	return groupKeys, nil
+}
+
+// 把已标记为采纳但未保存的候选恢复为待审核
+func (s *FaqService) release(claimed map[int64]string) {
+	for id := range claimed {
+		_, err := new(models.FaqCandidate).UpdateByStatus(id, models.FaqStatusAccepted, map[string]any{
+			"status":    models.FaqStatusPending,
+			"group_key": "",
+		})
+		if err != nil {
+			logger.Logger.Errorw("恢复候选问答错误", "err", err, "id", id)
+		}
+	}
+}
+
+// Reject 拒绝候选问答
+func (s *FaqService) Reject(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return ErrInvalidParams
+	}
+	return new(models.FaqCandidate).UpdateStatusByIds(ids, models.FaqStatusPending, models.FaqStatusRejected)
+}
+
+// 分页查询
+func (s *FaqService) GetList(ctx context.Context, page, pageSize int, status int32, documentId int64) (list []*models.FaqCandidate, total int64, err error) {
+	return new(models.FaqCandidate).GetList(page, pageSize, status, documentId)
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+/* 答案评价及转为问答 */
+
+var (
+	Feedback = new(FeedbackService)
+)
+
+// 答案评价
+type FeedbackService struct {
+}
+
+// 评价参数
+type FeedbackParams struct {
+	QueryLogId int64  `json:"query_log_id"`
+	Helpful    bool   `json:"helpful"`
+	Comment    string `json:"comment"`
+}
+
+// 评价及对应的查询记录
+type FeedbackItem struct {
+	*models.AnswerFeedback
+	QueryLog *models.QueryLog `json:"query_log"`
+}
+
+// 转为问答参数
+type PromoteParams struct {
+	QueryLogId int64    `json:"query_log_id"`
+	Questions  []string `json:"questions"` // 为空时使用查询的问题 可补充相似问题
+	Answer     string   `json:"answer"`    // 为空时使用生成的答案
+	Tags       []string `json:"tags"`
+}
+
+// Save 保存对一次查询答案的评价
+func (s *FeedbackService) Save(ctx context.Context, params *FeedbackParams) (int64, error) {
+	log, err := new(models.QueryLog).GetById(params.QueryLogId)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "query_log_id", params.QueryLogId)
+		return 0, err
+	}
+	if log == nil {
+		return 0, ErrDataNotFound
+	}
+	rating := models.FeedbackUnhelpful
+	if params.Helpful {
+		rating = models.FeedbackHelpful
+	}
+	now := time.Now().Unix()
+	feedback := &models.AnswerFeedback{
+		QueryLogId: params.QueryLogId,
+		Rating:     rating,
+		Comment:    params.Comment,
+		Status:     models.FeedbackStatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err = new(models.AnswerFeedback).Create(feedback); err != nil {
+		logger.Logger.Errorw("保存评价错误", "err", err, "params", params)
+		return 0, err
+	}
+	return feedback.Id, nil
+}
+
+// GetList 分页查询评价 附带查询记录 rating为0、status小于0时不过滤
+func (s *FeedbackService) GetList(ctx context.Context, page, pageSize int, rating, status int32) (list []*FeedbackItem, total int64, err error) {
+	list = make([]*FeedbackItem, 0)
+	feedbacks, total, err := new(models.AnswerFeedback).GetList(page, pageSize, rating, status)
+	if err != nil || len(feedbacks) == 0 {
+		return
+	}
+	ids := make([]int64, 0, len(feedbacks))
+	for _, v := range feedbacks {
+		ids = append(ids, v.QueryLogId)
+	}
+	logs, err := new(models.QueryLog).BatchGetByIds(ids)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
+		return
+	}
+	logMap := make(map[int64]*models.QueryLog)
+	for _, v := range logs {
+		logMap[v.Id] = v
+	}
+	for _, v := range feedbacks {
+		list = append(list, &FeedbackItem{
+			AnswerFeedback: v,
+			QueryLog:       logMap[v.QueryLogId],
+		})
+	}
+	return
+}
+
+// Promote 把模型生成的答案通过SaveQAndA保存为新的问答分组 该查询的待处理评价标记为已转为问答
+func (s *FeedbackService) Promote(ctx context.Context, params *PromoteParams) (groupKey string, err error) {
+	log, err := new(models.QueryLog).GetById(params.QueryLogId)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "query_log_id", params.QueryLogId)
+		return
+	}
+	if log == nil {
+		err = ErrDataNotFound
+		return
+	}
+	if log.AnswerSource != AnswerSourceGenerated {
+		err = fmt.Errorf("%w: 只能转换模型生成的答案", ErrInvalidParams)
+		return
+	}
+	if log.GroupKey != "" {
+		err = fmt.Errorf("%w: 答案已转为问答%s", ErrInvalidParams, log.GroupKey)
+		return
+	}
+	questions := trimValues(params.Questions)
+	if len(questions) == 0 {
+		// 多轮对话使用改写后的独立问题
+		question := log.StandaloneQuestion
+		if question == "" {
+			question = log.Question
+		}
+		questions = []string{question}
+	}
+	answer := params.Answer
+	if answer == "" {
+		answer = log.Answer
+	}
+
+	// 先在查询记录上占用分组标识 并发转换同一答案时只有一个成功
+	logHandler := new(models.QueryLog)
+	claimedKey := new(models.Knowledge).GenGroupKey()
+	ok, err := logHandler.UpdateGroupKey(log.Id, "", claimedKey)
+	if err != nil {
+		logger.Logger.Errorw("更新查询记录错误", "err", err, "query_log_id", log.Id)
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("%w: 答案已转为问答", ErrInvalidParams)
+		return
+	}
+	groupKey, err = Knowledge.SaveQAndA(ctx, &QAndAGroup{
+		GroupKey:  claimedKey,
+		Questions: questions,
+		Answer:    answer,
+		Tags:      params.Tags,
+	})
+	if err != nil {
+		logger.Logger.Errorw("保存问答错误", "err", err, "query_log_id", params.QueryLogId)
+		// 保存失败释放分组标识
+		if _, releaseErr := logHandler.UpdateGroupKey(log.Id, claimedKey, ""); releaseErr != nil {
+			logger.Logger.Errorw("恢复查询记录错误", "err", releaseErr, "query_log_id", log.Id)
+		}
+		return
+	}
+	err = new(models.AnswerFeedback).UpdateStatusByQueryLogId(log.Id, models.FeedbackStatusPending, models.FeedbackStatusPromoted)
+	if err != nil {
+		logger.Logger.Errorw("更新评价错误", "err", err, "query_log_id", log.Id)
+	}
+	return
+}
+
+// Ignore 忽略待处理的评价
+func (s *FeedbackService) Ignore(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return ErrInvalidParams
+	}
+	return new(models.AnswerFeedback).UpdateStatusByIds(ids, models.FeedbackStatusPending, models.FeedbackStatusIgnored)
+}
@@ -0,0 +1,380 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/embedding"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/* 知识缺口分析 对未回答的问题聚类 由模型总结缺少的主题 */
+
+const (
+	defaultGapMinSimilarity    = 0.6
+	defaultGapClusterThreshold = 0.8
+	defaultGapMaxQuestions     = 2000
+	defaultGapMaxTopics        = 20
+	// 每个主题保存的示例问题数
+	gapSamples = 5
+
+	gapPrompt = `你是知识库编辑，下面是用户提出但知识库没能回答的一组相似问题，请总结这些问题共同关心的主题。
+要求：
+1. topic为简短的主题名称，不超过20个字
+2. description说明用户想了解什么、知识库需要补充哪些内容
+3. 只输出JSON，例如：{"topic": "主题", "description": "说明"}
+
+问题：
+%s`
+)
+
+var (
+	Gap = new(GapService)
+)
+
+// 知识缺口
+type GapService struct {
+}
+
+// 分析参数
+type GapParams struct {
+	Start         string  `json:"start"` // 日期范围 2006-01-02 为空时不限制
+	End           string  `json:"end"`
+	KnowledgeBase string  `json:"knowledge_base"` // 为空时分析全部知识库
+	MinSimilarity float32 `json:"min_similarity"` // 为0时使用配置
+	Until         int64   `json:"until"`          // 提交时间 重启后分析同一批查询及反馈
+}
+
+// 知识缺口及示例问题
+type GapItem struct {
+	*models.KnowledgeGap
+	Samples []string `json:"samples"`
+}
+
+// 转为问答参数
+type GapPromoteParams struct {
+	Id        int64    `json:"id"`
+	Questions []string `json:"questions"` // 为空时使用示例问题
+	Answer    string   `json:"answer"`
+	Tags      []string `json:"tags"`
+}
+
+// 去重后的问题
+type gapQuestion struct {
+	text   string
+	count  int64 // 查询次数
+	vector []float32
+}
+
+// 问题聚类
+type gapCluster struct {
+	centroid  []float32
+	questions []*gapQuestion
+	queries   int64
+}
+
+// 模型输出的主题
+type gapTopic struct {
+	Topic       string `json:"topic"`
+	Description string `json:"description"`
+}
+
+func gapConfig() config.GapConfig {
+	c := config.GapConfig{}
+	if cfg.Gap != nil {
+		c = *cfg.Gap
+	}
+	if c.MinSimilarity <= 0 {
+		c.MinSimilarity = defaultGapMinSimilarity
+	}
+	if c.ClusterThreshold <= 0 {
+		c.ClusterThreshold = defaultGapClusterThreshold
+	}
+	if c.MaxQuestions <= 0 {
+		c.MaxQuestions = defaultGapMaxQuestions
+	}
+	if c.MaxTopics <= 0 {
+		c.MaxTopics = defaultGapMaxTopics
+	}
+	return c
+}
+
+// Submit 创建知识缺口分析任务
+func (s *GapService) Submit(ctx context.Context, params *GapParams) (*models.Job, error) {
+	if params.MinSimilarity < 0 || params.MinSimilarity > 1 {
+		return nil, fmt.Errorf("%w: min_similarity应在0到1之间", ErrInvalidParams)
+	}
+	if _, _, err := parseDayRange(params.Start, params.End); err != nil {
+		return nil, err
+	}
+	params.Until = time.Now().Unix()
+	return Job.Enqueue(JobTypeGap, params, nil, "")
+}
+
+// 分析任务 每个主题为一行
+func (s *GapService) gapJob(ctx context.Context, job *models.Job, progress *JobProgress) (any, error) {
+	params := new(GapParams)
+	if err := json.Unmarshal([]byte(job.Params), params); err != nil {
+		return nil, err
+	}
+	start, end, err := parseDayRange(params.Start, params.End)
+	if err != nil {
+		return nil, err
+	}
+	if end == 0 || end > params.Until {
+		end = params.Until + 1
+	}
+	c := gapConfig()
+	if params.MinSimilarity > 0 {
+		c.MinSimilarity = params.MinSimilarity
+	}
+	logs, err := new(models.QueryLog).GetUnanswered(start, end, params.Until, params.KnowledgeBase, c.MinSimilarity, c.MaxQuestions)
+	if err != nil {
+		return nil, err
+	}
+	questions := collectQuestions(logs)
+	if len(questions) == 0 {
+		progress.SetTotal(0)
+		return map[string]any{
+			"queries": 0,
+			"topics":  0,
+		}, nil
+	}
+
+	texts := make([]string, 0, len(questions))
+	for _, v := range questions {
+		texts = append(texts, v.text)
+	}
+	vectors, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, texts)
+	if err != nil {
+		logger.Logger.Errorw("处理问题为向量错误", "err", err, "job_id", job.Id)
+		return nil, err
+	}
+	if len(vectors) != len(questions) {
+		return nil, ErrVectorTransform
+	}
+	for i, v := range questions {
+		v.vector = vectors[i]
+	}
+	clusters := clusterQuestions(questions, c.ClusterThreshold)
+	clusters = clusters[:min(len(clusters), c.MaxTopics)]
+	progress.SetTotal(int64(len(clusters)))
+
+	for i, cluster := range clusters {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !progress.Next() {
+			continue
+		}
+		err := s.label(ctx, job.Id, cluster)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		progress.Done(i+1, err)
+	}
+	return map[string]any{
+		"queries": len(logs),
+		"topics":  len(clusters),
+	}, nil
+}
+
+// 按问题去重 多轮对话使用改写后的独立问题 按查询次数从多到少
+func collectQuestions(logs []*models.QueryLog) []*gapQuestion {
+	questions := make([]*gapQuestion, 0)
+	questionMap := make(map[string]*gapQuestion)
+	for _, v := range logs {
+		text := v.StandaloneQuestion
+		if text == "" {
+			text = v.Question
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		question, ok := questionMap[text]
+		if !ok {
+			question = &gapQuestion{text: text}
+			questionMap[text] = question
+			questions = append(questions, question)
+		}
+		question.count++
+	}
+	slices.SortStableFunc(questions, func(a, b *gapQuestion) int {
+		return cmp.Compare(b.count, a.count)
+	})
+	return questions
+}
+
+// 依次把问题归入相似度最高且达到threshold的聚类 没有时新建聚类 聚类按查询次数从多到少
+func clusterQuestions(questions []*gapQuestion, threshold float32) []*gapCluster {
+	clusters := make([]*gapCluster, 0)
+	for _, q := range questions {
+		var best *gapCluster
+		bestSimilarity := threshold
+		for _, c := range clusters {
+			similarity := 1 / (1 + l2Distance(q.vector, c.centroid))
+			if similarity >= bestSimilarity {
+				best, bestSimilarity = c, similarity
+			}
+		}
+		if best == nil {
+			clusters = append(clusters, &gapCluster{
+				centroid:  slices.Clone(q.vector),
+				questions: []*gapQuestion{q},
+				queries:   q.count,
+			})
+			continue
+		}
+		best.questions = append(best.questions, q)
+		best.queries += q.count
+		// 聚类中心为各问题向量的平均值
+		n := float32(len(best.questions))
+		for i := range best.centroid {
+			best.centroid[i] += (q.vector[i] - best.centroid[i]) / n
+		}
+	}
+	slices.SortStableFunc(clusters, func(a, b *gapCluster) int {
+		return cmp.Compare(b.queries, a.queries)
+	})
+	return clusters
+}
+
+// 调用模型总结聚类的主题并保存 模型出错时使用查询最多的问题作为主题 任务取消时不保存
+func (s *GapService) label(ctx context.Context, jobId int64, cluster *gapCluster) (err error) {
+	samples := make([]string, 0, gapSamples)
+	for _, v := range cluster.questions[:min(len(cluster.questions), gapSamples)] {
+		samples = append(samples, v.text)
+	}
+	topic := new(gapTopic)
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(gapPrompt, "- "+strings.Join(samples, "\n- ")))
+	if ctx.Err() != nil {
+		// 重启后重新处理该聚类
+		return ctx.Err()
+	}
+	if err == nil {
+		err = extractJSON(text, topic)
+	}
+	if err != nil {
+		logger.Logger.Errorw("总结知识缺口主题错误", "err", err, "questions", samples)
+		err = fmt.Errorf("主题%s: %w", samples[0], err)
+	}
+	if topic.Topic == "" {
+		topic.Topic = samples[0]
+	}
+
+	js, _ := json.Marshal(samples)
+	now := time.Now().Unix()
+	gap := &models.KnowledgeGap{
+		JobId:       jobId,
+		Topic:       topic.Topic,
+		Description: topic.Description,
+		Samples:     string(js),
+		Queries:     cluster.queries,
+		Questions:   int64(len(cluster.questions)),
+		Status:      models.GapStatusPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if saveErr := new(models.KnowledgeGap).Create(gap); saveErr != nil {
+		logger.Logger.Errorw("保存知识缺口错误", "err", saveErr, "job_id", jobId)
+		return saveErr
+	}
+	return
+}
+
+func gapItem(gap *models.KnowledgeGap) *GapItem {
+	item := &GapItem{
+		KnowledgeGap: gap,
+		Samples:      make([]string, 0),
+	}
+	_ = json.Unmarshal([]byte(gap.Samples), &item.Samples)
+	return item
+}
+
+// GetList 分页查询知识缺口 同一任务按查询次数从多到少
+func (s *GapService) GetList(ctx context.Context, page, pageSize int, jobId int64, status int32) ([]*GapItem, int64, error) {
+	list, total, err := new(models.KnowledgeGap).GetList(page, pageSize, jobId, status)
+	if err != nil {
+		return nil, 0, err
+	}
+	items := make([]*GapItem, 0, len(list))
+	for _, v := range list {
+		items = append(items, gapItem(v))
+	}
+	return items, total, nil
+}
+
+// Promote 为知识缺口补充答案并保存为问答分组
+func (s *GapService) Promote(ctx context.Context, params *GapPromoteParams) (groupKey string, err error) {
+	if strings.TrimSpace(params.Answer) == "" {
+		err = fmt.Errorf("%w: answer不能为空", ErrInvalidParams)
+		return
+	}
+	gap, err := new(models.KnowledgeGap).GetById(params.Id)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "id", params.Id)
+		return
+	}
+	if gap == nil {
+		err = ErrDataNotFound
+		return
+	}
+	if gap.Status != models.GapStatusPending {
+		err = fmt.Errorf("%w: 知识缺口已处理", ErrInvalidParams)
+		return
+	}
+	questions := trimValues(params.Questions)
+	if len(questions) == 0 {
+		questions = gapItem(gap).Samples
+	}
+
+	// 先标记为已转为问答并分配分组标识 并发转换同一缺口时只有一个成功
+	gapHandler := new(models.KnowledgeGap)
+	claimedKey := new(models.Knowledge).GenGroupKey()
+	ok, err := gapHandler.UpdateByStatus(gap.Id, models.GapStatusPending, map[string]any{
+		"status":    models.GapStatusPromoted,
+		"group_key": claimedKey,
+	})
+	if err != nil {
+		logger.Logger.Errorw("更新知识缺口错误", "err", err, "id", gap.Id)
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("%w: 知识缺口已处理", ErrInvalidParams)
+		return
+	}
+	groupKey, err = Knowledge.SaveQAndA(ctx, &QAndAGroup{
+		GroupKey:  claimedKey,
+		Questions: questions,
+		Answer:    params.Answer,
+		Tags:      params.Tags,
+	})
+	if err != nil {
+		logger.Logger.Errorw("保存问答错误", "err", err, "id", params.Id)
+		// 保存失败恢复为待处理
+		_, releaseErr := gapHandler.UpdateByStatus(gap.Id, models.GapStatusPromoted, map[string]any{
+			"status":    models.GapStatusPending,
+			"group_key": "",
+		})
+		if releaseErr != nil {
+			logger.Logger.Errorw("恢复知识缺口错误", "err", releaseErr, "id", gap.Id)
+		}
+	}
+	return
+}
+
+// Ignore 忽略待处理的知识缺口
+func (s *GapService) Ignore(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return ErrInvalidParams
+	}
+	return new(models.KnowledgeGap).UpdateStatusByIds(ids, models.GapStatusPending, models.GapStatusIgnored)
+}
@@ -0,0 +1,428 @@
+package service
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/* 异步任务 */
+
+const (
+	// 默认任务并发数
+	defaultJobWorkers = 2
+	// 任务最多记录的行错误数
+	maxJobErrors = 1000
+	// 任务行结果文件后缀
+	jobRowsExt = ".rows.jsonl"
+
+	// 任务类型
+	JobTypeImport     = "import"
+	JobTypeImportXlsx = "import_xlsx"
+	JobTypeDocument   = "document"
+	JobTypeVariant    = "variant"
+	JobTypeFaqExtract = "faq_extract"
+	JobTypeEval       = "eval"
+	JobTypeGap        = "gap"
+	JobTypeConflict   = "conflict"
+)
+
+var (
+	Job = newJobService()
+
+	ErrJobNotCancelable = errors.New("job not cancelable")
+	ErrUnknownJobType   = errors.New("unknown job type")
+)
+
+// 任务处理函数 需要根据progress跳过已处理的行以支持重启后继续执行
+type JobHandler func(ctx context.Context, job *models.Job, progress *JobProgress) (result any, err error)
+
+// 异步任务管理 任务状态存储在mysql 由固定数量的worker执行
+type JobService struct {
+	handlers map[string]JobHandler
+	queue    chan int64
+	ctx      context.Context
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	cancels map[int64]context.CancelFunc
+}
+
+// 队列在创建时初始化 Start之前创建的任务在队列中等待 命令行等不启动worker的场景只保存任务
+func newJobService() *JobService {
+	ctx, stop := context.WithCancel(context.Background())
+	return &JobService{
+		handlers: make(map[string]JobHandler),
+		queue:    make(chan int64, 1024),
+		ctx:      ctx,
+		stop:     stop,
+		cancels:  make(map[int64]context.CancelFunc),
+	}
+}
+
+func init() {
+	Job.RegisterHandler(JobTypeImport, Knowledge.importJob)
+	Job.RegisterHandler(JobTypeImportXlsx, Knowledge.importXlsxJob)
+	Job.RegisterHandler(JobTypeDocument, Document.documentJob)
+	Job.RegisterHandler(JobTypeVariant, Knowledge.variantJob)
+	Job.RegisterHandler(JobTypeFaqExtract, Faq.extractJob)
+	Job.RegisterHandler(JobTypeEval, Eval.evalJob)
+	Job.RegisterHandler(JobTypeGap, Gap.gapJob)
+	Job.RegisterHandler(JobTypeConflict, Conflict.conflictJob)
+}
+
+// 注册任务处理函数
+func (s *JobService) RegisterHandler(typ string, handler JobHandler) {
+	s.handlers[typ] = handler
+}
+
+// Start 启动worker 并恢复重启前未完成的任务
+func (s *JobService) Start() {
+	workers := defaultJobWorkers
+	if cfg.Job != nil && cfg.Job.Workers > 0 {
+		workers = cfg.Job.Workers
+	}
+	for range workers {
+		s.wg.Add(1)
+		go s.worker()
+	}
+
+	// 执行中的任务是上次退出时中断的 重新排队从已处理位置继续 已在队列中的任务重复入队时由状态判断跳过
+	jobHandler := new(models.Job)
+	list, err := jobHandler.GetByStatus([]int32{models.JobStatusRunning, models.JobStatusPending})
+	if err != nil {
+		logger.Logger.Errorw("查询未完成任务错误", "err", err)
+		return
+	}
+	for _, v := range list {
+		if v.Status == models.JobStatusRunning {
+			err = jobHandler.UpdateById(v.Id, map[string]any{"status": models.JobStatusPending})
+			if err != nil {
+				logger.Logger.Errorw("恢复任务错误", "err", err, "id", v.Id)
+				continue
+			}
+		}
+		logger.Logger.Infow("恢复任务", "id", v.Id, "type", v.Type, "processed", v.Processed)
+		s.push(v.Id)
+	}
+}
+
+// Stop 停止全部worker 执行中的任务保持执行中状态 下次启动时继续
+func (s *JobService) Stop() {
+	s.stop()
+	s.wg.Wait()
+}
+
+// 任务入队
+func (s *JobService) push(id int64) {
+	go func() {
+		select {
+		case s.queue <- id:
+		case <-s.ctx.Done():
+		}
+	}()
+}
+
+func (s *JobService) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case id := <-s.queue:
+			s.run(id)
+		}
+	}
+}
+
+// 执行任务
+func (s *JobService) run(id int64) {
+	jobHandler := new(models.Job)
+	ok, err := jobHandler.UpdateByStatus(id, []int32{models.JobStatusPending}, map[string]any{"status": models.JobStatusRunning})
+	if err != nil {
+		logger.Logger.Errorw("更新任务状态错误", "err", err, "id", id)
+		return
+	}
+	if !ok {
+		// 已被取消
+		return
+	}
+	job, err := jobHandler.GetById(id)
+	if err != nil || job == nil {
+		logger.Logger.Errorw("查询任务错误", "err", err, "id", id)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.mu.Lock()
+	s.cancels[id] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.cancels, id)
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	progress := newJobProgress(job)
+	var result any
+	handler, ok := s.handlers[job.Type]
+	if ok {
+		result, err = s.safeRun(ctx, handler, job, progress)
+	} else {
+		err = ErrUnknownJobType
+	}
+	if s.ctx.Err() != nil {
+		// 程序退出 保持执行中状态等待恢复
+		progress.save()
+		return
+	}
+
+	data := progress.data()
+	data["finished_at"] = time.Now().Unix()
+	if result != nil {
+		js, _ := json.Marshal(result)
+		data["result"] = string(js)
+	}
+	switch {
+	case err == nil:
+		data["status"] = models.JobStatusSucceeded
+	case ctx.Err() != nil:
+		data["status"] = models.JobStatusCanceled
+		data["message"] = "任务已取消"
+	default:
+		data["status"] = models.JobStatusFailed
+		data["message"] = err.Error()
+		logger.Logger.Errorw("任务执行错误", "err", err, "id", id, "type", job.Type)
+	}
+	_, err = jobHandler.UpdateByStatus(id, []int32{models.JobStatusRunning}, data)
+	if err != nil {
+		logger.Logger.Errorw("更新任务状态错误", "err", err, "id", id)
+	}
+	if job.File != "" {
+		os.Remove(job.File)
+		os.Remove(jobRowsPath(job))
+	}
+}
+
+// 执行任务处理函数 防止panic导致worker退出
+func (s *JobService) safeRun(ctx context.Context, handler JobHandler, job *models.Job, progress *JobProgress) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panic: %v", r)
+		}
+	}()
+	return handler(ctx, job, progress)
+}
+
+// Enqueue 创建任务 file为需要随任务保存的上传内容 可为nil
+func (s *JobService) Enqueue(typ string, params any, file io.Reader, ext string) (*models.Job, error) {
+	if _, ok := s.handlers[typ]; !ok {
+		return nil, ErrUnknownJobType
+	}
+	js, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.Job{
+		Type:   typ,
+		Status: models.JobStatusPending,
+		Params: string(js),
+	}
+	if file != nil {
+		job.File, err = saveJobFile(file, ext)
+		if err != nil {
+			logger.Logger.Errorw("保存任务文件错误", "err", err, "type", typ)
+			return nil, err
+		}
+	}
+	err = new(models.Job).Create(job)
+	if err != nil {
+		logger.Logger.Errorw("创建任务错误", "err", err, "type", typ)
+		return nil, err
+	}
+	s.push(job.Id)
+	return job, nil
+}
+
+// 保存任务文件
+func saveJobFile(r io.Reader, ext string) (string, error) {
+	dir := filepath.Join(common.GetRootDir(), common.JobDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, uuid.NewString()+ext)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, r); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// 任务行结果文件 用于重启后继续汇总结果
+func jobRowsPath(job *models.Job) string {
+	return job.File + jobRowsExt
+}
+
+// Cancel 取消等待中或执行中的任务
+func (s *JobService) Cancel(ctx context.Context, id int64) error {
+	ok, err := new(models.Job).UpdateByStatus(id, []int32{models.JobStatusPending}, map[string]any{
+		"status":      models.JobStatusCanceled,
+		"message":     "任务已取消",
+		"finished_at": time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	s.mu.Lock()
+	cancel, ok := s.cancels[id]
+	s.mu.Unlock()
+	if !ok {
+		return ErrJobNotCancelable
+	}
+	cancel()
+	return nil
+}
+
+// 任务详情
+type JobView struct {
+	*models.Job
+	Progress float64         `json:"progress"` // 进度百分比
+	Errors   []*RowError     `json:"errors"`
+	Result   json.RawMessage `json:"result,omitempty"`
+}
+
+func newJobView(job *models.Job) *JobView {
+	view := &JobView{
+		Job:    job,
+		Errors: make([]*RowError, 0),
+	}
+	if job.Total > 0 {
+		view.Progress = float64(job.Processed) * 100 / float64(job.Total)
+	}
+	if job.Errors != "" {
+		json.Unmarshal([]byte(job.Errors), &view.Errors)
+	}
+	if job.Result != "" {
+		view.Result = json.RawMessage(job.Result)
+	}
+	return view
+}
+
+// 获取任务
+func (s *JobService) GetJob(ctx context.Context, id int64) (*JobView, error) {
+	job, err := new(models.Job).GetById(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrDataNotFound
+	}
+	return newJobView(job), nil
+}
+
+// 分页查询
+func (s *JobService) GetList(ctx context.Context, page, pageSize int, typ string, status int32) (list []*JobView, total int64, err error) {
+	jobs, total, err := new(models.Job).GetList(page, pageSize, typ, status)
+	if err != nil {
+		return
+	}
+	list = make([]*JobView, 0, len(jobs))
+	for _, v := range jobs {
+		// 列表不返回行错误明细
+		v.Errors = ""
+		list = append(list, newJobView(v))
+	}
+	return
+}
+
+// 任务进度 每处理一行持久化一次
+type JobProgress struct {
+	job    *models.Job
+	errors []*RowError
+	index  int64
+}
+
+func newJobProgress(job *models.Job) *JobProgress {
+	p := &JobProgress{
+		job:    job,
+		errors: make([]*RowError, 0),
+	}
+	if job.Errors != "" {
+		json.Unmarshal([]byte(job.Errors), &p.errors)
+	}
+	return p
+}
+
+// SetTotal 设置总行数
+func (p *JobProgress) SetTotal(total int64) {
+	p.job.Total = total
+	p.save()
+}
+
+// Next 进入下一行 返回false表示该行在重启前已处理需跳过
+func (p *JobProgress) Next() bool {
+	p.index++
+	return p.index > p.job.Processed
+}
+
+// GroupKey 当前行的分组标识 由任务id和行序号生成 重启后重新处理同一行时整组替换而不是重复创建
+func (p *JobProgress) GroupKey() string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, fmt.Appendf(nil, "job:%d:%d", p.job.Id, p.index)).String()
+}
+
+// Done 记录当前行处理结果
+func (p *JobProgress) Done(line int, err error) {
+	p.job.Processed = p.index
+	if err != nil {
+		p.job.Failed++
+		if len(p.errors) < maxJobErrors {
+			p.errors = append(p.errors, &RowError{
+				Line:  line,
+				Error: err.Error(),
+			})
+		}
+	} else {
+		p.job.Succeeded++
+	}
+	p.save()
+}
+
+// 持久化进度
+func (p *JobProgress) save() {
+	err := new(models.Job).UpdateById(p.job.Id, p.data())
+	if err != nil {
+		logger.Logger.Errorw("保存任务进度错误", "err", err, "id", p.job.Id)
+	}
+}
+
+func (p *JobProgress) data() map[string]any {
+	js, _ := json.Marshal(p.errors)
+	return map[string]any{
+		"total":     p.job.Total,
+		"processed": p.job.Processed,
+		"succeeded": p.job.Succeeded,
+		"failed":    p.job.Failed,
+		"errors":    string(js),
+	}
+}
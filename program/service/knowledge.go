@@ -2,15 +2,13 @@ package service
 
 import (
 	"ai-knowledge/internal/embedding"
-	"ai-knowledge/internal/llm"
 	"ai-knowledge/internal/logger"
 	"ai-knowledge/internal/milvus"
 	"ai-knowledge/program/models"
 	"context"
 	"errors"
 	"fmt"
-
-	"github.com/tmc/langchaingo/schema"
+	"strings"
 )
 
 var (
@@ -21,57 +19,104 @@ var (
 type KnowledgeService struct {
 }
 
+// 问答分组
+type QAndAGroup struct {
+	GroupKey  string   `json:"group_key"` // 分组标识 为空时自动生成 已存在时整组替换
+	Questions []string `json:"questions"`
+	Answer    string   `json:"answer"`
+	Tags      []string `json:"tags"`
+	// 大于0时为每个问题生成的变体数
+	GenVariants int `json:"gen_variants"`
+	// 导入时与问题一一对应的问题来源
+	Generated []int32 `json:"-"`
+}
+
 // 保存问答知识
-func (s *KnowledgeService) SaveQAndA(ctx context.Context, questions []string, answer string) (err error) {
-	if len(questions) == 0 || answer == "" {
+func (s *KnowledgeService) SaveQAndA(ctx context.Context, group *QAndAGroup) (groupKey string, err error) {
+	if group == nil || len(group.Questions) == 0 || group.Answer == "" {
 		err = ErrInvalidParams
 		return
 	}
+	questions, answer := group.Questions, group.Answer
 	// 处理问题为向量
 	vectors, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, questions)
 	if err != nil {
 		logger.Logger.Errorw("处理问题为向量错误", "err", err, "questions", questions, "answer", answer)
-		return err
+		return
 	}
 
 	if len(vectors) != len(questions) {
-		return errors.New("向量数与问题数不一致")
+		err = errors.New("向量数与问题数不一致")
+		return
+	}
+
+	groupKey = group.GroupKey
+	oldList := make([]*models.Knowledge, 0)
+	if groupKey == "" {
+		groupKey = new(models.Knowledge).GenGroupKey()
+	} else {
+		oldList, err = new(models.Knowledge).GetByGroupKey(groupKey)
+		if err != nil {
+			logger.Logger.Errorw("查询db错误", "err", err, "group_key", groupKey)
+			return
+		}
+		if err = checkReplaceGroup(oldList, models.KnowledgeTypeQAndA); err != nil {
+			return
+		}
 	}
 
 	// 写入向量数据库
 	ids, err := milvus.MilvusHandler.Insert(ctx, questions, vectors)
 	if err != nil {
 		logger.Logger.Errorw("写入向量数据库错误", "err", err, "questions", questions, "answer", answer, "vectors", vectors)
-		return err
+		return
 	}
 	if len(ids) != len(questions) {
-		return errors.New("向量插入数与问题数不一致")
+		err = errors.New("向量插入数与问题数不一致")
+		return
 	}
 
-	groupKey := new(models.Knowledge).GenGroupKey()
-
 	// 写入db
 	knowledges := make([]*models.Knowledge, 0)
 	for i, question := range questions {
-		knowledges = append(knowledges, &models.Knowledge{
+		knowledge := &models.Knowledge{
 			Question: question,
 			Answer:   answer,
 			VectorId: ids[i],
 			Type:     models.KnowledgeTypeQAndA,
 			GroupKey: groupKey,
-		})
+			Tags:     strings.Join(group.Tags, ","),
+		}
+		if i < len(group.Generated) {
+			knowledge.Generated = group.Generated[i]
+		}
+		knowledges = append(knowledges, knowledge)
 	}
 	err = new(models.Knowledge).BatchCreate(knowledges)
 	if err != nil {
 		logger.Logger.Errorw("写入db错误", "err", err, "knowledges", knowledges)
-		return err
+		return
+	}
+
+	// 替换分组内旧数据
+	err = s.delKnowledges(ctx, oldList)
+	if err != nil {
+		return
 	}
+	Conflict.Schedule(groupKey)
 
+	// 生成问题变体 失败不影响本次保存
+	if group.GenVariants > 0 {
+		if _, err := s.GenVariants(ctx, groupKey, group.GenVariants); err != nil {
+			logger.Logger.Errorw("生成问题变体错误", "err", err, "group_key", groupKey)
+		}
+	}
 	return
 }
 
-// 更新保存问答知识
-func (s *KnowledgeService) UpQAndA(ctx context.Context, ids []int64, questions []string, answer string) (err error) {
+// 更新保存问答知识 ids为知识id
+// genVariants大于0时重新生成分组内的问题变体
+func (s *KnowledgeService) UpQAndA(ctx context.Context, ids []int64, questions []string, answer string, genVariants int) (err error) {
 	if len(ids) == 0 || len(questions) == 0 || answer == "" {
 		err = ErrInvalidParams
 		return
@@ -87,22 +132,14 @@ func (s *KnowledgeService) UpQAndA(ctx context.Context, ids []int64, questions [
 		return errors.New("向量数与问题数不一致")
 	}
 
-	// 根据id查询数据
-	oldList, err := new(models.Knowledge).BatchGetByIds(ids)
+	oldList, oldVectorIds, err := s.getForUpdate(ids)
 	if err != nil {
-		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
 		return err
 	}
-	if len(oldList) == 0 {
-		err = ErrDataNotFound
-		return
-	}
-	if len(oldList) != len(ids) {
-		return errors.New("查询数据与id数不一致")
-	}
+	groupKeys := knowledgeGroupKeys(oldList)
 
 	// 写入向量数据库
-	vectorIds, err := milvus.MilvusHandler.Update(ctx, ids, questions, vectors)
+	vectorIds, err := milvus.MilvusHandler.Update(ctx, oldVectorIds, questions, vectors)
 	if err != nil {
 		logger.Logger.Errorw("写入向量数据库错误", "err", err, "ids", ids, "questions", questions, "answer", answer, "vectors", vectors)
 		return err
@@ -124,111 +161,73 @@ func (s *KnowledgeService) UpQAndA(ctx context.Context, ids []int64, questions [
 			return err
 		}
 	}
+	AnswerCache.Invalidate(oldList)
+	Conflict.Schedule(groupKeys...)
 
-	return
-}
-
-type SearchKnowledge struct {
-	*models.Knowledge
-	Score float32
-}
-
-// 搜索知识
-func (s *KnowledgeService) Search(ctx context.Context, question string, topK int) (answer any, knowledges []*SearchKnowledge, err error) {
-	vector, err := embedding.TextEmbeddingHandler.CalculateEmbedding(ctx, question)
-	if err != nil {
-		logger.Logger.Errorw("处理问题为向量错误", "err", err, "question", question)
-		return
-	}
-	if len(vector) == 0 {
-		err = ErrVectorTransform
-		return
-	}
-	results, err := milvus.MilvusHandler.Search(ctx, vector, topK)
-	if err != nil {
-		logger.Logger.Errorw("搜索向量数据库错误", "err", err, "question", question, "vector", vector, "top_k", topK)
-		return
-	}
-	if len(results) == 0 {
-		return
-	}
-	ids := make([]int64, 0)
-	for _, result := range results {
-		ids = append(ids, result.Id)
-	}
-	list, err := new(models.Knowledge).BatchGetByIds(ids)
-	if err != nil {
-		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
-		return
-	}
-	// 整理数据
-	for _, v := range list {
-		score := float32(0)
-		for _, vv := range results {
-			if vv.Id == v.VectorId {
-				score = vv.Score
-				break
+	// 重新生成问题变体 失败不影响本次修改
+	if genVariants > 0 {
+		for _, groupKey := range groupKeys {
+			if _, err := s.RegenVariants(ctx, groupKey, genVariants); err != nil {
+				logger.Logger.Errorw("生成问题变体错误", "err", err, "group_key", groupKey)
 			}
 		}
-		knowledges = append(knowledges, &SearchKnowledge{
-			Knowledge: v,
-			Score:     score,
-		})
-	}
-	// 调用大模型
-	documents := make([]schema.Document, 0)
-	for _, v := range knowledges {
-		switch v.Type {
-		case models.KnowledgeTypePure:
-			documents = append(documents, schema.Document{
-				PageContent: v.Text,
-				Score:       v.Score,
-			})
-		case models.KnowledgeTypeQAndA:
-			documents = append(documents, schema.Document{
-				PageContent: fmt.Sprintf("问：%s\n答：%s", v.Question, v.Answer),
-				Score:       v.Score,
-			})
-		}
-	}
-	answer, err = llm.LLMHandler.LoadStuffQA(ctx, question, documents)
-	if err != nil {
-		logger.Logger.Errorw("调用大模型错误", "err", err, "question", question, "documents", documents)
-		return
 	}
 
 	return
 }
 
+// 纯知识分组
+type KnowledgeGroup struct {
+	GroupKey string   `json:"group_key"` // 分组标识 为空时自动生成 已存在时整组替换
+	Texts    []string `json:"texts"`
+	Tags     []string `json:"tags"`
+}
+
 // 保存知识
-func (s *KnowledgeService) SaveKnowledge(ctx context.Context, texts []string) (err error) {
-	if len(texts) == 0 {
+func (s *KnowledgeService) SaveKnowledge(ctx context.Context, group *KnowledgeGroup) (groupKey string, err error) {
+	if group == nil || len(group.Texts) == 0 {
 		err = ErrInvalidParams
 		return
 	}
+	texts := group.Texts
 	// 处理问题为向量
 	vectors, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, texts)
 	if err != nil {
 		logger.Logger.Errorw("处理问题为向量错误", "err", err, "texts", texts)
-		return err
+		return
 	}
 
 	if len(vectors) != len(texts) {
-		return errors.New("向量数与问题数不一致")
+		err = errors.New("向量数与问题数不一致")
+		return
+	}
+
+	groupKey = group.GroupKey
+	oldList := make([]*models.Knowledge, 0)
+	if groupKey == "" {
+		groupKey = new(models.Knowledge).GenGroupKey()
+	} else {
+		oldList, err = new(models.Knowledge).GetByGroupKey(groupKey)
+		if err != nil {
+			logger.Logger.Errorw("查询db错误", "err", err, "group_key", groupKey)
+			return
+		}
+		if err = checkReplaceGroup(oldList, models.KnowledgeTypePure); err != nil {
+			return
+		}
 	}
 
 	// 写入向量数据库
 	ids, err := milvus.MilvusHandler.Insert(ctx, texts, vectors)
 	if err != nil {
 		logger.Logger.Errorw("写入向量数据库错误", "err", err, "texts", texts, "vectors", vectors)
-		return err
+		return
 	}
 	if len(ids) != len(texts) {
-		return errors.New("向量插入数与问题数不一致")
+		err = errors.New("向量插入数与问题数不一致")
+		return
 	}
 
-	groupKey := new(models.Knowledge).GenGroupKey()
-
 	// 写入db
 	knowledges := make([]*models.Knowledge, 0)
 	for i, text := range texts {
@@ -237,18 +236,25 @@ func (s *KnowledgeService) SaveKnowledge(ctx context.Context, texts []string) (e
 			VectorId: ids[i],
 			Type:     models.KnowledgeTypePure,
 			GroupKey: groupKey,
+			Tags:     strings.Join(group.Tags, ","),
 		})
 	}
 	err = new(models.Knowledge).BatchCreate(knowledges)
 	if err != nil {
 		logger.Logger.Errorw("写入db错误", "err", err, "knowledges", knowledges)
-		return err
+		return
 	}
 
+	// 替换分组内旧数据
+	err = s.delKnowledges(ctx, oldList)
+	if err != nil {
+		return
+	}
+	Conflict.Schedule(groupKey)
 	return
 }
 
-// 保存知识
+// 更新保存纯知识 ids为知识id
 func (s *KnowledgeService) UpKnowledge(ctx context.Context, ids []int64, texts []string) (err error) {
 	if len(ids) == 0 || len(texts) == 0 {
 		err = ErrInvalidParams
@@ -265,27 +271,18 @@ func (s *KnowledgeService) UpKnowledge(ctx context.Context, ids []int64, texts [
 		return errors.New("向量数与问题数不一致")
 	}
 
-	// 根据id查询数据
-	oldList, err := new(models.Knowledge).BatchGetByIds(ids)
+	oldList, oldVectorIds, err := s.getForUpdate(ids)
 	if err != nil {
-		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
 		return err
 	}
-	if len(oldList) == 0 {
-		err = ErrDataNotFound
-		return
-	}
-	if len(oldList) != len(ids) {
-		return errors.New("查询数据与id数不一致")
-	}
 
 	// 写入向量数据库
-	vectorIds, err := milvus.MilvusHandler.Update(ctx, ids, texts, vectors)
+	vectorIds, err := milvus.MilvusHandler.Update(ctx, oldVectorIds, texts, vectors)
 	if err != nil {
-		logger.Logger.Errorw("写入向量数据库错误", "err", err, "texts", texts, "vectors", vectors)
+		logger.Logger.Errorw("写入向量数据库错误", "err", err, "ids", ids, "texts", texts, "vectors", vectors)
 		return err
 	}
-	if len(ids) != len(texts) {
+	if len(vectorIds) != len(texts) {
 		return errors.New("向量插入数与问题数不一致")
 	}
 
@@ -301,13 +298,40 @@ func (s *KnowledgeService) UpKnowledge(ctx context.Context, ids []int64, texts [
 			return err
 		}
 	}
+	AnswerCache.Invalidate(oldList)
+	Conflict.Schedule(knowledgeGroupKeys(oldList)...)
 
 	return
 }
 
+// 根据知识id查询待修改的数据及对应向量id 文档切片只能通过文档修改
+func (s *KnowledgeService) getForUpdate(ids []int64) (list []*models.Knowledge, vectorIds []int64, err error) {
+	list, err = new(models.Knowledge).BatchGetByPks(ids)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
+		return
+	}
+	if len(list) == 0 {
+		err = ErrDataNotFound
+		return
+	}
+	if len(list) != len(ids) {
+		err = errors.New("查询数据与id数不一致")
+		return
+	}
+	if err = checkNotChunks(list); err != nil {
+		return
+	}
+	vectorIds = make([]int64, 0, len(list))
+	for _, v := range list {
+		vectorIds = append(vectorIds, v.VectorId)
+	}
+	return
+}
+
 // 分页查询
-func (s *KnowledgeService) GetList(ctx context.Context, page, pageSize int, typ int32) (list []*models.Knowledge, total int64, err error) {
-	return new(models.Knowledge).GetList(page, pageSize, typ)
+func (s *KnowledgeService) GetList(ctx context.Context, page, pageSize int, typ int32, generated int32) (list []*models.Knowledge, total int64, err error) {
+	return new(models.Knowledge).GetList(page, pageSize, typ, generated)
 }
 
 // 获取组内全部数据
@@ -322,13 +346,54 @@ func (s *KnowledgeService) DelByIds(ctx context.Context, ids []int64) (err error
 		return
 	}
 	// 查询出数据
-	list, err := new(models.Knowledge).BatchGetByIds(ids)
+	list, err := new(models.Knowledge).BatchGetByPks(ids)
 	if err != nil {
 		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
 		return err
 	}
+	if err = checkNotChunks(list); err != nil {
+		return err
+	}
+	err = s.delKnowledges(ctx, list)
+	if err != nil {
+		return err
+	}
+	Conflict.Schedule(knowledgeGroupKeys(list)...)
+	return
+}
+
+// 按分组标识整组替换时 只能替换同类型的非文档分组
+func checkReplaceGroup(oldList []*models.Knowledge, typ int32) error {
+	if err := checkNotChunks(oldList); err != nil {
+		return err
+	}
+	for _, v := range oldList {
+		if v.Type != typ {
+			return fmt.Errorf("%w: 分组%s已存在且类型为%d", ErrInvalidParams, v.GroupKey, v.Type)
+		}
+	}
+	return nil
+}
+
+// 文档切片由文档管理 切片数及校验和记录在文档上 不能单独修改或删除
+func checkNotChunks(list []*models.Knowledge) error {
+	for _, v := range list {
+		if v.DocumentId > 0 {
+			return fmt.Errorf("%w: 知识%d为文档%d的切片 请通过文档接口修改", ErrInvalidParams, v.Id, v.DocumentId)
+		}
+	}
+	return nil
+}
+
+// 删除知识及对应向量
+func (s *KnowledgeService) delKnowledges(ctx context.Context, list []*models.Knowledge) (err error) {
+	if len(list) == 0 {
+		return
+	}
+	ids := make([]int64, 0)
 	vectorIds := make([]int64, 0)
 	for _, v := range list {
+		ids = append(ids, v.Id)
 		vectorIds = append(vectorIds, v.VectorId)
 	}
 
@@ -344,6 +409,7 @@ func (s *KnowledgeService) DelByIds(ctx context.Context, ids []int64) (err error
 		logger.Logger.Errorw("删除db错误", "err", err, "ids", ids)
 		return err
 	}
+	AnswerCache.Invalidate(list)
 
 	return
 }
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/program/models"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+/* 回答提示词模板 */
+
+const (
+	defaultSystemPrompt = `你是一个专业的知识库问答助手，请使用简体中文回答用户问题。`
+
+	defaultDocumentPrompt = `[{{.Index}}] {{if .IsQAndA}}问：{{.Question}}{{range .Questions}}
+问：{{.}}{{end}}
+答：{{.Answer}}{{else}}{{if .Title}}《{{.Title}}》{{.Section}}
+{{end}}{{.Text}}{{end}}`
+
+	defaultQuestionPrompt = `已知信息：
+{{.Context}}
+
+请根据上述已知信息简洁、专业地回答问题。如果无法从中得到答案，请说“根据已知信息无法回答该问题”，不允许在答案中添加编造成分。
+回答引用已知信息时，请在对应句子末尾用方括号标注信息编号，例如[1]或[1][2]。
+问题：{{.Question}}`
+)
+
+var (
+	Prompt = new(PromptService)
+)
+
+// 提示词模板
+type PromptService struct {
+}
+
+// 提示词模板 为空的项依次使用知识库配置、全局配置、默认模板
+type PromptTemplate struct {
+	System   string `json:"system"`
+	Document string `json:"document"`
+	Question string `json:"question"`
+}
+
+// 单条知识模板数据
+type PromptDocument struct {
+	Index     int      `json:"index"` // 从1开始的序号
+	IsQAndA   bool     `json:"is_q_and_a"`
+	Question  string   `json:"question"`
+	Questions []string `json:"questions"` // 同一问答分组中其他命中的问题
+	Answer    string   `json:"answer"`
+	Text      string   `json:"text"`
+	Title     string   `json:"title"` // 来源文档标题
+	Section   string   `json:"section"`
+	Tags      []string `json:"tags"`
+	Score     float32  `json:"score"`
+}
+
+// 问题模板数据
+type promptQuestion struct {
+	Question  string
+	Context   string   // 全部知识按顺序拼接
+	Documents []string // 每条知识渲染结果
+}
+
+// 渲染结果
+type RenderedPrompt struct {
+	System    string   `json:"system"`
+	Documents []string `json:"documents"`
+	User      string   `json:"user"`
+}
+
+// 解析后的模板
+type promptTemplates struct {
+	system   *template.Template
+	document *template.Template
+	question *template.Template
+}
+
+// 查询知识库配置 名称为空时返回nil
+func getKnowledgeBase(name string) (*config.KnowledgeBaseConfig, error) {
+	if name == "" {
+		return nil, nil
+	}
+	kb, ok := cfg.KnowledgeBases[name]
+	if !ok || kb == nil {
+		return nil, fmt.Errorf("%w: 知识库%s不存在", ErrInvalidParams, name)
+	}
+	return kb, nil
+}
+
+// 按请求、知识库、全局配置、默认模板的顺序选择非空模板
+func pickPrompt(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Resolve 合并模板配置并解析
+func (s *PromptService) Resolve(knowledgeBase string, override *PromptTemplate) (*promptTemplates, error) {
+	kb, err := getKnowledgeBase(knowledgeBase)
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]*config.PromptConfig, 0, 3)
+	if override != nil {
+		layers = append(layers, &config.PromptConfig{
+			System:   override.System,
+			Document: override.Document,
+			Question: override.Question,
+		})
+	}
+	if kb != nil && kb.Prompt != nil {
+		layers = append(layers, kb.Prompt)
+	}
+	if cfg.Prompt != nil {
+		layers = append(layers, cfg.Prompt)
+	}
+	systems, documents, questions := make([]string, 0), make([]string, 0), make([]string, 0)
+	for _, v := range layers {
+		systems = append(systems, v.System)
+		documents = append(documents, v.Document)
+		questions = append(questions, v.Question)
+	}
+	systems = append(systems, defaultSystemPrompt)
+	documents = append(documents, defaultDocumentPrompt)
+	questions = append(questions, defaultQuestionPrompt)
+
+	t := new(promptTemplates)
+	if t.system, err = parsePrompt("system", pickPrompt(systems...)); err != nil {
+		return nil, err
+	}
+	if t.document, err = parsePrompt("document", pickPrompt(documents...)); err != nil {
+		return nil, err
+	}
+	if t.question, err = parsePrompt("question", pickPrompt(questions...)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// 解析模板 引用不存在的字段时渲染报错
+func parsePrompt(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s模板错误: %w", ErrInvalidParams, name, err)
+	}
+	return t, nil
+}
+
+func executePrompt(t *template.Template, data any) (string, error) {
+	buf := new(strings.Builder)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("%w: %s模板渲染错误: %w", ErrInvalidParams, t.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// Render 渲染系统提示词和用户消息
+func (t *promptTemplates) Render(question string, documents []*PromptDocument) (*RenderedPrompt, error) {
+	texts, err := t.RenderDocuments(documents)
+	if err != nil {
+		return nil, err
+	}
+	return t.RenderQuestion(question, texts)
+}
+
+// RenderDocuments 按单条知识模板渲染
+func (t *promptTemplates) RenderDocuments(documents []*PromptDocument) ([]string, error) {
+	texts := make([]string, 0, len(documents))
+	for _, v := range documents {
+		text, err := executePrompt(t.document, v)
+		if err != nil {
+			return nil, err
+		}
+		texts = append(texts, text)
+	}
+	return texts, nil
+}
+
+// RenderQuestion 使用已渲染的知识渲染系统提示词和用户消息
+func (t *promptTemplates) RenderQuestion(question string, documents []string) (*RenderedPrompt, error) {
+	rendered := &RenderedPrompt{
+		Documents: documents,
+	}
+	data := &promptQuestion{
+		Question:  question,
+		Context:   strings.Join(documents, "\n\n"),
+		Documents: documents,
+	}
+	var err error
+	if rendered.System, err = executePrompt(t.system, data); err != nil {
+		return nil, err
+	}
+	if rendered.User, err = executePrompt(t.question, data); err != nil {
+		return nil, err
+	}
+	return rendered, nil
+}
+
+// 检索结果转换为模板数据
+func newPromptDocuments(knowledges []*SearchKnowledge) []*PromptDocument {
+	documents := make([]*PromptDocument, 0, len(knowledges))
+	for i, v := range knowledges {
+		document := &PromptDocument{
+			Index:    i + 1,
+			IsQAndA:  v.Type == models.KnowledgeTypeQAndA,
+			Question: v.Question,
+			Answer:   v.Answer,
+			Text:     v.Text,
+			Section:  v.Section,
+			Tags:     make([]string, 0),
+			Score:    v.Score,
+		}
+		for _, q := range v.MatchedQuestions {
+			document.Questions = append(document.Questions, q.Question)
+		}
+		if v.Tags != "" {
+			document.Tags = strings.Split(v.Tags, ",")
+		}
+		if v.Document != nil {
+			document.Title = v.Document.Title
+		}
+		documents = append(documents, document)
+	}
+	return documents
+}
+
+// Validate 使用示例知识渲染模板 用于保存配置前检查模板
+func (s *PromptService) Validate(ctx context.Context, knowledgeBase string, override *PromptTemplate, question string, documents []*PromptDocument) (*RenderedPrompt, error) {
+	t, err := s.Resolve(knowledgeBase, override)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range documents {
+		if v.Index == 0 {
+			v.Index = i + 1
+		}
+	}
+	return t.Render(question, documents)
+}
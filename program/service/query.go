@@ -0,0 +1,161 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+/* 检索前的问题处理 */
+
+const (
+	// 问题处理策略
+	QueryOriginal   = "original"    // 原问题 始终参与检索
+	QueryRewrite    = "rewrite"     // 改写为规范的书面问题
+	QueryMultiQuery = "multi_query" // 从不同角度生成多个问题
+	QueryHyDE       = "hyde"        // 生成假设答案 使用答案检索
+
+	// 默认多问题扩展数量
+	defaultSubQueries = 3
+	// 多问题扩展数量上限
+	maxSubQueries = 10
+
+	rewritePrompt = `请把用户的问题改写为简洁、规范的书面问题，便于在知识库中检索。
+要求：
+1. 补全口语中省略的信息，去掉语气词和无关内容
+2. 不要改变问题的意思，不要回答问题
+3. 只输出改写后的问题
+问题：%s`
+
+	multiQueryPrompt = `请从不同角度把用户的问题改写为%d个意思相同、表述不同的问题，便于在知识库中检索。
+每行一个问题，不要编号，只输出问题。
+问题：%s`
+
+	hydePrompt = `请针对下面的问题写一段简短的回答，像知识库中的标准答案一样，不超过200字。不确定时也给出最可能的回答，只输出回答内容。
+问题：%s`
+)
+
+var (
+	queryStrategies = []string{QueryRewrite, QueryMultiQuery, QueryHyDE}
+	// 行首编号 1. 1、 (1) - *
+	queryNumberRegexp = regexp.MustCompile(`^\s*(?:\d+[.、)）]|[(（]\d+[)）]|[-*•])\s*`)
+)
+
+// 检索使用的问题及检索效果
+type SearchQuery struct {
+	Strategy  string  `json:"strategy"`
+	Text      string  `json:"text"`
+	BestScore float32 `json:"best_score"` // 最近的L2距离 没有结果时为0
+	Hits      int     `json:"hits"`       // 检索到的条数
+	NewHits   int     `json:"new_hits"`   // 原问题未检索到的条数
+
+	vector []float32 // 已计算的向量
+}
+
+// 选择问题处理策略 请求优先 请求传入空列表时不处理
+func resolveStrategies(strategies []string, search *config.SearchConfig) ([]string, error) {
+	if strategies == nil {
+		strategies = search.Strategies
+	}
+	resolved := make([]string, 0, len(strategies))
+	for _, v := range strategies {
+		if !slices.Contains(queryStrategies, v) {
+			return nil, fmt.Errorf("%w: 不支持的问题处理策略%s", ErrInvalidParams, v)
+		}
+		if !slices.Contains(resolved, v) {
+			resolved = append(resolved, v)
+		}
+	}
+	return resolved, nil
+}
+
+// 按策略生成检索问题 第一个为原问题 生成失败的策略跳过
+func (s *KnowledgeService) expandQueries(ctx context.Context, question string, strategies []string, search *config.SearchConfig) []*SearchQuery {
+	expanded := make([][]*SearchQuery, len(strategies))
+	_ = mapConcurrent(ctx, len(strategies), func(ctx context.Context, i int) error {
+		var err error
+		switch strategies[i] {
+		case QueryRewrite:
+			expanded[i], err = s.rewriteQuery(ctx, question)
+		case QueryMultiQuery:
+			expanded[i], err = s.multiQuery(ctx, question, search.SubQueries)
+		case QueryHyDE:
+			expanded[i], err = s.hydeQuery(ctx, question)
+		}
+		return err
+	})
+
+	queries := []*SearchQuery{{Strategy: QueryOriginal, Text: question}}
+	texts := []string{question}
+	for _, list := range expanded {
+		for _, v := range list {
+			if v.Text == "" || slices.Contains(texts, v.Text) {
+				continue
+			}
+			texts = append(texts, v.Text)
+			queries = append(queries, v)
+		}
+	}
+	return queries
+}
+
+// 改写问题
+func (s *KnowledgeService) rewriteQuery(ctx context.Context, question string) ([]*SearchQuery, error) {
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(rewritePrompt, question), llms.WithTemperature(0))
+	if err != nil {
+		return nil, err
+	}
+	return []*SearchQuery{{Strategy: QueryRewrite, Text: strings.TrimSpace(text)}}, nil
+}
+
+// 多问题扩展
+func (s *KnowledgeService) multiQuery(ctx context.Context, question string, n int) ([]*SearchQuery, error) {
+	if n <= 0 {
+		n = defaultSubQueries
+	}
+	n = min(n, maxSubQueries)
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(multiQueryPrompt, n, question))
+	if err != nil {
+		return nil, err
+	}
+	queries := make([]*SearchQuery, 0, n)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(queryNumberRegexp.ReplaceAllString(line, ""))
+		if line == "" {
+			continue
+		}
+		queries = append(queries, &SearchQuery{Strategy: QueryMultiQuery, Text: line})
+		if len(queries) >= n {
+			break
+		}
+	}
+	return queries, nil
+}
+
+// 生成假设答案
+func (s *KnowledgeService) hydeQuery(ctx context.Context, question string) ([]*SearchQuery, error) {
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(hydePrompt, question))
+	if err != nil {
+		return nil, err
+	}
+	return []*SearchQuery{{Strategy: QueryHyDE, Text: strings.TrimSpace(text)}}, nil
+}
+
+// 记录各问题的检索效果 best为合并后的最近距离
+func logQueryEffect(question string, queries []*SearchQuery, best float32) {
+	if len(queries) <= 1 {
+		return
+	}
+	effects := make([]string, 0, len(queries)-1)
+	for _, v := range queries[1:] {
+		effects = append(effects, fmt.Sprintf("%s[%s] best=%g hits=%d new=%d", v.Strategy, v.Text, v.BestScore, v.Hits, v.NewHits))
+	}
+	logger.Logger.Infow("问题处理检索效果", "question", question, "original_best", queries[0].BestScore, "best", best, "queries", effects)
+}
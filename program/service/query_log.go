@@ -0,0 +1,236 @@
+package service
+
+import (
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* 问答查询记录及统计 */
+
+const (
+	// 统计列表默认及最大条数
+	defaultStatLimit = 20
+	maxStatLimit     = 500
+)
+
+var (
+	QueryLog = new(QueryLogService)
+
+	// 耗时统计的百分位
+	latencyPercents = []float64{0.5, 0.9, 0.95, 0.99}
+)
+
+// 问答查询记录
+type QueryLogService struct {
+}
+
+// 查询记录参数
+type QueryLogMeta struct {
+	Question string
+	Channel  string // 调用渠道
+}
+
+// 知识命中统计
+type KnowledgeHitItem struct {
+	*models.KnowledgeHitStat
+	HitRate   float64           `json:"hit_rate"` // 被检索到的次数/查询次数
+	Knowledge *models.Knowledge `json:"knowledge"`
+}
+
+// 耗时统计(毫秒)
+type LatencyStats struct {
+	Total int64   `json:"total"` // 未出错的查询次数
+	Avg   float64 `json:"avg"`
+	P50   int64   `json:"p50"`
+	P90   int64   `json:"p90"`
+	P95   int64   `json:"p95"`
+	P99   int64   `json:"p99"`
+}
+
+// 是否兜底回答 未检索到知识、模型无法回答或出错
+func isFallback(result *SearchResult, err error) bool {
+	return err != nil || result.Answer == "" || strings.Contains(result.Answer, cannotAnswer)
+}
+
+// Record 保存一次问答查询及检索到的知识 出错的查询同样保存 返回记录id 保存失败时返回0
+func (s *QueryLogService) Record(ctx context.Context, meta *QueryLogMeta, result *SearchResult, searchErr error) int64 {
+	if result == nil {
+		return 0
+	}
+	now := time.Now().Unix()
+	ids := make([]string, 0, len(result.Knowledges))
+	hits := make([]*models.QueryLogHit, 0, len(result.Knowledges))
+	for i, v := range result.Knowledges {
+		ids = append(ids, strconv.FormatInt(v.Id, 10))
+		hits = append(hits, &models.QueryLogHit{
+			KnowledgeId: v.Id,
+			Position:    int32(i + 1),
+			Similarity:  v.Similarity,
+			CreatedAt:   now,
+		})
+		// 问答按分组汇总时 组内其他命中的问题同样记为检索到 位置与分组相同
+		for _, q := range v.MatchedQuestions {
+			hits = append(hits, &models.QueryLogHit{
+				KnowledgeId: q.Id,
+				Position:    int32(i + 1),
+				Similarity:  q.Similarity,
+				CreatedAt:   now,
+			})
+		}
+	}
+	log := &models.QueryLog{
+		KnowledgeBase:      result.KnowledgeBase,
+		ConversationId:     result.ConversationId,
+		Question:           meta.Question,
+		StandaloneQuestion: result.StandaloneQuestion,
+		Answer:             result.Answer,
+		AnswerSource:       result.AnswerSource,
+		KnowledgeIds:       strings.Join(ids, ","),
+		Channel:            meta.Channel,
+		Fallback:           models.QueryFallbackNo,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if isFallback(result, searchErr) {
+		log.Fallback = models.QueryFallbackYes
+	}
+	if result.Timing != nil {
+		log.Latency = result.Timing.Total
+	}
+	if searchErr != nil {
+		log.Error = searchErr.Error()
+	}
+	if err := new(models.QueryLog).CreateWithHits(log, hits); err != nil {
+		logger.Logger.Errorw("保存查询记录错误", "err", err, "question", meta.Question)
+		return 0
+	}
+	return log.Id
+}
+
+// 解析日期范围 2006-01-02 结束日期包含当天 为空时不限制
+func parseDayRange(startDay, endDay string) (start, end int64, err error) {
+	if startDay != "" {
+		t, e := time.ParseInLocation(time.DateOnly, startDay, time.Local)
+		if e != nil {
+			return 0, 0, fmt.Errorf("%w: 日期格式需为2006-01-02", ErrInvalidParams)
+		}
+		start = t.Unix()
+	}
+	if endDay != "" {
+		t, e := time.ParseInLocation(time.DateOnly, endDay, time.Local)
+		if e != nil {
+			return 0, 0, fmt.Errorf("%w: 日期格式需为2006-01-02", ErrInvalidParams)
+		}
+		end = t.AddDate(0, 0, 1).Unix()
+	}
+	return
+}
+
+func statLimit(limit int) int {
+	if limit <= 0 {
+		return defaultStatLimit
+	}
+	return min(limit, maxStatLimit)
+}
+
+// GetList 分页查询查询记录 channel为空、fallback小于0时不过滤
+func (s *QueryLogService) GetList(ctx context.Context, page, pageSize int, startDay, endDay, channel string, fallback int32) ([]*models.QueryLog, int64, error) {
+	start, end, err := parseDayRange(startDay, endDay)
+	if err != nil {
+		return nil, 0, err
+	}
+	return new(models.QueryLog).GetList(page, pageSize, start, end, channel, fallback)
+}
+
+// TopQuestions 查询次数最多的问题
+func (s *QueryLogService) TopQuestions(ctx context.Context, startDay, endDay string, limit int) ([]*models.QuestionStat, error) {
+	start, end, err := parseDayRange(startDay, endDay)
+	if err != nil {
+		return nil, err
+	}
+	return new(models.QueryLog).GetTopQuestions(start, end, statLimit(limit))
+}
+
+// LeastAnswered 回答率最低的问题
+func (s *QueryLogService) LeastAnswered(ctx context.Context, startDay, endDay string, limit int) ([]*models.QuestionStat, error) {
+	start, end, err := parseDayRange(startDay, endDay)
+	if err != nil {
+		return nil, err
+	}
+	return new(models.QueryLog).GetLeastAnswered(start, end, statLimit(limit))
+}
+
+// KnowledgeHits 各知识被检索到的次数及命中率 按次数从多到少
+func (s *QueryLogService) KnowledgeHits(ctx context.Context, startDay, endDay string, page, pageSize int) (list []*KnowledgeHitItem, total int64, err error) {
+	list = make([]*KnowledgeHitItem, 0)
+	start, end, err := parseDayRange(startDay, endDay)
+	if err != nil {
+		return
+	}
+	queries, err := new(models.QueryLog).Count(start, end)
+	if err != nil {
+		return
+	}
+	stats, total, err := new(models.QueryLogHit).GetKnowledgeStats(start, end, page, pageSize)
+	if err != nil || len(stats) == 0 {
+		return
+	}
+	ids := make([]int64, 0, len(stats))
+	for _, v := range stats {
+		ids = append(ids, v.KnowledgeId)
+	}
+	knowledges, err := new(models.Knowledge).BatchGetByPks(ids)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
+		return
+	}
+	knowledgeMap := make(map[int64]*models.Knowledge)
+	for _, v := range knowledges {
+		knowledgeMap[v.Id] = v
+	}
+	for _, v := range stats {
+		item := &KnowledgeHitItem{
+			KnowledgeHitStat: v,
+			Knowledge:        knowledgeMap[v.KnowledgeId],
+		}
+		if queries > 0 {
+			item.HitRate = float64(v.Hits) / float64(queries)
+		}
+		list = append(list, item)
+	}
+	return
+}
+
+// NotRetrieved 最近days天未被检索到的知识 不包含days天内新增的知识
+func (s *QueryLogService) NotRetrieved(ctx context.Context, days, page, pageSize int) ([]*models.Knowledge, int64, error) {
+	if days <= 0 {
+		return nil, 0, ErrInvalidParams
+	}
+	since := time.Now().AddDate(0, 0, -days).Unix()
+	return new(models.Knowledge).GetNotRetrieved(since, page, pageSize)
+}
+
+// Latency 耗时百分位 不包含出错的查询
+func (s *QueryLogService) Latency(ctx context.Context, startDay, endDay string) (*LatencyStats, error) {
+	start, end, err := parseDayRange(startDay, endDay)
+	if err != nil {
+		return nil, err
+	}
+	total, avg, values, err := new(models.QueryLog).GetLatencyPercentiles(start, end, latencyPercents)
+	if err != nil {
+		return nil, err
+	}
+	return &LatencyStats{
+		Total: total,
+		Avg:   avg,
+		P50:   values[0],
+		P90:   values[1],
+		P95:   values[2],
+		P99:   values[3],
+	}, nil
+}
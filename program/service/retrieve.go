@@ -0,0 +1,196 @@
+package service
+
+import (
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/* 只检索知识 不调用大模型 */
+
+const (
+	// 有过滤条件时首次检索的倍数 过滤后不足topK条时继续扩大检索数量
+	retrieveOverFetch = 5
+	// 单次向量检索的最大条数
+	maxRetrieveTopK = 1000
+)
+
+// 检索过滤条件 各条件同时满足
+type RetrieveFilter struct {
+	Type          *int32   `json:"type"`           // 类型 0问答 1纯知识
+	Tags          []string `json:"tags"`           // 包含其中任一标签
+	DocumentIds   []int64  `json:"document_ids"`   // 来源文档
+	GroupKeys     []string `json:"group_keys"`     // 分组标识
+	MinSimilarity float32  `json:"min_similarity"` // 最低相似度
+}
+
+// 只检索参数
+type RetrieveParams struct {
+	Question      string
+	TopK          int
+	KnowledgeBase string
+	Filter        *RetrieveFilter
+	Group         bool // 是否按分组汇总 只汇总前TopK条命中 分组数可能少于TopK
+}
+
+// 检索命中的知识
+type RetrieveHit struct {
+	*SearchKnowledge
+	GroupSize int64 `json:"group_size"` // 所在分组的知识条数
+}
+
+// 按分组汇总的命中
+type RetrieveGroup struct {
+	GroupKey   string  `json:"group_key"`
+	Type       int32   `json:"type"`
+	Answer     string  `json:"answer,omitempty"` // 问答的答案
+	Similarity float32 `json:"similarity"`       // 组内最高相似度
+	Size       int64   `json:"size"`             // 分组的知识条数
+	Hits       []int64 `json:"hits"`             // 命中的知识id 按相似度从高到低
+}
+
+// 只检索结果
+type RetrieveResult struct {
+	KnowledgeBase string           `json:"knowledge_base,omitempty"`
+	Hits          []*RetrieveHit   `json:"hits"`
+	Groups        []*RetrieveGroup `json:"groups,omitempty"`
+	Usage         *llm.Usage       `json:"usage"`
+	Timing        *RetrieveTiming  `json:"timing"`
+}
+
+// 耗时(毫秒)
+type RetrieveTiming struct {
+	Retrieve int64 `json:"retrieve"` // 向量化及检索
+	Total    int64 `json:"total"`
+}
+
+// 是否需要检索后过滤
+func (f *RetrieveFilter) active() bool {
+	return f != nil && (f.Type != nil || len(f.Tags) > 0 || len(f.DocumentIds) > 0 || len(f.GroupKeys) > 0)
+}
+
+func (f *RetrieveFilter) match(v *SearchKnowledge) bool {
+	if f == nil {
+		return true
+	}
+	if v.Similarity < f.MinSimilarity {
+		return false
+	}
+	if f.Type != nil && v.Type != *f.Type {
+		return false
+	}
+	if len(f.DocumentIds) > 0 && !slices.Contains(f.DocumentIds, v.DocumentId) {
+		return false
+	}
+	if len(f.GroupKeys) > 0 && !slices.Contains(f.GroupKeys, v.GroupKey) {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		tags := strings.Split(v.Tags, ",")
+		if !slices.ContainsFunc(f.Tags, func(tag string) bool {
+			return slices.Contains(tags, tag)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// Retrieve 向量检索知识并返回相似度 不调用大模型
+func (s *KnowledgeService) Retrieve(ctx context.Context, params *RetrieveParams) (result *RetrieveResult, err error) {
+	start := time.Now()
+	ctx, usage := llm.WithUsage(ctx)
+	result = &RetrieveResult{
+		KnowledgeBase: params.KnowledgeBase,
+		Hits:          make([]*RetrieveHit, 0),
+		Usage:         usage,
+		Timing:        new(RetrieveTiming),
+	}
+	defer func() {
+		result.Usage = usage.Snapshot()
+		Usage.Price(result.Usage)
+		result.Timing.Total = time.Since(start).Milliseconds()
+	}()
+
+	if params.TopK > maxRetrieveTopK {
+		err = fmt.Errorf("%w: top_k不能超过%d", ErrInvalidParams, maxRetrieveTopK)
+		return
+	}
+	if f := params.Filter; f != nil && (f.MinSimilarity < 0 || f.MinSimilarity > 1) {
+		err = fmt.Errorf("%w: min_similarity应在0到1之间", ErrInvalidParams)
+		return
+	}
+	// milvus不支持按知识属性过滤 检索后过滤 不足topK条时扩大检索数量
+	// 命中按相似度排序 只有最低相似度时扩大检索也不会有更多满足条件的知识
+	var filter func(*SearchKnowledge) bool
+	if params.Filter.active() {
+		filter = params.Filter.match
+	}
+	knowledges, err := s.retrieveQueries(ctx, []*SearchQuery{{Strategy: QueryOriginal, Text: params.Question}}, params.TopK, false, filter)
+	if err != nil {
+		return
+	}
+	result.Timing.Retrieve = time.Since(start).Milliseconds()
+	knowledges = slices.DeleteFunc(knowledges, func(v *SearchKnowledge) bool {
+		return !params.Filter.match(v)
+	})
+	if len(knowledges) == 0 {
+		return
+	}
+
+	groupKeys := make([]string, 0, len(knowledges))
+	for _, v := range knowledges {
+		if !slices.Contains(groupKeys, v.GroupKey) {
+			groupKeys = append(groupKeys, v.GroupKey)
+		}
+	}
+	sizes, err := new(models.Knowledge).CountByGroupKeys(groupKeys)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "group_keys", groupKeys)
+		return
+	}
+	for _, v := range knowledges {
+		result.Hits = append(result.Hits, &RetrieveHit{
+			SearchKnowledge: v,
+			GroupSize:       sizes[v.GroupKey],
+		})
+	}
+	if params.Group {
+		result.Groups = groupHits(result.Hits)
+	}
+	return
+}
+
+// 按分组汇总命中 分组按组内最高相似度排序
+func groupHits(hits []*RetrieveHit) []*RetrieveGroup {
+	groups := make([]*RetrieveGroup, 0)
+	groupMap := make(map[string]*RetrieveGroup)
+	for _, v := range hits {
+		group, ok := groupMap[v.GroupKey]
+		if !ok {
+			group = &RetrieveGroup{
+				GroupKey: v.GroupKey,
+				Type:     v.Type,
+				Size:     v.GroupSize,
+				Hits:     make([]int64, 0),
+			}
+			groupMap[v.GroupKey] = group
+			groups = append(groups, group)
+		}
+		if group.Answer == "" {
+			group.Answer = v.Answer
+		}
+		group.Similarity = max(group.Similarity, v.Similarity)
+		group.Hits = append(group.Hits, v.Id)
+	}
+	slices.SortStableFunc(groups, func(a, b *RetrieveGroup) int {
+		return cmp.Compare(b.Similarity, a.Similarity)
+	})
+	return groups
+}
@@ -0,0 +1,533 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/embedding"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/internal/milvus"
+	"ai-knowledge/program/models"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+/* 检索知识并调用大模型回答 */
+
+const (
+	// 答案来源
+	AnswerSourceRetrieved = "retrieved" // 直接返回已存答案
+	AnswerSourceGenerated = "generated" // 模型生成
+	AnswerSourceCached    = "cached"    // 相似问题的缓存答案
+
+	// 问答按分组汇总时多检索的倍数 仍不足topK个分组时继续翻倍
+	groupOverFetch = 3
+)
+
+type SearchKnowledge struct {
+	*models.Knowledge
+	Score      float32
+	Similarity float32          `json:"similarity"`         // 相似度 1/(1+L2距离)
+	Document   *models.Document `json:"document,omitempty"` // 来源文档
+	// 同一问答分组中其他命中的问题 按相似度从高到低
+	MatchedQuestions []*MatchedQuestion `json:"matched_questions,omitempty"`
+}
+
+// 命中的问题
+type MatchedQuestion struct {
+	Id         int64   `json:"id"`
+	Question   string  `json:"question"`
+	Similarity float32 `json:"similarity"`
+}
+
+// 检索参数
+type SearchParams struct {
+	Question       string
+	TopK           int
+	KnowledgeBase  string              // 知识库名称 使用对应的配置
+	Prompt         *PromptTemplate     // 覆盖提示词模板
+	LLMParams      *llm.ParamsOverride // 覆盖生成参数
+	ConversationId int64               // 多轮对话会话id 结合历史改写问题并保存本轮问答
+	DirectAnswer   *bool               // 是否启用高相似度问答直接返回 为空时按配置
+	Chain          string              // 回答方式 为空时按配置
+	Strategies     []string            // 检索前的问题处理 为nil时按配置
+	Cache          *bool               // 是否使用语义答案缓存 为空时按配置
+	RetrieveOnly   bool                // 只检索不生成答案 用于评测
+
+	// 流式输出 检索完成后回调知识列表 生成答案时回调增量文本 回调返回错误时终止
+	OnKnowledges func(knowledges []*SearchKnowledge) error
+	OnToken      func(ctx context.Context, chunk []byte) error
+}
+
+// 检索结果
+type SearchResult struct {
+	QueryLogId         int64              `json:"query_log_id,omitempty"` // 查询记录id 评价答案时使用
+	ConversationId     int64              `json:"conversation_id,omitempty"`
+	KnowledgeBase      string             `json:"knowledge_base,omitempty"`
+	StandaloneQuestion string             `json:"standalone_question,omitempty"` // 结合历史改写后的问题
+	Answer             string             `json:"answer"`                        // 去掉引用标注的答案
+	AnswerSource       string             `json:"answer_source"`                 // 答案来源 retrieved直接返回已存答案 generated模型生成 cached缓存答案
+	Cache              *SearchCache       `json:"cache,omitempty"`               // 命中的缓存
+	Chain              string             `json:"chain,omitempty"`               // 模型生成时的回答方式
+	Segments           []*AnswerSegment   `json:"segments"`                      // 按句子切分的答案及引用的知识
+	Knowledges         []*SearchKnowledge `json:"knowledges"`
+	Queries            []*SearchQuery     `json:"queries,omitempty"` // 启用问题处理时各检索问题及效果
+	Params             *llm.Params        `json:"params"`            // 实际使用的生成参数
+	Usage              *llm.Usage         `json:"usage"`
+	Timing             *SearchTiming      `json:"timing"`
+}
+
+// 耗时(毫秒)
+type SearchTiming struct {
+	Condense   int64 `json:"condense"`    // 加载会话及改写问题
+	Retrieve   int64 `json:"retrieve"`    // 向量化及检索
+	FirstToken int64 `json:"first_token"` // 首个token 仅流式输出
+	Generate   int64 `json:"generate"`    // 模型生成
+	Total      int64 `json:"total"`
+}
+
+// 搜索知识
+func (s *KnowledgeService) Search(ctx context.Context, params *SearchParams) (result *SearchResult, err error) {
+	start := time.Now()
+	ctx, usage := llm.WithUsage(ctx)
+	result = &SearchResult{
+		Knowledges: make([]*SearchKnowledge, 0),
+		Segments:   make([]*AnswerSegment, 0),
+		Usage:      usage,
+		Timing:     new(SearchTiming),
+	}
+	defer func() {
+		result.Usage = usage.Snapshot()
+		Usage.Price(result.Usage)
+		result.Timing.Total = time.Since(start).Milliseconds()
+	}()
+
+	// 多轮对话 使用改写后的独立问题检索
+	question := params.Question
+	knowledgeBase := params.KnowledgeBase
+	var cc *conversationContext
+	if params.ConversationId > 0 {
+		cc, err = Conversation.load(ctx, params.ConversationId)
+		if err != nil {
+			return
+		}
+		if knowledgeBase == "" {
+			knowledgeBase = cc.conversation.KnowledgeBase
+		}
+		question, err = Conversation.condense(ctx, cc, params.Question)
+		if err != nil {
+			logger.Logger.Errorw("改写问题错误", "err", err, "question", params.Question)
+			return
+		}
+		result.ConversationId = params.ConversationId
+		result.StandaloneQuestion = question
+		result.Timing.Condense = time.Since(start).Milliseconds()
+	}
+
+	result.KnowledgeBase = knowledgeBase
+	prompt, err := Prompt.Resolve(knowledgeBase, params.Prompt)
+	if err != nil {
+		return
+	}
+	search := searchConfig(knowledgeBase)
+	chain, err := resolveChain(params.Chain, search)
+	if err != nil {
+		return
+	}
+	strategies, err := resolveStrategies(params.Strategies, search)
+	if err != nil {
+		return
+	}
+	result.Params, err = llm.LLMHandler.ResolveParams(params.LLMParams)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidParams, err)
+		return
+	}
+	ctx = llm.WithParams(ctx, result.Params)
+	retrieveStart := time.Now()
+	queries := []*SearchQuery{{Strategy: QueryOriginal, Text: question}}
+
+	// 语义缓存 问题向量同时用于检索
+	useCache := AnswerCache.usable(params)
+	if useCache {
+		queries[0].vector, err = embedding.TextEmbeddingHandler.CalculateEmbedding(ctx, question)
+		if err != nil {
+			logger.Logger.Errorw("处理问题为向量错误", "err", err, "question", question)
+			return
+		}
+		if entry, similarity := AnswerCache.lookup(knowledgeBase, params.TopK, queries[0].vector); entry != nil {
+			result.Timing.Retrieve = time.Since(retrieveStart).Milliseconds()
+			err = s.answerCached(ctx, params, entry, similarity, result)
+			return
+		}
+	}
+
+	if len(strategies) > 0 {
+		queries = s.expandQueries(ctx, question, strategies, search)
+		result.Queries = queries
+	}
+	result.Knowledges, err = s.retrieveQueries(ctx, queries, params.TopK, search.Group == nil || *search.Group, nil)
+	if err != nil {
+		return
+	}
+	result.Timing.Retrieve = time.Since(retrieveStart).Milliseconds()
+	if params.OnKnowledges != nil {
+		if err = params.OnKnowledges(result.Knowledges); err != nil {
+			return
+		}
+	}
+	if len(result.Knowledges) == 0 || params.RetrieveOnly {
+		return
+	}
+
+	if hit := s.directHit(result.Knowledges, search, params.DirectAnswer); hit != nil {
+		err = s.answerDirect(ctx, params, hit, result)
+	} else {
+		err = s.generate(ctx, params, &chainRun{
+			question: question,
+			prompt:   prompt,
+			cc:       cc,
+			search:   search,
+		}, chain, result)
+	}
+	if err != nil {
+		return
+	}
+
+	if useCache && result.AnswerSource == AnswerSourceGenerated {
+		AnswerCache.store(knowledgeBase, params.TopK, question, queries[0].vector, result, start)
+	}
+
+	if cc != nil && result.Answer != "" {
+		if err := Conversation.save(ctx, cc, params.Question, question, result.Answer); err != nil {
+			logger.Logger.Errorw("保存会话消息错误", "err", err, "conversation_id", params.ConversationId)
+		}
+	}
+
+	return
+}
+
+// 检索问答配置 知识库配置中非零的项覆盖全局配置
+func searchConfig(knowledgeBase string) *config.SearchConfig {
+	search := new(config.SearchConfig)
+	if cfg.Search != nil {
+		*search = *cfg.Search
+	}
+	kb, _ := getKnowledgeBase(knowledgeBase)
+	if kb == nil || kb.Search == nil {
+		return search
+	}
+	if kb.Search.DirectThreshold != 0 {
+		search.DirectThreshold = kb.Search.DirectThreshold
+	}
+	if kb.Search.ContextTokens > 0 {
+		search.ContextTokens = kb.Search.ContextTokens
+	}
+	if kb.Search.Overflow != "" {
+		search.Overflow = kb.Search.Overflow
+	}
+	if kb.Search.Chain != "" {
+		search.Chain = kb.Search.Chain
+	}
+	if kb.Search.Strategies != nil {
+		search.Strategies = kb.Search.Strategies
+	}
+	if kb.Search.SubQueries > 0 {
+		search.SubQueries = kb.Search.SubQueries
+	}
+	if kb.Search.Group != nil {
+		search.Group = kb.Search.Group
+	}
+	return search
+}
+
+// 相似度达到阈值的最佳问答
+func (s *KnowledgeService) directHit(knowledges []*SearchKnowledge, search *config.SearchConfig, enabled *bool) *SearchKnowledge {
+	if enabled != nil && !*enabled {
+		return nil
+	}
+	threshold := search.DirectThreshold
+	if threshold <= 0 {
+		return nil
+	}
+	var best *SearchKnowledge
+	for _, v := range knowledges {
+		if v.Type != models.KnowledgeTypeQAndA || v.Answer == "" || v.Similarity < threshold {
+			continue
+		}
+		if best == nil || v.Similarity > best.Similarity {
+			best = v
+		}
+	}
+	return best
+}
+
+// 直接返回已存答案 不调用模型
+func (s *KnowledgeService) answerDirect(ctx context.Context, params *SearchParams, hit *SearchKnowledge, result *SearchResult) error {
+	result.Answer = hit.Answer
+	result.AnswerSource = AnswerSourceRetrieved
+	result.Segments = []*AnswerSegment{
+		{
+			Text:         hit.Answer,
+			KnowledgeIds: []int64{hit.Id},
+		},
+	}
+	if params.OnToken != nil {
+		return params.OnToken(ctx, []byte(hit.Answer))
+	}
+	return nil
+}
+
+// 返回缓存答案 不检索也不调用模型
+func (s *KnowledgeService) answerCached(ctx context.Context, params *SearchParams, entry *cacheEntry, similarity float32, result *SearchResult) error {
+	result.Knowledges = entry.knowledges
+	result.Answer = entry.answer
+	result.Segments = entry.segments
+	result.AnswerSource = AnswerSourceCached
+	result.Cache = &SearchCache{
+		Id:         entry.id,
+		Question:   entry.question,
+		Similarity: similarity,
+	}
+	if params.OnKnowledges != nil {
+		if err := params.OnKnowledges(result.Knowledges); err != nil {
+			return err
+		}
+	}
+	if params.OnToken != nil {
+		return params.OnToken(ctx, []byte(entry.answer))
+	}
+	return nil
+}
+
+// 调用大模型生成答案
+func (s *KnowledgeService) generate(ctx context.Context, params *SearchParams, run *chainRun, chain string, result *SearchResult) error {
+	texts, err := run.prompt.RenderDocuments(newPromptDocuments(result.Knowledges))
+	if err != nil {
+		return err
+	}
+	documents := make([]*chainDocument, 0, len(texts))
+	for i, v := range texts {
+		documents = append(documents, &chainDocument{
+			Index: i + 1,
+			Text:  v,
+		})
+	}
+	generateStart := time.Now()
+	if params.OnToken != nil {
+		run.onToken = func(ctx context.Context, chunk []byte) error {
+			if result.Timing.FirstToken == 0 {
+				result.Timing.FirstToken = time.Since(generateStart).Milliseconds()
+			}
+			return params.OnToken(ctx, chunk)
+		}
+		run.options = append(run.options, llms.WithStreamingFunc(run.onToken))
+	}
+	answer, err := run.run(ctx, chain, documents)
+	result.Timing.Generate = time.Since(generateStart).Milliseconds()
+	if err != nil {
+		logger.Logger.Errorw("调用大模型错误", "err", err, "question", run.question, "chain", chain)
+		return err
+	}
+	result.Answer, result.Segments = parseCitations(answer, result.Knowledges)
+	result.AnswerSource = AnswerSourceGenerated
+	result.Chain = chain
+	return nil
+}
+
+// 向量检索知识 使用多个问题时合并结果 同一知识取最近距离
+// group为true时同一问答分组只保留距离最近的一条 filter不为空时只保留满足条件的知识
+// 过滤或合并后不足topK条时扩大检索数量重新检索
+func (s *KnowledgeService) retrieveQueries(ctx context.Context, queries []*SearchQuery, topK int, group bool, filter func(*SearchKnowledge) bool) (knowledges []*SearchKnowledge, err error) {
+	knowledges = make([]*SearchKnowledge, 0)
+	if err = s.embedQueries(ctx, queries); err != nil {
+		return
+	}
+	fetch := topK
+	if group {
+		fetch = min(topK*groupOverFetch, maxRetrieveTopK)
+	}
+	if filter != nil {
+		fetch = min(max(fetch, topK*retrieveOverFetch), maxRetrieveTopK)
+	}
+	for {
+		scores, ids, exhausted, err := s.searchVectors(ctx, queries, fetch)
+		if err != nil || len(ids) == 0 {
+			return knowledges, err
+		}
+		knowledges, err = s.loadKnowledges(ids, scores)
+		if err != nil {
+			return knowledges, err
+		}
+		if filter != nil {
+			knowledges = slices.DeleteFunc(knowledges, func(v *SearchKnowledge) bool {
+				return !filter(v)
+			})
+		}
+		if group {
+			knowledges = groupKnowledges(knowledges)
+		}
+		if len(knowledges) >= topK || exhausted || fetch >= maxRetrieveTopK {
+			logQueryEffect(queries[0].Text, queries, scores[ids[0]])
+			break
+		}
+		fetch = min(fetch*2, maxRetrieveTopK)
+	}
+	knowledges = knowledges[:min(len(knowledges), topK)]
+	return
+}
+
+// 计算尚无向量的问题的向量
+func (s *KnowledgeService) embedQueries(ctx context.Context, queries []*SearchQuery) error {
+	pending := make([]string, 0, len(queries))
+	for _, v := range queries {
+		if v.vector == nil {
+			pending = append(pending, v.Text)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	computed, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, pending)
+	if err != nil {
+		logger.Logger.Errorw("处理问题为向量错误", "err", err, "question", pending)
+		return err
+	}
+	if len(computed) != len(pending) {
+		return ErrVectorTransform
+	}
+	for _, v := range queries {
+		if v.vector == nil {
+			v.vector, computed = computed[0], computed[1:]
+		}
+	}
+	return nil
+}
+
+// 各问题分别检索fetch条 返回各向量id的最近距离及按距离排序的id
+// 各问题的检索结果均不足fetch条时exhausted为true 扩大检索数量也不会有更多结果
+func (s *KnowledgeService) searchVectors(ctx context.Context, queries []*SearchQuery, fetch int) (scores map[int64]float32, ids []int64, exhausted bool, err error) {
+	scores = make(map[int64]float32)
+	ids = make([]int64, 0)
+	exhausted = true
+	for i, query := range queries {
+		if len(query.vector) == 0 {
+			err = ErrVectorTransform
+			return
+		}
+		var results []*milvus.SearchResult
+		results, err = milvus.MilvusHandler.Search(ctx, query.vector, fetch)
+		if err != nil {
+			logger.Logger.Errorw("搜索向量数据库错误", "err", err, "question", query.Text, "top_k", fetch)
+			return
+		}
+		if len(results) >= fetch {
+			exhausted = false
+		}
+		query.Hits, query.NewHits = len(results), 0
+		for j, v := range results {
+			if j == 0 || v.Score < query.BestScore {
+				query.BestScore = v.Score
+			}
+			score, ok := scores[v.Id]
+			if !ok {
+				if i > 0 {
+					query.NewHits++
+				}
+				ids = append(ids, v.Id)
+			}
+			if !ok || v.Score < score {
+				scores[v.Id] = v.Score
+			}
+		}
+	}
+	// 多个问题合并后按距离排序
+	slices.SortStableFunc(ids, func(a, b int64) int {
+		return cmp.Compare(scores[a], scores[b])
+	})
+	return
+}
+
+// 查询向量id对应的知识及来源文档 按距离从近到远排序 不包含待审核的问题变体
+func (s *KnowledgeService) loadKnowledges(ids []int64, scores map[int64]float32) ([]*SearchKnowledge, error) {
+	knowledges := make([]*SearchKnowledge, 0, len(ids))
+	list, err := new(models.Knowledge).BatchGetByIds(ids)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
+		return knowledges, err
+	}
+	// 来源文档
+	documentMap, err := s.getDocumentMap(list)
+	if err != nil {
+		logger.Logger.Errorw("查询文档错误", "err", err, "ids", ids)
+		return knowledges, err
+	}
+	// 整理数据
+	for _, v := range list {
+		// 模型生成的问题审核通过前不参与检索
+		if v.Generated == models.GeneratedPending {
+			continue
+		}
+		score := scores[v.VectorId]
+		knowledges = append(knowledges, &SearchKnowledge{
+			Knowledge:  v,
+			Score:      score,
+			Similarity: 1 / (1 + score),
+			Document:   documentMap[v.DocumentId],
+		})
+	}
+	slices.SortStableFunc(knowledges, func(a, b *SearchKnowledge) int {
+		return cmp.Compare(a.Score, b.Score)
+	})
+	return knowledges, nil
+}
+
+// 同一问答分组只保留距离最近的一条 组内其他命中的问题记录在MatchedQuestions
+// 文档切片和纯知识各自独立 不合并
+func groupKnowledges(knowledges []*SearchKnowledge) []*SearchKnowledge {
+	grouped := make([]*SearchKnowledge, 0, len(knowledges))
+	best := make(map[string]*SearchKnowledge)
+	for _, v := range knowledges {
+		if v.Type != models.KnowledgeTypeQAndA || v.GroupKey == "" {
+			grouped = append(grouped, v)
+			continue
+		}
+		if b, ok := best[v.GroupKey]; ok {
+			b.MatchedQuestions = append(b.MatchedQuestions, &MatchedQuestion{
+				Id:         v.Id,
+				Question:   v.Question,
+				Similarity: v.Similarity,
+			})
+			continue
+		}
+		best[v.GroupKey] = v
+		grouped = append(grouped, v)
+	}
+	return grouped
+}
+
+// 查询知识来源文档
+func (s *KnowledgeService) getDocumentMap(list []*models.Knowledge) (map[int64]*models.Document, error) {
+	documentMap := make(map[int64]*models.Document)
+	documentIds := make([]int64, 0)
+	for _, v := range list {
+		if v.DocumentId > 0 {
+			documentIds = append(documentIds, v.DocumentId)
+		}
+	}
+	if len(documentIds) == 0 {
+		return documentMap, nil
+	}
+	documents, err := new(models.Document).BatchGetByIds(documentIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range documents {
+		documentMap[v.Id] = v
+	}
+	return documentMap, nil
+}
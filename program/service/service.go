@@ -1,9 +1,26 @@
 package service
 
-import "errors"
+import (
+	"ai-knowledge/internal/config"
+	"errors"
+)
 
 var (
 	ErrInvalidParams   = errors.New("invalid params")
 	ErrVectorTransform = errors.New("vector transform failed")
 	ErrDataNotFound    = errors.New("data not found")
+	ErrSyncDisabled    = errors.New("sync disabled")
 )
+
+var (
+	// 业务配置
+	cfg = new(config.Config)
+)
+
+// InitService 初始化业务配置
+func InitService(c *config.Config) {
+	if c == nil {
+		return
+	}
+	cfg = c
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/internal/watcher"
+	"ai-knowledge/program/models"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* 监听目录同步markdown文档 */
+
+const (
+	// 默认文件变化合并间隔
+	defaultSyncDebounce = 500 * time.Millisecond
+
+	// 同步结果
+	SyncStatusCreated   = "created"
+	SyncStatusUpdated   = "updated"
+	SyncStatusUnchanged = "unchanged"
+	SyncStatusDeleted   = "deleted"
+	SyncStatusFailed    = "failed"
+)
+
+var (
+	Sync = &SyncService{
+		files: make(map[string]*SyncFile),
+	}
+
+	defaultSyncExts = []string{".md", ".markdown"}
+)
+
+// 同步文件状态
+type SyncFile struct {
+	Path       string `json:"path"`
+	DocumentId int64  `json:"document_id"`
+	Checksum   string `json:"checksum"`
+	Version    int32  `json:"version"`
+	Status     string `json:"status"` // 最近一次同步结果
+	Error      string `json:"error,omitempty"`
+	SyncedAt   int64  `json:"synced_at"`
+}
+
+// 同步状态
+type SyncStatus struct {
+	Dirs  []string    `json:"dirs"`
+	Files []*SyncFile `json:"files"`
+}
+
+// 目录同步 文件以绝对路径作为文档来源 新增、修改、删除时增量更新文档切片
+type SyncService struct {
+	watcher *watcher.Watcher
+	dirs    []string
+	exts    []string
+	pending chan string
+	ctx     context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	files map[string]*SyncFile
+}
+
+// Start 监听配置的目录 启动时全量扫描一次
+func (s *SyncService) Start() {
+	if cfg.Sync == nil || len(cfg.Sync.Dirs) == 0 {
+		return
+	}
+	s.exts = defaultSyncExts
+	if len(cfg.Sync.Exts) > 0 {
+		s.exts = make([]string, 0, len(cfg.Sync.Exts))
+		for _, v := range cfg.Sync.Exts {
+			s.exts = append(s.exts, strings.ToLower(v))
+		}
+	}
+	debounce := defaultSyncDebounce
+	if cfg.Sync.Debounce > 0 {
+		debounce = time.Duration(cfg.Sync.Debounce) * time.Millisecond
+	}
+	s.dirs = make([]string, 0, len(cfg.Sync.Dirs))
+	for _, v := range cfg.Sync.Dirs {
+		dir, err := filepath.Abs(v)
+		if err != nil {
+			logger.Logger.Errorw("同步目录不合法", "err", err, "dir", v)
+			continue
+		}
+		s.dirs = append(s.dirs, dir)
+	}
+
+	s.pending = make(chan string, 1024)
+	s.ctx, s.stop = context.WithCancel(context.Background())
+	w, err := watcher.New(watcher.Options{
+		Debounce:  debounce,
+		Recursive: true,
+		Match:     s.match,
+		OnChange: func(path string) {
+			select {
+			case s.pending <- path:
+			case <-s.ctx.Done():
+			}
+		},
+		OnError: func(err error) {
+			logger.Logger.Errorw("目录监听错误", "err", err)
+		},
+	})
+	if err != nil {
+		logger.Logger.Errorw("创建目录监听错误", "err", err)
+		s.stop()
+		s.stop = nil
+		return
+	}
+	s.watcher = w
+	s.wg.Add(1)
+	go s.worker()
+	// 空路径表示全量扫描
+	s.pending <- ""
+}
+
+// Stop 停止监听
+func (s *SyncService) Stop() {
+	if s.stop == nil {
+		return
+	}
+	s.stop()
+	s.watcher.Close()
+	s.wg.Wait()
+}
+
+// Rescan 重新全量扫描 补偿监听丢失的变化
+func (s *SyncService) Rescan(ctx context.Context) error {
+	if s.stop == nil {
+		return ErrSyncDisabled
+	}
+	select {
+	case s.pending <- "":
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Status 同步目录及文件状态
+func (s *SyncService) Status(ctx context.Context) *SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := &SyncStatus{
+		Dirs:  slices.Clone(s.dirs),
+		Files: make([]*SyncFile, 0, len(s.files)),
+	}
+	if status.Dirs == nil {
+		status.Dirs = make([]string, 0)
+	}
+	for _, v := range s.files {
+		file := *v
+		status.Files = append(status.Files, &file)
+	}
+	slices.SortFunc(status.Files, func(a, b *SyncFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return status
+}
+
+// 按顺序执行同步 避免同一文件并发处理
+func (s *SyncService) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case path := <-s.pending:
+			if path == "" {
+				s.scan()
+			} else {
+				s.syncPath(path)
+			}
+		}
+	}
+}
+
+// 是否为需要同步的文件
+func (s *SyncService) match(path string) bool {
+	return slices.Contains(s.exts, strings.ToLower(filepath.Ext(path)))
+}
+
+// 全量扫描 同步全部文件并删除文件已不存在的文档
+func (s *SyncService) scan() {
+	for _, dir := range s.dirs {
+		if err := s.watcher.AddRecursive(dir); err != nil {
+			logger.Logger.Errorw("遍历同步目录错误", "err", err, "dir", dir)
+		}
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if s.ctx.Err() != nil {
+				return s.ctx.Err()
+			}
+			if err == nil && !d.IsDir() && s.match(path) {
+				s.syncPath(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+
+		documents, err := new(models.Document).GetByOriginPrefix(dir + string(filepath.Separator))
+		if err != nil {
+			logger.Logger.Errorw("查询同步文档错误", "err", err, "dir", dir)
+			continue
+		}
+		for _, v := range documents {
+			if _, err = os.Stat(v.Origin); errors.Is(err, fs.ErrNotExist) {
+				s.syncPath(v.Origin)
+			}
+		}
+	}
+}
+
+// 同步单个路径 路径不存在或文件内容为空时删除对应文档
+func (s *SyncService) syncPath(path string) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.remove(path)
+		return
+	}
+	if err != nil {
+		s.record(&SyncFile{Path: path, Status: SyncStatusFailed, Error: err.Error()})
+		return
+	}
+	if info.IsDir() || !s.match(path) {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		s.record(&SyncFile{Path: path, Status: SyncStatusFailed, Error: err.Error()})
+		return
+	}
+	if len(content) == 0 {
+		// 文档内容不能为空 清空的文件按删除处理
+		s.remove(path)
+		return
+	}
+	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	document, changed, err := Document.SaveDocument(s.ctx, title, path, string(content))
+	if err != nil {
+		logger.Logger.Errorw("同步文档错误", "err", err, "path", path)
+		s.record(&SyncFile{Path: path, Status: SyncStatusFailed, Error: err.Error()})
+		return
+	}
+	status := SyncStatusUnchanged
+	if changed && document.Version == 1 {
+		status = SyncStatusCreated
+	} else if changed {
+		status = SyncStatusUpdated
+	}
+	if changed {
+		logger.Logger.Infow("同步文档", "path", path, "status", status, "version", document.Version)
+	}
+	s.record(&SyncFile{
+		Path:       path,
+		DocumentId: document.Id,
+		Checksum:   document.Checksum,
+		Version:    document.Version,
+		Status:     status,
+	})
+}
+
+// 删除文件或目录对应的文档
+func (s *SyncService) remove(path string) {
+	documents, err := new(models.Document).GetByOriginPrefix(path + string(filepath.Separator))
+	if err != nil {
+		logger.Logger.Errorw("查询同步文档错误", "err", err, "path", path)
+		return
+	}
+	document, err := new(models.Document).GetByOrigin(path)
+	if err != nil {
+		logger.Logger.Errorw("查询同步文档错误", "err", err, "path", path)
+		return
+	}
+	if document != nil {
+		documents = append(documents, document)
+	}
+	for _, v := range documents {
+		err = Document.DelDocument(s.ctx, v.Id)
+		if err != nil {
+			logger.Logger.Errorw("删除同步文档错误", "err", err, "path", v.Origin)
+			s.record(&SyncFile{Path: v.Origin, DocumentId: v.Id, Status: SyncStatusFailed, Error: err.Error()})
+			continue
+		}
+		logger.Logger.Infow("同步删除文档", "path", v.Origin, "document_id", v.Id)
+		s.record(&SyncFile{Path: v.Origin, DocumentId: v.Id, Status: SyncStatusDeleted})
+	}
+}
+
+// 记录同步结果
+func (s *SyncService) record(file *SyncFile) {
+	file.SyncedAt = time.Now().Unix()
+	s.mu.Lock()
+	s.files[file.Path] = file
+	s.mu.Unlock()
+}
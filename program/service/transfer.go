@@ -0,0 +1,511 @@
+package service
+
+import (
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/* 知识批量导入导出 */
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+
+	// 导出时每批查询的分组数
+	exportBatchSize = 100
+	// jsonl单行最大长度
+	maxLineSize = 10 << 20
+	// csv多值字段分隔符
+	csvValueSep = "\n"
+	csvTagSep   = ","
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format")
+
+	csvHeader = []string{"group_key", "type", "questions", "answer", "texts", "tags", "generated"}
+)
+
+// 导入导出的一行数据 对应一个分组 文档切片由文档管理 不在其中
+type KnowledgeRow struct {
+	GroupKey  string   `json:"group_key,omitempty"`
+	Type      int32    `json:"type"` // 0问答 1纯知识
+	Questions []string `json:"questions,omitempty"`
+	Answer    string   `json:"answer,omitempty"`
+	Texts     []string `json:"texts,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	// 与questions一一对应的问题来源 0人工 1模型生成待审核 2模型生成已确认 为空时均为人工
+	Generated []int32 `json:"generated,omitempty"`
+}
+
+// 行错误
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// 导入结果
+type ImportResult struct {
+	DryRun  bool        `json:"dry_run"`
+	Total   int         `json:"total"`
+	Success int         `json:"success"`
+	Failed  int         `json:"failed"`
+	Errors  []*RowError `json:"errors"`
+}
+
+// 添加行错误
+func (r *ImportResult) addError(line int, err error) {
+	r.Failed++
+	r.Errors = append(r.Errors, &RowError{
+		Line:  line,
+		Error: err.Error(),
+	})
+}
+
+// 校验并整理行数据
+func (row *KnowledgeRow) Validate() error {
+	row.GroupKey = strings.TrimSpace(row.GroupKey)
+	if len(row.Generated) > 0 {
+		if row.Type != models.KnowledgeTypeQAndA || len(row.Generated) != len(row.Questions) {
+			return errors.New("generated应与问题一一对应")
+		}
+		questions := make([]string, 0, len(row.Questions))
+		generated := make([]int32, 0, len(row.Generated))
+		for i, v := range row.Questions {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			if row.Generated[i] < models.GeneratedNone || row.Generated[i] > models.GeneratedAccepted {
+				return fmt.Errorf("问题来源不合法: %d", row.Generated[i])
+			}
+			questions = append(questions, v)
+			generated = append(generated, row.Generated[i])
+		}
+		row.Questions, row.Generated = questions, generated
+	}
+	row.Questions = trimValues(row.Questions)
+	row.Texts = trimValues(row.Texts)
+	row.Tags = trimValues(row.Tags)
+	row.Answer = strings.TrimSpace(row.Answer)
+	switch row.Type {
+	case models.KnowledgeTypeQAndA:
+		if len(row.Questions) == 0 {
+			return errors.New("问题不能为空")
+		}
+		if row.Answer == "" {
+			return errors.New("答案不能为空")
+		}
+	case models.KnowledgeTypePure:
+		if len(row.Texts) == 0 {
+			return errors.New("知识内容不能为空")
+		}
+	default:
+		return fmt.Errorf("类型不合法: %d", row.Type)
+	}
+	for _, v := range row.Tags {
+		if strings.Contains(v, csvTagSep) {
+			return fmt.Errorf("标签不能包含%s: %s", csvTagSep, v)
+		}
+	}
+	return nil
+}
+
+// 去除空白值
+func trimValues(values []string) []string {
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// ReadRows 流式读取导入数据 每读取一行回调一次 回调返回错误时终止读取
+func ReadRows(r io.Reader, format string, fn func(line int, row *KnowledgeRow, err error) error) error {
+	switch format {
+	case FormatJSONL:
+		return readJSONLRows(r, fn)
+	case FormatCSV:
+		return readCSVRows(r, fn)
+	}
+	return ErrUnsupportedFormat
+}
+
+// 读取jsonl 每行一个json对象
+func readJSONLRows(r io.Reader, fn func(line int, row *KnowledgeRow, err error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		row := new(KnowledgeRow)
+		err := json.Unmarshal([]byte(text), row)
+		if err != nil {
+			err = fmt.Errorf("json格式错误: %w", err)
+		}
+		if err := fn(line, row, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// 读取csv 首行为表头 多个问题或知识使用换行分隔 多个标签使用,分隔
+func readCSVRows(r io.Reader, fn func(line int, row *KnowledgeRow, err error) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	columns := make(map[string]int)
+	for i, v := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(v, "\ufeff")))] = i
+	}
+	if _, ok := columns["questions"]; !ok {
+		if _, ok := columns["texts"]; !ok {
+			return errors.New("csv表头缺少questions或texts列")
+		}
+	}
+	get := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line := 0
+		row := new(KnowledgeRow)
+		if perr, ok := err.(*csv.ParseError); ok {
+			line = perr.StartLine
+		} else if err != nil {
+			return err
+		} else {
+			line, _ = cr.FieldPos(0)
+			row.GroupKey = get(record, "group_key")
+			row.Questions = strings.Split(get(record, "questions"), csvValueSep)
+			row.Answer = get(record, "answer")
+			row.Texts = strings.Split(get(record, "texts"), csvValueSep)
+			row.Tags = strings.Split(get(record, "tags"), csvTagSep)
+			if generated := strings.TrimSpace(get(record, "generated")); generated != "" {
+				for _, v := range strings.Split(generated, csvTagSep) {
+					n, perr := strconv.Atoi(strings.TrimSpace(v))
+					if perr != nil && err == nil {
+						err = fmt.Errorf("问题来源不合法: %s", v)
+					}
+					row.Generated = append(row.Generated, int32(n))
+				}
+			}
+			if typ := strings.TrimSpace(get(record, "type")); typ != "" {
+				v, perr := strconv.Atoi(typ)
+				if perr != nil {
+					err = fmt.Errorf("类型不合法: %s", typ)
+				}
+				row.Type = int32(v)
+			}
+		}
+		if err := fn(line, row, err); err != nil {
+			return err
+		}
+	}
+}
+
+// 导出写入
+type rowWriter interface {
+	Write(row *KnowledgeRow) error
+	Flush() error
+}
+
+type jsonlRowWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (jw *jsonlRowWriter) Write(row *KnowledgeRow) error {
+	return jw.enc.Encode(row)
+}
+
+func (jw *jsonlRowWriter) Flush() error {
+	return jw.w.Flush()
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvRowWriter) Write(row *KnowledgeRow) error {
+	return cw.w.Write([]string{
+		row.GroupKey,
+		strconv.Itoa(int(row.Type)),
+		strings.Join(row.Questions, csvValueSep),
+		row.Answer,
+		strings.Join(row.Texts, csvValueSep),
+		strings.Join(row.Tags, csvTagSep),
+		joinInts(row.Generated, csvTagSep),
+	})
+}
+
+// 整数列表转为字符串
+func joinInts(values []int32, sep string) string {
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		list = append(list, strconv.Itoa(int(v)))
+	}
+	return strings.Join(list, sep)
+}
+
+func (cw *csvRowWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// 创建导出写入
+func newRowWriter(w io.Writer, format string) (rowWriter, error) {
+	switch format {
+	case FormatJSONL:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		enc.SetEscapeHTML(false)
+		return &jsonlRowWriter{w: bw, enc: enc}, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvRowWriter{w: cw}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// 导入一行数据
+func (s *KnowledgeService) ImportRow(ctx context.Context, row *KnowledgeRow) (err error) {
+	switch row.Type {
+	case models.KnowledgeTypeQAndA:
+		_, err = s.SaveQAndA(ctx, &QAndAGroup{
+			GroupKey:  row.GroupKey,
+			Questions: row.Questions,
+			Answer:    row.Answer,
+			Tags:      row.Tags,
+			Generated: row.Generated,
+		})
+	case models.KnowledgeTypePure:
+		_, err = s.SaveKnowledge(ctx, &KnowledgeGroup{
+			GroupKey: row.GroupKey,
+			Texts:    row.Texts,
+			Tags:     row.Tags,
+		})
+	}
+	return
+}
+
+// Import 流式导入 已存在的分组标识会整组替换 dryRun时只校验不写入
+func (s *KnowledgeService) Import(ctx context.Context, r io.Reader, format string, dryRun bool) (*ImportResult, error) {
+	result := &ImportResult{
+		DryRun: dryRun,
+		Errors: make([]*RowError, 0),
+	}
+	next := func() bool {
+		return true
+	}
+	err := s.importRows(ctx, r, format, dryRun, next, nil, func(line int, err error) {
+		result.Total++
+		if err != nil {
+			result.addError(line, err)
+			return
+		}
+		result.Success++
+	})
+	return result, err
+}
+
+// 逐行导入 next返回false时跳过该行 groupKey不为nil时为没有分组标识的行生成标识 每行处理完成后回调done
+func (s *KnowledgeService) importRows(ctx context.Context, r io.Reader, format string, dryRun bool,
+	next func() bool, groupKey func() string, done func(line int, err error)) error {
+	groupKeys := make(map[string]int)
+	err := ReadRows(r, format, func(line int, row *KnowledgeRow, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		process := next()
+		if err == nil {
+			err = row.Validate()
+		}
+		if err == nil && row.GroupKey != "" {
+			if prev, ok := groupKeys[row.GroupKey]; ok {
+				err = fmt.Errorf("分组标识与第%d行重复", prev)
+			} else {
+				groupKeys[row.GroupKey] = line
+			}
+		}
+		if !process {
+			return nil
+		}
+		if err == nil && !dryRun {
+			if row.GroupKey == "" && groupKey != nil {
+				row.GroupKey = groupKey()
+			}
+			err = s.ImportRow(ctx, row)
+		}
+		done(line, err)
+		return nil
+	})
+	if err != nil {
+		logger.Logger.Errorw("导入数据错误", "err", err, "format", format)
+	}
+	return err
+}
+
+// 导入任务参数
+type ImportJobParams struct {
+	Format string `json:"format"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// 异步导入任务
+func (s *KnowledgeService) importJob(ctx context.Context, job *models.Job, progress *JobProgress) (result any, err error) {
+	params := new(ImportJobParams)
+	if err = json.Unmarshal([]byte(job.Params), params); err != nil {
+		return
+	}
+	if job.Total == 0 {
+		total, err := countJobFileRows(job.File, func(r io.Reader, fn func() error) error {
+			return ReadRows(r, params.Format, func(int, *KnowledgeRow, error) error {
+				return fn()
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		progress.SetTotal(total)
+	}
+
+	f, err := os.Open(job.File)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	// 没有分组标识的行使用由行序号生成的标识 重启后重新导入同一行时不会重复创建
+	err = s.importRows(ctx, f, params.Format, params.DryRun, progress.Next, progress.GroupKey, progress.Done)
+	return
+}
+
+// 统计任务文件行数
+func countJobFileRows(path string, read func(r io.Reader, fn func() error) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	total := int64(0)
+	err = read(f, func() error {
+		total++
+		return nil
+	})
+	return total, err
+}
+
+// Export 按分组导出全部知识 文档切片由文档管理 不导出
+func (s *KnowledgeService) Export(ctx context.Context, w io.Writer, format string) (err error) {
+	rw, err := newRowWriter(w, format)
+	if err != nil {
+		return
+	}
+	knowledgeHandler := new(models.Knowledge)
+	groupKeys, err := knowledgeHandler.GetGroupKeys()
+	if err != nil {
+		logger.Logger.Errorw("查询分组错误", "err", err)
+		return
+	}
+	for start := 0; start < len(groupKeys); start += exportBatchSize {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		end := min(start+exportBatchSize, len(groupKeys))
+		list, err := knowledgeHandler.GetByGroupKeys(groupKeys[start:end])
+		if err != nil {
+			logger.Logger.Errorw("查询知识错误", "err", err)
+			return err
+		}
+		for _, row := range knowledgeToRows(groupKeys[start:end], list) {
+			if err := rw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return rw.Flush()
+}
+
+// 按分组整理为导出行 无分组标识的数据每条单独一行 文档切片不导出
+// 有模型生成的问题时输出generated 重新导入后保留审核状态
+func knowledgeToRows(groupKeys []string, list []*models.Knowledge) []*KnowledgeRow {
+	groups := make(map[string]*KnowledgeRow)
+	rows := make([]*KnowledgeRow, 0)
+	singles := make([]*KnowledgeRow, 0)
+	for _, v := range list {
+		if v.DocumentId > 0 {
+			continue
+		}
+		row, ok := groups[v.GroupKey]
+		if !ok || v.GroupKey == "" {
+			row = &KnowledgeRow{
+				GroupKey: v.GroupKey,
+				Type:     v.Type,
+				Answer:   v.Answer,
+			}
+			if v.Tags != "" {
+				row.Tags = strings.Split(v.Tags, csvTagSep)
+			}
+			if v.GroupKey == "" {
+				singles = append(singles, row)
+			} else {
+				groups[v.GroupKey] = row
+			}
+		}
+		switch v.Type {
+		case models.KnowledgeTypeQAndA:
+			row.Questions = append(row.Questions, v.Question)
+			row.Generated = append(row.Generated, v.Generated)
+		case models.KnowledgeTypePure:
+			row.Texts = append(row.Texts, v.Text)
+		}
+	}
+	// 保持分组原有顺序
+	for _, groupKey := range groupKeys {
+		if row, ok := groups[groupKey]; ok {
+			rows = append(rows, row)
+		}
+	}
+	rows = append(rows, singles...)
+	for _, row := range rows {
+		if !slices.ContainsFunc(row.Generated, func(v int32) bool { return v != models.GeneratedNone }) {
+			row.Generated = nil
+		}
+	}
+	return rows
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"ai-knowledge/internal/config"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/* token用量及费用统计 */
+
+var (
+	Usage = new(UsageService)
+
+	// 汇总支持的分组字段
+	usageGroupBys = []string{"knowledge_base", "api_key", "endpoint", "type", "provider", "model"}
+)
+
+// 用量统计
+type UsageService struct {
+}
+
+// 请求信息
+type UsageMeta struct {
+	KnowledgeBase string
+	ApiKey        string // 调用方密钥 保存时脱敏
+	Endpoint      string // 接口路径
+}
+
+// 用量汇总
+type UsageReport struct {
+	Currency string               `json:"currency"`
+	List     []*models.UsageDaily `json:"list"`
+}
+
+// 模型价格 未配置时返回nil
+func modelPrice(model string) *config.ModelPriceConfig {
+	if cfg.Usage == nil {
+		return nil
+	}
+	for _, v := range cfg.Usage.Prices {
+		if v.Model == model {
+			return v
+		}
+	}
+	return nil
+}
+
+// 按每千token价格计算费用
+func tokenCost(model string, promptTokens, completionTokens int) float64 {
+	price := modelPrice(model)
+	if price == nil {
+		return 0
+	}
+	return (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1000
+}
+
+// Price 按配置价格计算用量中各模型及总的费用
+func (s *UsageService) Price(usage *llm.Usage) {
+	usage.Cost = 0
+	for _, v := range usage.Endpoints {
+		v.Cost = tokenCost(v.Model, v.PromptTokens, v.CompletionTokens)
+		usage.Cost += v.Cost
+	}
+	if usage.Embedding != nil {
+		usage.Embedding.Cost = tokenCost(usage.Embedding.Model, usage.Embedding.PromptTokens, 0)
+		usage.Cost += usage.Embedding.Cost
+	}
+}
+
+// 密钥脱敏 只保留首尾各4位
+func maskApiKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 12 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
+
+// Record 保存一次请求的用量 每个模型一条记录 未开启统计或没有用量时忽略
+func (s *UsageService) Record(ctx context.Context, meta *UsageMeta, usage *llm.Usage) error {
+	if cfg.Usage == nil || !cfg.Usage.Enabled || usage == nil {
+		return nil
+	}
+	now := time.Now()
+	requestId := uuid.NewString()
+	newRecord := func(typ, provider, model string) *models.UsageRecord {
+		return &models.UsageRecord{
+			RequestId:     requestId,
+			KnowledgeBase: meta.KnowledgeBase,
+			ApiKey:        maskApiKey(meta.ApiKey),
+			Endpoint:      meta.Endpoint,
+			Type:          typ,
+			Provider:      provider,
+			Model:         model,
+			Day:           now.Format(time.DateOnly),
+		}
+	}
+	records := make([]*models.UsageRecord, 0, len(usage.Endpoints)+1)
+	for _, v := range usage.Endpoints {
+		record := newRecord(models.UsageTypeLLM, v.Endpoint, v.Model)
+		record.PromptTokens = v.PromptTokens
+		record.CompletionTokens = v.CompletionTokens
+		record.TotalTokens = v.TotalTokens
+		record.Calls = v.Calls
+		record.Cost = v.Cost
+		records = append(records, record)
+	}
+	if v := usage.Embedding; v != nil && v.Calls > 0 {
+		record := newRecord(models.UsageTypeEmbedding, "", v.Model)
+		record.PromptTokens = v.PromptTokens
+		record.TotalTokens = v.PromptTokens
+		record.Calls = v.Calls
+		record.Cost = v.Cost
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	err := new(models.UsageRecord).BatchCreate(records)
+	if err != nil {
+		logger.Logger.Errorw("保存用量记录错误", "err", err, "endpoint", meta.Endpoint)
+	}
+	return err
+}
+
+// Daily 按天汇总 groupBy为空时只按天汇总
+func (s *UsageService) Daily(ctx context.Context, startDay, endDay, groupBy string) (*UsageReport, error) {
+	if groupBy != "" && !slices.Contains(usageGroupBys, groupBy) {
+		return nil, fmt.Errorf("%w: 分组字段需为%s之一", ErrInvalidParams, strings.Join(usageGroupBys, "、"))
+	}
+	for _, v := range []string{startDay, endDay} {
+		if _, err := time.Parse(time.DateOnly, v); v != "" && err != nil {
+			return nil, fmt.Errorf("%w: 日期格式需为2006-01-02", ErrInvalidParams)
+		}
+	}
+	list, err := new(models.UsageRecord).GetDaily(startDay, endDay, groupBy)
+	if err != nil {
+		return nil, err
+	}
+	report := &UsageReport{
+		List: list,
+	}
+	if cfg.Usage != nil {
+		report.Currency = cfg.Usage.Currency
+	}
+	return report, nil
+}
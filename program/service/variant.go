@@ -0,0 +1,247 @@
+package service
+
+import (
+	"ai-knowledge/internal/embedding"
+	"ai-knowledge/internal/llm"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/internal/milvus"
+	"ai-knowledge/program/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/* 模型生成问题变体 */
+
+const (
+	// 每个问题最多生成的变体数
+	maxVariants = 10
+
+	variantPrompt = `请为下面的问题生成%d个意思相同但表述不同的问法，用于提升知识库检索的召回率。
+要求：
+1. 保持原问题的含义，可以使用口语化、同义词、不同句式
+2. 不要回答问题，不要编号
+3. 只输出JSON字符串数组，例如：["问法1", "问法2"]
+
+问题：%s`
+)
+
+// 从模型输出中提取json 兼容输出中包含说明文字或markdown代码块
+func extractJSON(text string, v any) error {
+	start := strings.IndexAny(text, "[{")
+	end := strings.LastIndexAny(text, "]}")
+	if start < 0 || end < start {
+		return fmt.Errorf("模型输出不是json: %s", text)
+	}
+	return json.Unmarshal([]byte(text[start:end+1]), v)
+}
+
+// 调用模型生成单个问题的变体
+func (s *KnowledgeService) paraphrase(ctx context.Context, question string, n int) ([]string, error) {
+	text, err := llm.LLMHandler.Call(ctx, fmt.Sprintf(variantPrompt, n, question))
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]string, 0)
+	if err = extractJSON(text, &variants); err != nil {
+		return nil, err
+	}
+	return trimValues(variants), nil
+}
+
+// GenVariants 为分组内人工录入的问题生成变体 作为待审核问题保存在同一分组 返回新增数量
+func (s *KnowledgeService) GenVariants(ctx context.Context, groupKey string, n int) (count int, err error) {
+	if groupKey == "" || n <= 0 {
+		err = ErrInvalidParams
+		return
+	}
+	n = min(n, maxVariants)
+	list, err := new(models.Knowledge).GetByGroupKey(groupKey)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "group_key", groupKey)
+		return
+	}
+	var first *models.Knowledge
+	exists := make(map[string]bool)
+	for _, v := range list {
+		if v.Type != models.KnowledgeTypeQAndA {
+			continue
+		}
+		exists[v.Question] = true
+		if first == nil {
+			first = v
+		}
+	}
+	if first == nil {
+		err = ErrDataNotFound
+		return
+	}
+
+	questions := make([]string, 0)
+	for _, v := range list {
+		if v.Type != models.KnowledgeTypeQAndA || v.Generated != models.GeneratedNone {
+			continue
+		}
+		variants, err := s.paraphrase(ctx, v.Question, n)
+		if err != nil {
+			logger.Logger.Errorw("模型生成问题变体错误", "err", err, "question", v.Question)
+			return 0, err
+		}
+		for _, variant := range variants {
+			if exists[variant] {
+				continue
+			}
+			exists[variant] = true
+			questions = append(questions, variant)
+		}
+	}
+	if len(questions) == 0 {
+		return
+	}
+
+	vectors, err := embedding.TextEmbeddingHandler.CalculateEmbeddings(ctx, questions)
+	if err != nil {
+		logger.Logger.Errorw("处理问题为向量错误", "err", err, "questions", questions)
+		return
+	}
+	if len(vectors) != len(questions) {
+		err = errors.New("向量数与问题数不一致")
+		return
+	}
+	ids, err := milvus.MilvusHandler.Insert(ctx, questions, vectors)
+	if err != nil {
+		logger.Logger.Errorw("写入向量数据库错误", "err", err, "questions", questions)
+		return
+	}
+	if len(ids) != len(questions) {
+		err = errors.New("向量插入数与问题数不一致")
+		return
+	}
+	knowledges := make([]*models.Knowledge, 0, len(questions))
+	for i, question := range questions {
+		knowledges = append(knowledges, &models.Knowledge{
+			Question:  question,
+			Answer:    first.Answer,
+			VectorId:  ids[i],
+			Type:      models.KnowledgeTypeQAndA,
+			GroupKey:  groupKey,
+			Tags:      first.Tags,
+			Generated: models.GeneratedPending,
+		})
+	}
+	err = new(models.Knowledge).BatchCreate(knowledges)
+	if err != nil {
+		logger.Logger.Errorw("写入db错误", "err", err, "knowledges", knowledges)
+		return
+	}
+	return len(knowledges), nil
+}
+
+// RegenVariants 删除分组内待审核的变体后重新生成
+func (s *KnowledgeService) RegenVariants(ctx context.Context, groupKey string, n int) (count int, err error) {
+	list, err := new(models.Knowledge).GetByGroupKey(groupKey)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "group_key", groupKey)
+		return
+	}
+	pending := make([]*models.Knowledge, 0)
+	for _, v := range list {
+		if v.Generated == models.GeneratedPending {
+			pending = append(pending, v)
+		}
+	}
+	if err = s.delKnowledges(ctx, pending); err != nil {
+		return
+	}
+	return s.GenVariants(ctx, groupKey, n)
+}
+
+// ReviewVariants 审核模型生成的问题 通过的标记为已确认 不通过的删除
+func (s *KnowledgeService) ReviewVariants(ctx context.Context, ids []int64, accept bool) (err error) {
+	if len(ids) == 0 {
+		err = ErrInvalidParams
+		return
+	}
+	list, err := new(models.Knowledge).BatchGetByPks(ids)
+	if err != nil {
+		logger.Logger.Errorw("查询db错误", "err", err, "ids", ids)
+		return
+	}
+	generated := make([]*models.Knowledge, 0)
+	generatedIds := make([]int64, 0)
+	for _, v := range list {
+		if v.Generated == models.GeneratedNone {
+			continue
+		}
+		generated = append(generated, v)
+		generatedIds = append(generatedIds, v.Id)
+	}
+	if len(generated) == 0 {
+		err = ErrDataNotFound
+		return
+	}
+	if !accept {
+		return s.delKnowledges(ctx, generated)
+	}
+	err = new(models.Knowledge).UpdateByPks(generatedIds, map[string]any{
+		"generated": models.GeneratedAccepted,
+	})
+	if err != nil {
+		logger.Logger.Errorw("写入db错误", "err", err, "ids", generatedIds)
+	}
+	return
+}
+
+// 批量生成变体任务参数
+type VariantJobParams struct {
+	GroupKeys []string `json:"group_keys"` // 为空时处理全部问答分组
+	N         int      `json:"n"`
+	Regen     bool     `json:"regen"` // 是否删除已有待审核变体后重新生成
+}
+
+// 批量生成问题变体任务 每个分组为一行
+func (s *KnowledgeService) variantJob(ctx context.Context, job *models.Job, progress *JobProgress) (any, error) {
+	params := new(VariantJobParams)
+	if err := json.Unmarshal([]byte(job.Params), params); err != nil {
+		return nil, err
+	}
+	groupKeys := params.GroupKeys
+	if len(groupKeys) == 0 {
+		var err error
+		groupKeys, err = new(models.Knowledge).GetGroupKeysByType(models.KnowledgeTypeQAndA)
+		if err != nil {
+			return nil, err
+		}
+	}
+	progress.SetTotal(int64(len(groupKeys)))
+
+	total := 0
+	for i, groupKey := range groupKeys {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !progress.Next() {
+			continue
+		}
+		var count int
+		var err error
+		if params.Regen {
+			count, err = s.RegenVariants(ctx, groupKey, params.N)
+		} else {
+			count, err = s.GenVariants(ctx, groupKey, params.N)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", groupKey, err)
+		}
+		total += count
+		progress.Done(i+1, err)
+	}
+	return map[string]any{
+		"generated": total,
+	}, nil
+}
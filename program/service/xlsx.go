@@ -0,0 +1,565 @@
+package service
+
+import (
+	"ai-knowledge/internal/common"
+	"ai-knowledge/internal/logger"
+	"ai-knowledge/program/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+/* excel问答导入 */
+
+const (
+	XlsxRowImported  = "imported"
+	XlsxRowSkipped   = "skipped"
+	XlsxRowDuplicate = "duplicate"
+)
+
+var (
+	columnNameReg = regexp.MustCompile(`^[A-Z]{1,3}$`)
+	reportNameReg = regexp.MustCompile(`^[0-9a-f-]{36}\.xlsx$`)
+
+	ErrReportNotFound = errors.New("report not found")
+)
+
+// 列映射 列可以是表头名称或列字母(A、B...)
+type XlsxMapping struct {
+	Sheets          []string `json:"sheets"`           // 导入的工作表 为空导入全部
+	QuestionColumns []string `json:"question_columns"` // 问题列 可多列
+	AnswerColumn    string   `json:"answer_column"`    // 答案列
+	TagColumn       string   `json:"tag_column"`       // 分类列 值作为标签 为空时使用工作表名作为标签
+	Separator       string   `json:"separator"`        // 单元格内相似问题分隔符 默认换行
+	HeaderRow       int      `json:"header_row"`       // 表头行号 默认1 小于0表示无表头
+}
+
+// excel中的一行
+type XlsxRow struct {
+	Sheet     string   `json:"sheet"`
+	Line      int      `json:"line"`
+	Questions []string `json:"questions"`
+	Answer    string   `json:"answer"`
+	Tags      []string `json:"tags"`
+}
+
+// 行处理结果
+type XlsxRowResult struct {
+	*XlsxRow
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	GroupKey string `json:"group_key"`
+}
+
+// excel导入结果
+type XlsxImportResult struct {
+	DryRun    bool             `json:"dry_run"`
+	Total     int              `json:"total"`
+	Imported  int              `json:"imported"`
+	Skipped   int              `json:"skipped"`
+	Duplicate int              `json:"duplicate"`
+	Report    string           `json:"report"` // 报告文件名 通过importReport下载
+	Rows      []*XlsxRowResult `json:"-"`
+}
+
+// 添加行结果
+func (r *XlsxImportResult) add(row *XlsxRowResult) {
+	r.Total++
+	switch row.Status {
+	case XlsxRowImported:
+		r.Imported++
+	case XlsxRowSkipped:
+		r.Skipped++
+	case XlsxRowDuplicate:
+		r.Duplicate++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// 校验映射并补全默认值
+func (m *XlsxMapping) Validate() error {
+	if len(m.QuestionColumns) == 0 || m.AnswerColumn == "" {
+		return errors.New("问题列和答案列不能为空")
+	}
+	if m.Separator == "" {
+		m.Separator = "\n"
+	}
+	if m.HeaderRow == 0 {
+		m.HeaderRow = 1
+	}
+	return nil
+}
+
+// 解析列位置 优先匹配表头名称 其次按列字母解析 返回从0开始的下标
+func resolveColumn(header []string, column string) (int, error) {
+	column = strings.TrimSpace(column)
+	for i, v := range header {
+		if strings.TrimSpace(v) == column {
+			return i, nil
+		}
+	}
+	if columnNameReg.MatchString(column) {
+		n, err := excelize.ColumnNameToNumber(column)
+		if err == nil {
+			return n - 1, nil
+		}
+	}
+	return 0, fmt.Errorf("列不存在: %s", column)
+}
+
+// ReadXlsxRows 按映射逐行读取 回调返回错误时终止读取
+func ReadXlsxRows(r io.Reader, mapping *XlsxMapping, fn func(row *XlsxRow) error) error {
+	if err := mapping.Validate(); err != nil {
+		return err
+	}
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sheets := mapping.Sheets
+	if len(sheets) == 0 {
+		sheets = f.GetSheetList()
+	}
+	for _, sheet := range sheets {
+		if err := readXlsxSheet(f, sheet, mapping, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取单个工作表
+func readXlsxSheet(f *excelize.File, sheet string, mapping *XlsxMapping, fn func(row *XlsxRow) error) error {
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		return fmt.Errorf("工作表%s读取错误: %w", sheet, err)
+	}
+	defer rows.Close()
+
+	var header []string
+	questionIdx := make([]int, 0)
+	answerIdx, tagIdx := -1, -1
+	resolve := func() error {
+		for _, v := range mapping.QuestionColumns {
+			i, err := resolveColumn(header, v)
+			if err != nil {
+				return fmt.Errorf("工作表%s: %w", sheet, err)
+			}
+			questionIdx = append(questionIdx, i)
+		}
+		var err error
+		if answerIdx, err = resolveColumn(header, mapping.AnswerColumn); err != nil {
+			return fmt.Errorf("工作表%s: %w", sheet, err)
+		}
+		if mapping.TagColumn != "" {
+			if tagIdx, err = resolveColumn(header, mapping.TagColumn); err != nil {
+				return fmt.Errorf("工作表%s: %w", sheet, err)
+			}
+		}
+		return nil
+	}
+	if mapping.HeaderRow < 0 {
+		if err := resolve(); err != nil {
+			return err
+		}
+	}
+
+	cell := func(columns []string, i int) string {
+		if i < 0 || i >= len(columns) {
+			return ""
+		}
+		return strings.TrimSpace(columns[i])
+	}
+	line := 0
+	for rows.Next() {
+		line++
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if line < mapping.HeaderRow {
+			continue
+		}
+		if line == mapping.HeaderRow {
+			header = columns
+			if err := resolve(); err != nil {
+				return err
+			}
+			continue
+		}
+		// 忽略空行
+		if strings.TrimSpace(strings.Join(columns, "")) == "" {
+			continue
+		}
+
+		row := &XlsxRow{
+			Sheet:  sheet,
+			Line:   line,
+			Answer: cell(columns, answerIdx),
+		}
+		for _, i := range questionIdx {
+			row.Questions = append(row.Questions, trimValues(strings.Split(cell(columns, i), mapping.Separator))...)
+		}
+		tag := sheet
+		if tagIdx >= 0 {
+			tag = cell(columns, tagIdx)
+		}
+		if tag != "" {
+			row.Tags = []string{strings.ReplaceAll(tag, csvTagSep, " ")}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Error()
+}
+
+// 检查行数据 去除文件内及库中已存在的问题 seen为文件内此前已导入的问题
+// groupKey不为空时该分组内的问题不算已存在 任务重启后重新导入同一行时整组替换
+func (s *KnowledgeService) checkXlsxRow(row *XlsxRow, seen map[string]string, groupKey string) *XlsxRowResult {
+	result := &XlsxRowResult{
+		XlsxRow: row,
+		Status:  XlsxRowSkipped,
+	}
+	if len(row.Questions) == 0 {
+		result.Reason = "问题为空"
+		return result
+	}
+	if row.Answer == "" {
+		result.Reason = "答案为空"
+		return result
+	}
+
+	exists := make(map[string]bool)
+	list, err := new(models.Knowledge).GetByQuestions(row.Questions)
+	if err != nil {
+		result.Reason = err.Error()
+		return result
+	}
+	for _, v := range list {
+		if groupKey == "" || v.GroupKey != groupKey {
+			exists[v.Question] = true
+		}
+	}
+	questions := make([]string, 0, len(row.Questions))
+	duplicates := make([]string, 0)
+	for _, v := range row.Questions {
+		if prev, ok := seen[v]; ok && prev != xlsxRowName(row) {
+			duplicates = append(duplicates, fmt.Sprintf("%s(与%s重复)", v, prev))
+			continue
+		}
+		if exists[v] {
+			duplicates = append(duplicates, fmt.Sprintf("%s(已存在)", v))
+			continue
+		}
+		if slices.Contains(questions, v) {
+			duplicates = append(duplicates, fmt.Sprintf("%s(本行重复)", v))
+			continue
+		}
+		questions = append(questions, v)
+	}
+	if len(questions) == 0 {
+		result.Status = XlsxRowDuplicate
+		result.Reason = strings.Join(duplicates, "；")
+		return result
+	}
+	if len(duplicates) > 0 {
+		result.Reason = "已忽略重复问题：" + strings.Join(duplicates, "；")
+	}
+	row.Questions = questions
+	result.Status = XlsxRowImported
+	return result
+}
+
+// 导入一行 每行创建一个问答分组 groupKey为空时自动生成 保存成功或校验通过后的问题才参与后续行判重
+func (s *KnowledgeService) importXlsxRow(ctx context.Context, row *XlsxRow, seen map[string]string, dryRun bool, groupKey string) *XlsxRowResult {
+	result := s.checkXlsxRow(row, seen, groupKey)
+	if result.Status != XlsxRowImported {
+		return result
+	}
+	if dryRun {
+		markXlsxSeen(row, seen)
+		return result
+	}
+	groupKey, err := s.SaveQAndA(ctx, &QAndAGroup{
+		GroupKey:  groupKey,
+		Questions: row.Questions,
+		Answer:    row.Answer,
+		Tags:      row.Tags,
+	})
+	if err != nil {
+		logger.Logger.Errorw("导入excel行错误", "err", err, "sheet", row.Sheet, "line", row.Line)
+		result.Status = XlsxRowSkipped
+		result.Reason = err.Error()
+		return result
+	}
+	result.GroupKey = groupKey
+	markXlsxSeen(row, seen)
+	return result
+}
+
+// 记录已导入的问题
+func markXlsxSeen(row *XlsxRow, seen map[string]string) {
+	for _, v := range row.Questions {
+		seen[v] = xlsxRowName(row)
+	}
+}
+
+// 行的位置说明 同时作为行的唯一标识
+func xlsxRowName(row *XlsxRow) string {
+	return fmt.Sprintf("%s第%d行", row.Sheet, row.Line)
+}
+
+// ImportXlsx 按列映射导入excel问答 并生成导入报告
+func (s *KnowledgeService) ImportXlsx(ctx context.Context, r io.Reader, mapping *XlsxMapping, dryRun bool) (*XlsxImportResult, error) {
+	result := &XlsxImportResult{
+		DryRun: dryRun,
+		Rows:   make([]*XlsxRowResult, 0),
+	}
+	seen := make(map[string]string)
+	err := ReadXlsxRows(r, mapping, func(row *XlsxRow) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		result.add(s.importXlsxRow(ctx, row, seen, dryRun, ""))
+		return nil
+	})
+	if err != nil {
+		logger.Logger.Errorw("导入excel错误", "err", err)
+		return result, err
+	}
+	result.Report, err = SaveXlsxReport(result.Rows)
+	if err != nil {
+		logger.Logger.Errorw("生成导入报告错误", "err", err)
+	}
+	return result, err
+}
+
+// excel导入任务参数
+type XlsxJobParams struct {
+	Mapping *XlsxMapping `json:"mapping"`
+	DryRun  bool         `json:"dry_run"`
+}
+
+// 异步excel导入任务 每行结果追加到行结果文件 完成后生成报告
+func (s *KnowledgeService) importXlsxJob(ctx context.Context, job *models.Job, progress *JobProgress) (any, error) {
+	params := new(XlsxJobParams)
+	if err := json.Unmarshal([]byte(job.Params), params); err != nil {
+		return nil, err
+	}
+	if params.Mapping == nil {
+		return nil, ErrInvalidParams
+	}
+	if job.Total == 0 {
+		total, err := countJobFileRows(job.File, func(r io.Reader, fn func() error) error {
+			return ReadXlsxRows(r, params.Mapping, func(*XlsxRow) error {
+				return fn()
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		progress.SetTotal(total)
+	}
+
+	// 重启前已导入的问题参与文件内判重
+	seen := make(map[string]string)
+	rowResults, err := readXlsxRowResults(jobRowsPath(job))
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range rowResults {
+		if v.Status == XlsxRowImported {
+			markXlsxSeen(v.XlsxRow, seen)
+		}
+	}
+	// 重写行结果文件 去除重复及中断时未写完的行
+	if err = writeXlsxRowResults(jobRowsPath(job), rowResults); err != nil {
+		return nil, err
+	}
+	rowsFile, err := os.OpenFile(jobRowsPath(job), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer rowsFile.Close()
+	f, err := os.Open(job.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(rowsFile)
+	err = ReadXlsxRows(f, params.Mapping, func(row *XlsxRow) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !progress.Next() {
+			return nil
+		}
+		rowResult := s.importXlsxRow(ctx, row, seen, params.DryRun, progress.GroupKey())
+		if err := enc.Encode(rowResult); err != nil {
+			return err
+		}
+		var rowErr error
+		if rowResult.Status == XlsxRowSkipped {
+			rowErr = errors.New(rowResult.Reason)
+		}
+		progress.Done(row.Line, rowErr)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 汇总全部行结果生成报告
+	result := &XlsxImportResult{
+		DryRun: params.DryRun,
+		Rows:   make([]*XlsxRowResult, 0),
+	}
+	rowResults, err = readXlsxRowResults(jobRowsPath(job))
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range rowResults {
+		result.add(v)
+	}
+	result.Report, err = SaveXlsxReport(result.Rows)
+	return result, err
+}
+
+// 读取任务行结果 文件不存在时为空 同一行在重启前后多次写入时保留最后一次结果
+func readXlsxRowResults(path string) ([]*XlsxRowResult, error) {
+	list := make([]*XlsxRowResult, 0)
+	index := make(map[string]int)
+	err := ReadJSONLFile(path, func() any {
+		return new(XlsxRowResult)
+	}, func(v any) {
+		rowResult := v.(*XlsxRowResult)
+		if rowResult.XlsxRow == nil {
+			return
+		}
+		name := xlsxRowName(rowResult.XlsxRow)
+		if i, ok := index[name]; ok {
+			list[i] = rowResult
+			return
+		}
+		index[name] = len(list)
+		list = append(list, rowResult)
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return list, err
+}
+
+// 写入任务行结果 先写临时文件再替换
+func writeXlsxRowResults(path string, list []*XlsxRowResult) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, v := range list {
+		if err = enc.Encode(v); err != nil {
+			break
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadJSONLFile 逐行读取jsonl文件 末尾中断时未写完的行忽略
+func ReadJSONLFile(path string, newValue func() any, fn func(v any)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		v := newValue()
+		err := dec.Decode(v)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(v)
+	}
+}
+
+// 报告目录
+func reportDir() string {
+	return filepath.Join(common.GetRootDir(), common.ReportDir)
+}
+
+// SaveXlsxReport 生成导入报告 返回报告文件名
+func SaveXlsxReport(rows []*XlsxRowResult) (name string, err error) {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+	err = f.SetSheetRow(sheet, "A1", &[]any{"工作表", "行号", "状态", "问题", "答案", "标签", "分组标识", "说明"})
+	if err != nil {
+		return
+	}
+	for i, v := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		err = f.SetSheetRow(sheet, cell, &[]any{
+			v.Sheet, v.Line, v.Status,
+			strings.Join(v.Questions, "\n"), v.Answer, strings.Join(v.Tags, csvTagSep),
+			v.GroupKey, v.Reason,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	if err = os.MkdirAll(reportDir(), 0755); err != nil {
+		return
+	}
+	name = uuid.NewString() + ".xlsx"
+	err = f.SaveAs(filepath.Join(reportDir(), name))
+	return
+}
+
+// GetReportPath 获取报告文件路径
+func GetReportPath(name string) (string, error) {
+	if !reportNameReg.MatchString(name) {
+		return "", ErrInvalidParams
+	}
+	path := filepath.Join(reportDir(), name)
+	ok, err := common.PathExists(path)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrReportNotFound
+	}
+	return path, nil
+}
+
+// 报告下载文件名
+func ReportFilename() string {
+	return fmt.Sprintf("import-report-%s.xlsx", time.Now().Format("20060102150405"))
+}
@@ -5,8 +5,252 @@ CREATE TABLE `knowledge` (
   `text` text COLLATE utf8mb4_general_ci COMMENT '知识内容',
   `vector_id` bigint DEFAULT NULL COMMENT '向量id',
   `type` tinyint NOT NULL DEFAULT '0' COMMENT '类型 0问答 1纯知识',
+  `group_key` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '分组标识',
+  `tags` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '标签 多个使用,分隔',
+  `generated` tinyint NOT NULL DEFAULT '0' COMMENT '问题来源 0人工录入 1模型生成待审核 2模型生成已确认',
+  `document_id` bigint NOT NULL DEFAULT '0' COMMENT '来源文档id',
+  `position` int NOT NULL DEFAULT '0' COMMENT '文档内切片位置',
+  `section` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '文档内所属章节',
   `created_at` bigint DEFAULT NULL COMMENT '创建时间',
   `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
   PRIMARY KEY (`id`),
-  KEY `idx_vector_id` (`vector_id`)
+  KEY `idx_vector_id` (`vector_id`),
+  KEY `idx_group_key` (`group_key`),
+  KEY `idx_document_id` (`document_id`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='知识库';
+
+
+CREATE TABLE `document` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `title` varchar(255) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '标题',
+  `origin` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '来源 文件名或地址',
+  `checksum` char(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '内容sha256',
+  `version` int NOT NULL DEFAULT '1' COMMENT '版本 每次替换内容加1',
+  `chunk_count` int NOT NULL DEFAULT '0' COMMENT '切片数',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_origin` (`origin`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='源文档';
+
+CREATE TABLE `job` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `type` varchar(32) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '任务类型',
+  `status` tinyint NOT NULL DEFAULT '0' COMMENT '状态 0等待 1执行中 2成功 3失败 4已取消',
+  `params` text COLLATE utf8mb4_general_ci COMMENT '任务参数json',
+  `file` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '任务文件路径',
+  `total` bigint NOT NULL DEFAULT '0' COMMENT '总行数',
+  `processed` bigint NOT NULL DEFAULT '0' COMMENT '已处理行数',
+  `succeeded` bigint NOT NULL DEFAULT '0' COMMENT '成功行数',
+  `failed` bigint NOT NULL DEFAULT '0' COMMENT '失败行数',
+  `errors` mediumtext COLLATE utf8mb4_general_ci COMMENT '行错误json',
+  `result` mediumtext COLLATE utf8mb4_general_ci COMMENT '任务结果json',
+  `message` varchar(1024) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '任务错误信息',
+  `finished_at` bigint NOT NULL DEFAULT '0' COMMENT '完成时间',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_status` (`status`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='异步任务';
+
+CREATE TABLE `faq_candidate` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `knowledge_id` bigint NOT NULL DEFAULT '0' COMMENT '来源知识切片id',
+  `document_id` bigint NOT NULL DEFAULT '0' COMMENT '来源文档id',
+  `position` int NOT NULL DEFAULT '0' COMMENT '来源切片在文档中的序号',
+  `question` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '问题',
+  `answer` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '答案',
+  `status` tinyint NOT NULL DEFAULT '0' COMMENT '状态 0待审核 1已采纳 2已拒绝',
+  `group_key` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '采纳后的问答分组',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_status` (`status`),
+  KEY `idx_document_id` (`document_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='候选问答';
+
+CREATE TABLE `conversation` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `knowledge_base` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '知识库名称',
+  `title` varchar(255) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '标题 首个问题',
+  `summary` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '较早对话的摘要',
+  `summarized_id` bigint NOT NULL DEFAULT '0' COMMENT '已总结到的消息id',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='多轮对话会话';
+
+CREATE TABLE `conversation_message` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `conversation_id` bigint NOT NULL DEFAULT '0' COMMENT '会话id',
+  `role` varchar(16) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '角色 user assistant',
+  `content` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '消息内容',
+  `question` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '改写后的独立问题',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_conversation_id` (`conversation_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='会话消息';
+
+CREATE TABLE `usage_record` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `request_id` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '请求id 同一请求的记录相同',
+  `knowledge_base` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '知识库名称',
+  `api_key` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '调用方密钥 脱敏',
+  `endpoint` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '接口路径',
+  `type` varchar(16) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '类型 llm embedding',
+  `provider` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '实际响应的模型服务',
+  `model` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '模型',
+  `prompt_tokens` int NOT NULL DEFAULT '0' COMMENT '输入token数',
+  `completion_tokens` int NOT NULL DEFAULT '0' COMMENT '输出token数',
+  `total_tokens` int NOT NULL DEFAULT '0' COMMENT '总token数',
+  `calls` int NOT NULL DEFAULT '0' COMMENT '调用次数',
+  `cost` decimal(20,8) NOT NULL DEFAULT '0.00000000' COMMENT '估算费用',
+  `day` varchar(10) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '日期',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_day` (`day`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='token用量记录';
+
+CREATE TABLE `query_log` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `knowledge_base` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '知识库名称',
+  `conversation_id` bigint NOT NULL DEFAULT '0' COMMENT '多轮对话会话id',
+  `question` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '问题',
+  `standalone_question` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '多轮对话改写后的问题',
+  `answer` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '答案',
+  `answer_source` varchar(16) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '答案来源 retrieved generated cached',
+  `knowledge_ids` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '检索到的知识id 多个使用,分隔',
+  `channel` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '调用渠道',
+  `fallback` tinyint NOT NULL DEFAULT '0' COMMENT '是否兜底回答 0否 1是',
+  `latency` bigint NOT NULL DEFAULT '0' COMMENT '耗时(毫秒)',
+  `error` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '错误信息',
+  `group_key` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '答案转为问答后的分组',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_created_at` (`created_at`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='问答查询记录';
+
+CREATE TABLE `query_log_hit` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `query_log_id` bigint NOT NULL DEFAULT '0' COMMENT '查询记录id',
+  `knowledge_id` bigint NOT NULL DEFAULT '0' COMMENT '知识id',
+  `position` int NOT NULL DEFAULT '0' COMMENT '检索结果中的位置 从1开始',
+  `similarity` float NOT NULL DEFAULT '0' COMMENT '相似度',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_created_at` (`created_at`),
+  KEY `idx_knowledge_id` (`knowledge_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='查询检索到的知识';
+
+CREATE TABLE `answer_feedback` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `query_log_id` bigint NOT NULL DEFAULT '0' COMMENT '查询记录id',
+  `rating` tinyint NOT NULL DEFAULT '0' COMMENT '评价 1有帮助 -1没有帮助',
+  `comment` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '评价内容',
+  `status` tinyint NOT NULL DEFAULT '0' COMMENT '状态 0待处理 1已转为问答 2已忽略',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_query_log_id` (`query_log_id`),
+  KEY `idx_rating_status` (`rating`, `status`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='答案评价';
+
+CREATE TABLE `eval_set` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '名称',
+  `description` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '说明',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='评测集';
+
+CREATE TABLE `eval_case` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `set_id` bigint NOT NULL DEFAULT '0' COMMENT '评测集id',
+  `question` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '问题',
+  `expected_ids` varchar(1024) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '期望检索到的知识id 多个使用,分隔',
+  `expected_keys` varchar(2048) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '期望知识的分组标识 文档切片为文档id及序号 多个使用,分隔',
+  `expected_answer` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '期望答案',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_set_id` (`set_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='评测用例';
+
+CREATE TABLE `eval_run` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `set_id` bigint NOT NULL DEFAULT '0' COMMENT '评测集id',
+  `job_id` bigint NOT NULL DEFAULT '0' COMMENT '任务id 命令行运行时为0',
+  `label` varchar(255) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '运行说明',
+  `config` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '运行参数及配置快照 json',
+  `status` tinyint NOT NULL DEFAULT '0' COMMENT '状态 0执行中 1已完成 2失败',
+  `cases` int NOT NULL DEFAULT '0' COMMENT '用例数',
+  `recall` double NOT NULL DEFAULT '0' COMMENT '平均recall@k',
+  `mrr` double NOT NULL DEFAULT '0' COMMENT 'MRR',
+  `ndcg` double NOT NULL DEFAULT '0' COMMENT '平均nDCG@k',
+  `answer_similarity` double NOT NULL DEFAULT '0' COMMENT '平均答案相似度',
+  `latency` bigint NOT NULL DEFAULT '0' COMMENT '平均耗时(毫秒)',
+  `message` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '说明',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_set_id` (`set_id`),
+  KEY `idx_job_id` (`job_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='评测运行';
+
+CREATE TABLE `eval_result` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `run_id` bigint NOT NULL DEFAULT '0' COMMENT '运行id',
+  `case_id` bigint NOT NULL DEFAULT '0' COMMENT '用例id',
+  `question` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '问题',
+  `retrieved_ids` varchar(1024) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '检索到的知识id 按排名',
+  `recall` double NOT NULL DEFAULT '0' COMMENT 'recall@k',
+  `rr` double NOT NULL DEFAULT '0' COMMENT '首个相关知识排名的倒数',
+  `ndcg` double NOT NULL DEFAULT '0' COMMENT 'nDCG@k',
+  `answer` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '生成的答案',
+  `answer_similarity` double NOT NULL DEFAULT '0' COMMENT '答案相似度',
+  `latency` bigint NOT NULL DEFAULT '0' COMMENT '耗时(毫秒)',
+  `error` varchar(512) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '错误信息',
+  `missing` varchar(1024) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '已不存在的期望知识 多个使用,分隔',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_run_id` (`run_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='评测结果';
+
+CREATE TABLE `knowledge_gap` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `job_id` bigint NOT NULL DEFAULT '0' COMMENT '分析任务id',
+  `topic` varchar(255) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '主题',
+  `description` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '说明',
+  `samples` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '示例问题 json数组',
+  `queries` int NOT NULL DEFAULT '0' COMMENT '查询次数',
+  `questions` int NOT NULL DEFAULT '0' COMMENT '不同问题数',
+  `status` tinyint NOT NULL DEFAULT '0' COMMENT '状态 0待处理 1已转为问答 2已忽略',
+  `group_key` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '转为问答后的分组',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  KEY `idx_job_id` (`job_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='知识缺口';
+
+CREATE TABLE `knowledge_conflict` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `group_key_a` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '知识A分组',
+  `knowledge_id_a` bigint NOT NULL DEFAULT '0' COMMENT '知识A id',
+  `content_a` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '检查时知识A的内容',
+  `group_key_b` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '知识B分组',
+  `knowledge_id_b` bigint NOT NULL DEFAULT '0' COMMENT '知识B id',
+  `content_b` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '检查时知识B的内容',
+  `similarity` float NOT NULL DEFAULT '0' COMMENT '相似度',
+  `conflict` tinyint NOT NULL DEFAULT '0' COMMENT '是否矛盾 0否 1是',
+  `explanation` text CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci COMMENT '矛盾说明',
+  `digest` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '两条内容的校验和',
+  `status` tinyint NOT NULL DEFAULT '0' COMMENT '状态 0待处理 1已忽略',
+  `created_at` bigint DEFAULT NULL COMMENT '创建时间',
+  `updated_at` bigint DEFAULT NULL COMMENT '修改时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_digest` (`digest`),
+  KEY `idx_group_key_a` (`group_key_a`),
+  KEY `idx_group_key_b` (`group_key_b`),
+  KEY `idx_conflict_status` (`conflict`, `status`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='知识矛盾检查结果';